@@ -14,6 +14,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"math"
@@ -41,10 +42,11 @@ import (
 var (
 	// DefaultModule set default configuration for the Module
 	DefaultModule = Module{
-		HTTP: DefaultHTTPProbe,
-		TCP:  DefaultTCPProbe,
-		ICMP: DefaultICMPProbe,
-		DNS:  DefaultDNSProbe,
+		HTTP:   DefaultHTTPProbe,
+		TCP:    DefaultTCPProbe,
+		ICMP:   DefaultICMPProbe,
+		DNS:    DefaultDNSProbe,
+		ETHRPC: DefaultETHRPCProbe,
 	}
 
 	// DefaultHTTPProbe set default value for HTTPProbe
@@ -71,6 +73,17 @@ var (
 		TTL:                DefaultICMPTTL,
 	}
 
+	// DefaultETHRPCProbe set default value for ETHRPCProbe
+	DefaultETHRPCProbe = ETHRPCProbe{
+		ClientIdleTTL: 5 * time.Minute,
+		RetryBackoff: ETHRPCRetryBackoff{
+			RateLimited: time.Second,
+			ServerError: 200 * time.Millisecond,
+			Network:     50 * time.Millisecond,
+			Other:       200 * time.Millisecond,
+		},
+	}
+
 	// DefaultDNSProbe set default value for DNSProbe
 	DefaultDNSProbe = DNSProbe{
 		IPProtocolFallback: true,
@@ -192,6 +205,9 @@ func MustNewRegexp(s string) Regexp {
 	return re
 }
 
+// metricPrefixRE matches the prefixes a metric name may start with.
+var metricPrefixRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
 type Module struct {
 	Prober  string        `yaml:"prober,omitempty"`
 	Timeout time.Duration `yaml:"timeout,omitempty"`
@@ -204,6 +220,11 @@ type Module struct {
 	BTCRPC  BTCRPCProbe   `yaml:"btcrpc,omitempty"`
 	JSON    JSONProbe     `yaml:"json,omitempty"`
 	GRAPHQL GRAPHQLProbe  `yaml:"graphql,omitempty"`
+
+	// Prepended to the names of the metrics registered by the prober, e.g.
+	// "mychain_". probe_success and probe_duration_seconds keep their
+	// names.
+	MetricPrefix string `yaml:"metric_prefix,omitempty"`
 }
 
 type HTTPProbe struct {
@@ -238,9 +259,61 @@ type GRPCProbe struct {
 }
 
 type ETHRPCProbe struct {
+	// How batched requests are counted in probe_rpc_billable_requests: one per
+	// method in the batch ("per_method", the default) or one per HTTP request
+	// ("per_request").
+	BillingModel string `yaml:"billing_model,omitempty"`
+	// Form of the addresses used as label values: EIP-55 "checksum" (the
+	// default) or "lowercase".
+	AddressLabelFormat string `yaml:"address_label_format,omitempty"`
+	// Headers sent with every request, e.g. a provider API key. Probe params
+	// of the form header=Name:Value are added to these.
+	Headers map[string]config.Secret `yaml:"headers,omitempty"`
+	// Total size of the responses a single probe may read over HTTP before it
+	// is aborted. 0 means no limit.
+	ResponseBytesLimit units.Base2Bytes `yaml:"response_bytes_limit,omitempty"`
+	// TLS settings for https:// and wss:// targets.
+	TLSConfig config.TLSConfig `yaml:"tls_config,omitempty"`
+	// Names of the TLS 1.0-1.2 cipher suites allowed, as listed by Go's
+	// crypto/tls. TLS 1.3 suites are not configurable. Empty means Go's
+	// defaults.
+	CipherSuites []string `yaml:"cipher_suites,omitempty"`
+	// How long the HTTP connections to a target are kept open after a probe,
+	// to be reused by the next probe of the same target and headers. 0
+	// closes them after each probe.
+	ClientIdleTTL time.Duration `yaml:"client_idle_ttl,omitempty"`
+	// Maximum number of requests per second sent to a target host over
+	// HTTP, counted by billing_model. The budget is shared by all probes of
+	// modules with the same limits. 0 means no limit.
+	RateLimit float64 `yaml:"rate_limit,omitempty"`
+	// Number of requests that may be sent at once before rate_limit
+	// applies. Defaults to rate_limit rounded up.
+	RateLimitBurst int `yaml:"rate_limit_burst,omitempty"`
+	// Backoff before retrying a failed call, by class of failure, when the
+	// retries probe param is set.
+	RetryBackoff ETHRPCRetryBackoff `yaml:"retry_backoff,omitempty"`
+}
+
+// ETHRPCRetryBackoff is the initial backoff of the retries of each class of
+// failure. It doubles after each attempt, except for network errors which
+// are retried at a steady pace.
+type ETHRPCRetryBackoff struct {
+	// HTTP 429 responses, unless they carry a Retry-After header, which is
+	// then waited for instead.
+	RateLimited time.Duration `yaml:"rate_limited,omitempty"`
+	// HTTP 5xx responses.
+	ServerError time.Duration `yaml:"server_error,omitempty"`
+	// Failures to connect or to read the response.
+	Network time.Duration `yaml:"network,omitempty"`
+	// Any other failure, e.g. a JSON-RPC error.
+	Other time.Duration `yaml:"other,omitempty"`
 }
 
 type BTCRPCProbe struct {
+	// Credentials for the node's RPC interface. When neither is set, the
+	// user and pass probe params are used instead.
+	User     string        `yaml:"user,omitempty"`
+	Password config.Secret `yaml:"password,omitempty"`
 }
 
 type JSONProbe struct {
@@ -319,6 +392,9 @@ func (s *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal((*plain)(s)); err != nil {
 		return err
 	}
+	if s.MetricPrefix != "" && !metricPrefixRE.MatchString(s.MetricPrefix) {
+		return fmt.Errorf("metric_prefix '%s' is not valid, must match %s", s.MetricPrefix, metricPrefixRE)
+	}
 	return nil
 }
 
@@ -374,6 +450,58 @@ func (s *GRPCProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ETHRPCProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*s = DefaultETHRPCProbe
+	type plain ETHRPCProbe
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	switch s.BillingModel {
+	case "", "per_method", "per_request":
+	default:
+		return fmt.Errorf("billing_model '%s' is not valid, must be per_method or per_request", s.BillingModel)
+	}
+	switch s.AddressLabelFormat {
+	case "", "checksum", "lowercase":
+	default:
+		return fmt.Errorf("address_label_format '%s' is not valid, must be checksum or lowercase", s.AddressLabelFormat)
+	}
+	if _, err := CipherSuiteIDs(s.CipherSuites); err != nil {
+		return err
+	}
+	if s.ClientIdleTTL < 0 {
+		return fmt.Errorf("client_idle_ttl '%s' is not valid, must not be negative", s.ClientIdleTTL)
+	}
+	if s.RateLimit < 0 || s.RateLimitBurst < 0 {
+		return fmt.Errorf("rate_limit and rate_limit_burst must not be negative")
+	}
+	b := s.RetryBackoff
+	if b.RateLimited < 0 || b.ServerError < 0 || b.Network < 0 || b.Other < 0 {
+		return fmt.Errorf("retry_backoff durations must not be negative")
+	}
+	return nil
+}
+
+// CipherSuiteIDs maps cipher suite names as listed by crypto/tls to their IDs.
+func CipherSuiteIDs(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		found := false
+		for _, cs := range tls.CipherSuites() {
+			if cs.Name == name {
+				ids = append(ids, cs.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("cipher suite '%s' is not a supported cipher suite", name)
+		}
+	}
+	return ids, nil
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (s *DNSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*s = DefaultDNSProbe
@@ -100,6 +100,30 @@ func TestLoadBadConfigs(t *testing.T) {
 			input: "testdata/invalid-http-body-config.yml",
 			want:  `error parsing config file: setting body and body_file both are not allowed`,
 		},
+		{
+			input: "testdata/invalid-ethrpc-billing-model.yml",
+			want:  "error parsing config file: billing_model 'per_call' is not valid, must be per_method or per_request",
+		},
+		{
+			input: "testdata/invalid-ethrpc-address-label-format.yml",
+			want:  "error parsing config file: address_label_format 'upper' is not valid, must be checksum or lowercase",
+		},
+		{
+			input: "testdata/invalid-ethrpc-cipher-suite.yml",
+			want:  "error parsing config file: cipher suite 'TLS_RSA_WITH_RC4_128_SHA' is not a supported cipher suite",
+		},
+		{
+			input: "testdata/invalid-ethrpc-client-idle-ttl.yml",
+			want:  "error parsing config file: client_idle_ttl '-1m0s' is not valid, must not be negative",
+		},
+		{
+			input: "testdata/invalid-ethrpc-retry-backoff.yml",
+			want:  "error parsing config file: retry_backoff durations must not be negative",
+		},
+		{
+			input: "testdata/invalid-metric-prefix.yml",
+			want:  "error parsing config file: metric_prefix 'my-chain_' is not valid, must match ^[a-zA-Z_:][a-zA-Z0-9_:]*$",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.input, func(t *testing.T) {
@@ -9,6 +9,7 @@ require (
 	github.com/btcsuite/btcd v0.24.0
 	github.com/ethereum/go-ethereum v1.13.12
 	github.com/go-kit/log v0.2.1
+	github.com/gorilla/websocket v1.5.0
 	github.com/jmespath/go-jmespath v0.4.0
 	github.com/miekg/dns v1.1.57
 	github.com/prometheus/client_golang v1.18.0
@@ -17,6 +18,7 @@ require (
 	github.com/prometheus/exporter-toolkit v0.11.0
 	golang.org/x/net v0.21.0
 	google.golang.org/grpc v1.60.1
+	google.golang.org/protobuf v1.32.0
 	gopkg.in/yaml.v2 v2.4.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -44,7 +46,6 @@ require (
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/holiman/uint256 v1.2.4 // indirect
 	github.com/jpillora/backoff v1.0.0 // indirect
 	github.com/mmcloughlin/addchain v0.4.0 // indirect
@@ -65,6 +66,5 @@ require (
 	golang.org/x/tools v0.15.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231002182017-d307bd883b97 // indirect
-	google.golang.org/protobuf v1.32.0 // indirect
 	rsc.io/tmplfunc v0.0.3 // indirect
 )
@@ -14,6 +14,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html"
@@ -26,7 +27,9 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log/level"
@@ -54,6 +57,7 @@ var (
 	historyLimit   = kingpin.Flag("history.limit", "The maximum amount of items to keep in the history.").Default("100").Uint()
 	externalURL    = kingpin.Flag("web.external-url", "The URL under which Blackbox exporter is externally reachable (for example, if Blackbox exporter is served via a reverse proxy). Used for generating relative and absolute links back to Blackbox exporter itself. If the URL has a path portion, it will be used to prefix all HTTP endpoints served by Blackbox exporter. If omitted, relevant URL components will be derived automatically.").PlaceHolder("<url>").String()
 	routePrefix    = kingpin.Flag("web.route-prefix", "Prefix for the internal routes of web endpoints. Defaults to path of --web.external-url.").PlaceHolder("<path>").String()
+	drainTimeout   = kingpin.Flag("web.shutdown-drain-timeout", "How long to wait for in-flight probes to finish on shutdown. New probes are rejected with 503 meanwhile.").Default("30s").Duration()
 	toolkitFlags   = webflag.AddFlags(kingpin.CommandLine, ":9115")
 
 	moduleUnknownCounter = promauto.NewCounter(prometheus.CounterOpts{
@@ -64,6 +68,7 @@ var (
 
 func init() {
 	prometheus.MustRegister(version.NewCollector("blackbox_exporter"))
+	prometheus.MustRegister(prober.RateLimiterCollector())
 }
 
 func main() {
@@ -177,17 +182,21 @@ func run() int {
 				http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
 			}
 		})
-	http.Handle(path.Join(*routePrefix, "/metrics"), promhttp.Handler())
+	http.Handle(path.Join(*routePrefix, "/metrics"), promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	))
 	http.HandleFunc(path.Join(*routePrefix, "/-/healthy"), func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Healthy"))
 	})
-	http.HandleFunc(path.Join(*routePrefix, "/probe"), func(w http.ResponseWriter, r *http.Request) {
+	drainer := newProbeDrainer()
+	http.Handle(path.Join(*routePrefix, "/probe"), drainer.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sc.Lock()
 		conf := sc.C
 		sc.Unlock()
 		prober.Handler(w, r, conf, logger, rh, *timeoutOffset, nil, moduleUnknownCounter, logLevelProber)
-	})
+	})))
 	http.HandleFunc(*routePrefix, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		w.Write([]byte(`<html>
@@ -278,7 +287,14 @@ func run() int {
 	for {
 		select {
 		case <-term:
-			level.Info(logger).Log("msg", "Received SIGTERM, exiting gracefully...")
+			level.Info(logger).Log("msg", "Received SIGTERM, draining in-flight probes...", "timeout", *drainTimeout)
+			if !drainer.drain(*drainTimeout) {
+				level.Warn(logger).Log("msg", "Timed out waiting for in-flight probes")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			srv.Shutdown(ctx)
+			cancel()
+			level.Info(logger).Log("msg", "Exiting gracefully...")
 			return 0
 		case <-srvc:
 			return 1
@@ -287,6 +303,63 @@ func run() int {
 
 }
 
+// probeDrainer tracks in-flight probes so that shutdown can wait for them,
+// while rejecting new ones.
+type probeDrainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+}
+
+func newProbeDrainer() *probeDrainer {
+	return &probeDrainer{idle: make(chan struct{})}
+}
+
+// wrap returns a handler that answers 503 once draining has started and
+// otherwise calls next, accounting for it as in-flight.
+func (d *probeDrainer) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		d.inFlight++
+		d.mu.Unlock()
+		defer d.done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *probeDrainer) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// drain stops accepting probes and waits up to timeout for the in-flight
+// ones to finish. It reports whether they all did.
+func (d *probeDrainer) drain(timeout time.Duration) bool {
+	d.mu.Lock()
+	d.draining = true
+	inFlight := d.inFlight
+	d.mu.Unlock()
+	if inFlight == 0 {
+		return true
+	}
+	select {
+	case <-d.idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 func startsOrEndsWithQuote(s string) bool {
 	return strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") ||
 		strings.HasSuffix(s, "\"") || strings.HasSuffix(s, "'")
@@ -13,7 +13,12 @@
 
 package main
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
 
 func TestComputeExternalURL(t *testing.T) {
 	tests := []struct {
@@ -67,3 +72,68 @@ func TestComputeExternalURL(t *testing.T) {
 		}
 	}
 }
+
+func TestProbeDrainer(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	d := newProbeDrainer()
+	h := d.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("probed"))
+	}))
+
+	inFlight := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		h.ServeHTTP(inFlight, httptest.NewRequest("GET", "/probe", nil))
+		close(finished)
+	}()
+	<-started
+
+	drained := make(chan bool)
+	go func() {
+		drained <- d.drain(5 * time.Second)
+	}()
+	// Wait for draining to start before sending a new probe.
+	for {
+		d.mu.Lock()
+		draining := d.draining
+		d.mu.Unlock()
+		if draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rejected := httptest.NewRecorder()
+	h.ServeHTTP(rejected, httptest.NewRequest("GET", "/probe", nil))
+	if rejected.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected a new probe to be rejected with 503 while draining, got %d", rejected.Code)
+	}
+
+	close(release)
+	if !<-drained {
+		t.Errorf("expected drain to report the in-flight probe as finished")
+	}
+	<-finished
+	if inFlight.Code != http.StatusOK || inFlight.Body.String() != "probed" {
+		t.Errorf("expected the in-flight probe to complete, got %d %q", inFlight.Code, inFlight.Body.String())
+	}
+}
+
+func TestProbeDrainerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	d := newProbeDrainer()
+	h := d.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/probe", nil))
+	<-started
+	if d.drain(50 * time.Millisecond) {
+		t.Errorf("expected drain to time out with a probe still in flight")
+	}
+}
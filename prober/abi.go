@@ -0,0 +1,331 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// abiComponent is a numeric leaf of a decoded ABI output.
+type abiComponent struct {
+	// Name is the dot separated path of the component, made of the output
+	// and tuple field names, or their index where unnamed, and array
+	// indexes.
+	Name string
+	// Type is the ABI type of the leaf, e.g. int256.
+	Type  string
+	Value *big.Int
+}
+
+// isScalarABIOutput reports whether outputs is a single value that is not a
+// tuple or an array.
+func isScalarABIOutput(outputs abi.Arguments) bool {
+	if len(outputs) != 1 {
+		return false
+	}
+	switch outputs[0].Type.T {
+	case abi.TupleTy, abi.SliceTy, abi.ArrayTy:
+		return false
+	}
+	return true
+}
+
+// flattenABIOutputs decodes data as outputs and returns its integer and bool
+// leaves, walking tuples and arrays. Other types, such as addresses and
+// bytes, are left out. The name of a single output is omitted from paths.
+func flattenABIOutputs(outputs abi.Arguments, data []byte) ([]abiComponent, error) {
+	values, err := outputs.UnpackValues(data)
+	if err != nil {
+		return nil, err
+	}
+	var components []abiComponent
+	for i, output := range outputs {
+		name := output.Name
+		if len(outputs) == 1 {
+			name = ""
+		} else if name == "" {
+			name = strconv.Itoa(i)
+		}
+		components = flattenABIValue(components, name, output.Type, reflect.ValueOf(values[i]))
+	}
+	return components, nil
+}
+
+func flattenABIValue(components []abiComponent, name string, t abi.Type, v reflect.Value) []abiComponent {
+	join := func(elem string) string {
+		if name == "" {
+			return elem
+		}
+		return name + "." + elem
+	}
+	switch t.T {
+	case abi.TupleTy:
+		for i, elem := range t.TupleElems {
+			elemName := t.TupleRawNames[i]
+			if elemName == "" {
+				elemName = strconv.Itoa(i)
+			}
+			components = flattenABIValue(components, join(elemName), *elem, v.Field(i))
+		}
+	case abi.SliceTy, abi.ArrayTy:
+		for i := 0; i < v.Len(); i++ {
+			components = flattenABIValue(components, join(strconv.Itoa(i)), *t.Elem, v.Index(i))
+		}
+	case abi.IntTy, abi.UintTy:
+		n := new(big.Int)
+		switch x := v.Interface().(type) {
+		case *big.Int:
+			n.Set(x)
+		default:
+			if t.T == abi.IntTy {
+				n.SetInt64(v.Int())
+			} else {
+				n.SetUint64(v.Uint())
+			}
+		}
+		components = append(components, abiComponent{Name: name, Type: t.String(), Value: n})
+	case abi.BoolTy:
+		n := new(big.Int)
+		if v.Bool() {
+			n.SetInt64(1)
+		}
+		components = append(components, abiComponent{Name: name, Type: t.String(), Value: n})
+	}
+	return components
+}
+
+// parseContractCall parses a call param of the contract_call module,
+// ContractName|ContractAddress|AbiJson[|Args], and packs its call data. The
+// ABI must hold a single method with at least one output. Arguments are
+// given comma separated in the fourth field, or one per field from the
+// fourth on, e.g. for strings holding commas. The contract name is returned
+// even if the spec is invalid.
+func parseContractCall(callParam string) (ValidCallParam, []byte, error) {
+	p := strings.Split(callParam, "|")
+	call := ValidCallParam{ContractName: p[0]}
+	if len(p) < 3 {
+		return call, nil, errors.New("malformed call spec, need at least ContractName|ContractAddress|AbiJson")
+	}
+	call.ContractAddress = p[1]
+	abiObj, err := abi.JSON(strings.NewReader(p[2]))
+	if err != nil {
+		return call, nil, errors.New("Abi json decode failed, " + err.Error())
+	}
+	if len(abiObj.Methods) != 1 {
+		return call, nil, errors.New("Only support one method")
+	}
+	var def abi.Method
+	for n, m := range abiObj.Methods {
+		call.MethodName, def = n, m
+	}
+	if len(def.Outputs) == 0 {
+		return call, nil, errors.New("Need at least one method output")
+	}
+	call.OutputType = def.Outputs[0].Type.String()
+	call.Outputs = def.Outputs
+
+	var args []string
+	if len(p) > 4 {
+		args = p[3:]
+		call.MethodArgs = strings.Join(p[3:], ",")
+	} else if len(p) == 4 && p[3] != "" {
+		call.MethodArgs = p[3]
+		args = strings.Split(p[3], ",")
+	}
+	if len(args) != len(def.Inputs) {
+		return call, nil, fmt.Errorf("malformed call spec, %s takes %d arguments, got %d", call.MethodName, len(def.Inputs), len(args))
+	}
+	var values []interface{}
+	for i, arg := range def.Inputs {
+		v, err := parseABIArg(arg.Type, args[i])
+		if err != nil {
+			return call, nil, fmt.Errorf("malformed call spec, argument %d of %s: %w", i+1, call.MethodName, err)
+		}
+		values = append(values, v)
+	}
+	callData, err := abiObj.Pack(call.MethodName, values...)
+	if err != nil {
+		return call, nil, errors.New("abi pack failed, " + err.Error())
+	}
+	return call, callData, nil
+}
+
+// parseContractCallAssert parses the assert param of the contract_call
+// module, which refers to call results by contract name, or by
+// contractName.methodName when a contract is called more than once.
+func parseContractCallAssert(assert string, calls []ValidCallParam) (ast.Expr, error) {
+	names := make(map[string]bool)
+	for _, c := range calls {
+		names[c.ContractName] = true
+		names[c.ContractName+"."+c.MethodName] = true
+	}
+	return parseExpr(assert, names)
+}
+
+// scaleExprVars are the variables of the scale param of the contract_call
+// module, the raw integer result.
+var scaleExprVars = map[string]bool{"value": true}
+
+// parseABIArg converts a call argument given as a string to the Go value
+// abi.Pack expects for t. Integers may be decimal or 0x prefixed hex, bytes
+// are 0x prefixed hex.
+func parseABIArg(t abi.Type, s string) (interface{}, error) {
+	switch t.T {
+	case abi.AddressTy:
+		if !common.IsHexAddress(s) {
+			return nil, fmt.Errorf("'%s' is not a valid address", s)
+		}
+		return common.HexToAddress(s), nil
+	case abi.IntTy, abi.UintTy:
+		n, ok := new(big.Int).SetString(s, 0)
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not a valid %s", s, t)
+		}
+		if t.T == abi.UintTy && (n.Sign() < 0 || n.BitLen() > t.Size) {
+			return nil, fmt.Errorf("'%s' overflows %s", s, t)
+		}
+		if t.T == abi.IntTy {
+			limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+			if n.Cmp(limit) >= 0 || n.Cmp(new(big.Int).Neg(limit)) < 0 {
+				return nil, fmt.Errorf("'%s' overflows %s", s, t)
+			}
+		}
+		// Sizes up to 64 bits are packed from the matching Go integer type.
+		if t.Size > 64 {
+			return n, nil
+		}
+		v := reflect.New(t.GetType()).Elem()
+		if t.T == abi.IntTy {
+			v.SetInt(n.Int64())
+		} else {
+			v.SetUint(n.Uint64())
+		}
+		return v.Interface(), nil
+	case abi.BoolTy:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid bool", s)
+		}
+		return b, nil
+	case abi.FixedBytesTy:
+		b, err := hexutil.Decode(s)
+		if err != nil || len(b) > t.Size {
+			return nil, fmt.Errorf("'%s' is not a valid %s", s, t)
+		}
+		// Shorter values are right padded, as Solidity does.
+		v := reflect.New(t.GetType()).Elem()
+		reflect.Copy(v, reflect.ValueOf(b))
+		return v.Interface(), nil
+	case abi.BytesTy:
+		b, err := hexutil.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid bytes", s)
+		}
+		return b, nil
+	case abi.StringTy:
+		return s, nil
+	}
+	return nil, fmt.Errorf("arguments of type %s are not supported", t)
+}
+
+var (
+	// revertSelector is the selector of Error(string), the payload of
+	// require and revert with a reason.
+	revertSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+	// panicSelector is the selector of Panic(uint256), the payload of
+	// failed asserts, overflows and the like since Solidity 0.8.
+	panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+)
+
+// panicReasons describes the codes of Panic(uint256).
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assert failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on an empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to a zero initialized function",
+}
+
+// revertReason decodes the revert data of a call: the reason of an
+// Error(string), or the code and meaning of a Panic(uint256). ok is false for
+// custom errors and data that is neither.
+func revertReason(data []byte) (reason string, ok bool) {
+	if len(data) < 4 {
+		return "", false
+	}
+	switch {
+	case bytes.Equal(data[:4], revertSelector):
+		stringTy, _ := abi.NewType("string", "", nil)
+		v, err := abi.Arguments{{Type: stringTy}}.Unpack(data[4:])
+		if err != nil {
+			return "", false
+		}
+		return v[0].(string), true
+	case bytes.Equal(data[:4], panicSelector):
+		if len(data) != 4+32 {
+			return "", false
+		}
+		code := new(big.Int).SetBytes(data[4:])
+		reason := "panic " + hexutil.EncodeBig(code)
+		if code.IsUint64() {
+			if r, ok := panicReasons[code.Uint64()]; ok {
+				reason += ": " + r
+			}
+		}
+		return reason, true
+	}
+	return "", false
+}
+
+// callReverted reports whether err is the error of a reverted call, and
+// returns the decoded reason if the node sent the revert data along. Nodes
+// report reverts as errors with code 3 or a message starting with
+// "execution reverted", the data as a hex string. Calls reverted in a
+// multicall carry their data the same way.
+func callReverted(err error) (reason string, reverted bool) {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if s, ok := dataErr.ErrorData().(string); ok {
+			if data, err := hexutil.Decode(s); err == nil {
+				if reason, ok := revertReason(data); ok {
+					return reason, true
+				}
+			}
+		}
+	}
+	if code, ok := rpcErrorCode(err); ok && code == 3 {
+		return "", true
+	}
+	if errors.Is(err, errMulticallReverted) {
+		return "", true
+	}
+	return "", strings.Contains(err.Error(), "execution reverted")
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// defaultAPIBodySizeLimit bounds the responses read from node APIs unless
+// the module sets a body_size_limit.
+const defaultAPIBodySizeLimit = 10 << 20
+
+// apiClient sends the requests of the probers reading a node's HTTP API,
+// configured like the http prober by the http section of the module: its
+// http_client_config, e.g. TLS settings, its headers and its
+// body_size_limit.
+type apiClient struct {
+	client    *http.Client
+	headers   map[string]string
+	sizeLimit int64
+}
+
+func newAPIClient(module config.Module, name string) (*apiClient, error) {
+	client, err := pconfig.NewClientFromConfig(module.HTTP.HTTPClientConfig, name)
+	if err != nil {
+		return nil, err
+	}
+	sizeLimit := int64(module.HTTP.BodySizeLimit)
+	if sizeLimit <= 0 {
+		sizeLimit = defaultAPIBodySizeLimit
+	}
+	return &apiClient{client: client, headers: module.HTTP.Headers, sizeLimit: sizeLimit}, nil
+}
+
+// do sends req with the module's headers, which override those already
+// set, and returns the body of the response. Responses other than 200 OK
+// and bodies larger than the size limit are an error.
+func (c *apiClient) do(req *http.Request) ([]byte, error) {
+	for name, value := range c.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.sizeLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.sizeLimit {
+		return nil, fmt.Errorf("response body exceeds the limit of %d bytes", c.sizeLimit)
+	}
+	return body, nil
+}
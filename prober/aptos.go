@@ -0,0 +1,121 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// aptosLedgerInfo is the ledger info served by an Aptos node at /v1. The
+// numbers are encoded as strings, the timestamp in microseconds.
+type aptosLedgerInfo struct {
+	LedgerVersion   string `json:"ledger_version"`
+	BlockHeight     string `json:"block_height"`
+	LedgerTimestamp string `json:"ledger_timestamp"`
+}
+
+// ProbeAptos reads the ledger info of an Aptos fullnode from its REST /v1
+// endpoint, which is appended to the target unless already there. Requests
+// use the http_client_config, headers and body_size_limit of the module's
+// http section.
+func ProbeAptos(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		ledgerVersionGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_aptos_ledger_version",
+			Help: "Latest ledger version of the node",
+		})
+		blockHeightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_aptos_block_height",
+			Help: "Latest block height of the node",
+		})
+		ledgerLagGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_aptos_ledger_lag_seconds",
+			Help: "Seconds between now and the timestamp of the latest ledger version",
+		})
+	)
+	registry.MustRegister(ledgerVersionGauge)
+	registry.MustRegister(blockHeightGauge)
+	registry.MustRegister(ledgerLagGauge)
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	if !strings.HasSuffix(strings.TrimSuffix(target, "/"), "/v1") {
+		target = strings.TrimSuffix(target, "/") + "/v1"
+	}
+
+	client, err := newAPIClient(module, "aptos_probe")
+	if err != nil {
+		level.Error(logger).Log("msg", "Error generating HTTP client", "err", err)
+		return false
+	}
+	info, err := aptosLedger(ctx, client, target)
+	if err != nil {
+		level.Error(logger).Log("msg", "get ledger info failed, "+err.Error())
+		return false
+	}
+	version, err := strconv.ParseUint(info.LedgerVersion, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("msg", "ledger_version '"+info.LedgerVersion+"' is not a number")
+		return false
+	}
+	height, err := strconv.ParseUint(info.BlockHeight, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("msg", "block_height '"+info.BlockHeight+"' is not a number")
+		return false
+	}
+	timestamp, err := strconv.ParseInt(info.LedgerTimestamp, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("msg", "ledger_timestamp '"+info.LedgerTimestamp+"' is not a number")
+		return false
+	}
+	ledgerVersionGauge.Set(float64(version))
+	blockHeightGauge.Set(float64(height))
+	ledgerLagGauge.Set(time.Since(time.UnixMicro(timestamp)).Seconds())
+	return true
+}
+
+// aptosLedger fetches ledgerURL and returns the ledger info.
+func aptosLedger(ctx context.Context, client *apiClient, ledgerURL string) (*aptosLedgerInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ledgerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	body, err := client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var info aptosLedgerInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	if info.LedgerVersion == "" {
+		return nil, fmt.Errorf("no ledger_version in response")
+	}
+	return &info, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestAptosLedgerInfo(t *testing.T) {
+	ledgerTimestamp := time.Now().Add(-30 * time.Second).UnixMicro()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"chain_id":1,"epoch":"9876","ledger_version":"1234567890","oldest_ledger_version":"0","ledger_timestamp":"%d","node_role":"full_node","oldest_block_height":"0","block_height":"345678901","git_hash":"abc"}`, ledgerTimestamp)
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !ProbeAptos(ctx, ts.URL, url.Values{}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger()) {
+		t.Fatalf("aptos probe failed unexpectedly")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValues(mfs, "probe_aptos_ledger_version", ""); got[""] != 1234567890 {
+		t.Errorf("unexpected ledger version %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_aptos_block_height", ""); got[""] != 345678901 {
+		t.Errorf("unexpected block height %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_aptos_ledger_lag_seconds", ""); got[""] < 30 || got[""] > 40 {
+		t.Errorf("unexpected ledger lag %v", got)
+	}
+}
+
+func TestAptosLedgerInfoError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"internal error"}`, http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if ProbeAptos(ctx, ts.URL, url.Values{}, config.Module{Timeout: 5 * time.Second}, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Errorf("expected the aptos probe to fail on an error response")
+	}
+}
+
+func TestAptosTLSConfig(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"ledger_version":"1","block_height":"1","ledger_timestamp":"%d"}`, time.Now().UnixMicro())
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name               string
+		insecureSkipVerify bool
+		success            bool
+	}{
+		{"Verified", false, false},
+		{"InsecureSkipVerify", true, true},
+	}
+	for _, test := range tests {
+		module := config.Module{Timeout: 5 * time.Second}
+		module.HTTP.HTTPClientConfig.TLSConfig.InsecureSkipVerify = test.insecureSkipVerify
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result := ProbeAptos(ctx, ts.URL, url.Values{}, module, prometheus.NewRegistry(), log.NewNopLogger())
+		cancel()
+		if result != test.success {
+			t.Errorf("%s: expected success %v, got %v", test.name, test.success, result)
+		}
+	}
+}
@@ -15,12 +15,15 @@ package prober
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
+	"math"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -28,15 +31,23 @@ func ProbeBTCRPC(ctx context.Context, target string, params url.Values, module c
 	disableTls := true
 	host := target
 	if strings.HasPrefix(target, "http://") {
-		host = strings.TrimLeft(target, "http://")
+		host = strings.TrimPrefix(target, "http://")
 	} else if strings.HasPrefix(target, "https://") {
-		host = strings.TrimLeft(target, "https://")
+		host = strings.TrimPrefix(target, "https://")
 		disableTls = false
 	} else {
 	}
 
-	rpcUser := params.Get("user")
-	rpcPass := params.Get("pass")
+	rpcUser := module.BTCRPC.User
+	rpcPass := string(module.BTCRPC.Password)
+	if rpcUser == "" && rpcPass == "" {
+		rpcUser = params.Get("user")
+		rpcPass = params.Get("pass")
+	}
+	// The password is kept out of the debug output. The user is left as is,
+	// redacting a common name such as bitcoin would mask it everywhere.
+	trace := callTraceFromContext(ctx)
+	trace.redact(rpcPass)
 
 	connCfg := &rpcclient.ConnConfig{
 		Host:         host,
@@ -46,9 +57,23 @@ func ProbeBTCRPC(ctx context.Context, target string, params url.Values, module c
 		DisableTLS:   disableTls, // Bitcoin core does not provide TLS by default
 	}
 
+	upGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_btcrpc_up",
+		Help: "1 if the node was reached and its answers decoded, 0 otherwise",
+	}, []string{"target"})
+	registry.MustRegister(upGaugeVec)
+	defer func() {
+		up := 0.0
+		if success {
+			up = 1
+		}
+		upGaugeVec.WithLabelValues(target).Set(up)
+	}()
+
 	client, err := rpcclient.New(connCfg, nil)
 	if err != nil {
-		level.Error(logger).Log("Error creating new BTC RPC client: " + err.Error())
+		level.Error(logger).Log("msg", "Error creating new BTC RPC client: "+err.Error())
+		return
 	}
 	defer client.Shutdown()
 
@@ -59,16 +84,175 @@ func ProbeBTCRPC(ctx context.Context, target string, params url.Values, module c
 				Name: "probe_btcrpc_block_number",
 				Help: "",
 			}, []string{"target"})
+			verificationProgressGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_verification_progress",
+				Help: "Estimated fraction of the chain verified by the node, between 0 and 1",
+			}, []string{"target"})
+			initialBlockDownloadGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_initial_block_download",
+				Help: "Whether the node is in initial block download",
+			}, []string{"target"})
 		)
 		registry.MustRegister(blockNumberGaugeVec)
+		registry.MustRegister(verificationProgressGaugeVec)
+		registry.MustRegister(initialBlockDownloadGaugeVec)
 
-		blockNumber, err := client.GetBlockCount()
+		// GetBlockCount would take a null result for height 0, decode the
+		// raw result instead.
+		raw, err := client.RawRequest("getblockcount", nil)
 		if err != nil {
-			level.Error(logger).Log("Error fetching block count: " + err.Error())
+			level.Error(logger).Log("msg", "Error fetching block count: "+err.Error())
+			return
+		}
+		var blockNumber *int64
+		if err := json.Unmarshal(raw, &blockNumber); err != nil {
+			level.Error(logger).Log("msg", "Error decoding block count: "+err.Error())
+			return
+		}
+		if blockNumber == nil {
+			level.Error(logger).Log("msg", "No block count in the response")
 			return
 		}
 
-		blockNumberGaugeVec.WithLabelValues(target).Set(float64(blockNumber))
+		trace.add("getblockcount", string(raw), strconv.FormatInt(*blockNumber, 10), traceMetric("probe_btcrpc_block_number"))
+		blockNumberGaugeVec.WithLabelValues(target).Set(float64(*blockNumber))
+
+		// rpcclient's GetBlockChainInfo first queries the backend version,
+		// decode the raw result instead.
+		raw, err = client.RawRequest("getblockchaininfo", nil)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error fetching blockchain info: "+err.Error())
+			return
+		}
+		var chainInfo struct {
+			VerificationProgress float64 `json:"verificationprogress"`
+			InitialBlockDownload bool    `json:"initialblockdownload"`
+		}
+		if err := json.Unmarshal(raw, &chainInfo); err != nil {
+			level.Error(logger).Log("msg", "Error decoding blockchain info: "+err.Error())
+			return
+		}
+		trace.add("getblockchaininfo", string(raw), strconv.FormatFloat(chainInfo.VerificationProgress, 'g', -1, 64), traceMetric("probe_btcrpc_verification_progress"))
+		verificationProgressGaugeVec.WithLabelValues(target).Set(chainInfo.VerificationProgress)
+		ibd := 0.0
+		if chainInfo.InitialBlockDownload {
+			ibd = 1
+		}
+		initialBlockDownloadGaugeVec.WithLabelValues(target).Set(ibd)
+	case "btc_mempool_info":
+		var (
+			mempoolSizeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_mempool_size",
+				Help: "Number of transactions in the mempool",
+			}, []string{"target"})
+			mempoolBytesGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_mempool_bytes",
+				Help: "Virtual size of the transactions in the mempool in bytes",
+			}, []string{"target"})
+			mempoolMinFeeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_mempool_min_fee",
+				Help: "Minimum fee rate in BTC/kvB for a transaction to be accepted into the mempool",
+			}, []string{"target"})
+		)
+		registry.MustRegister(mempoolSizeGaugeVec)
+		registry.MustRegister(mempoolBytesGaugeVec)
+		registry.MustRegister(mempoolMinFeeGaugeVec)
+
+		// rpcclient's GetMempoolInfoResult lacks mempoolminfee, decode the
+		// raw result instead.
+		raw, err := client.RawRequest("getmempoolinfo", nil)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error fetching mempool info: "+err.Error())
+			return
+		}
+		var mempoolInfo struct {
+			Size          int64   `json:"size"`
+			Bytes         int64   `json:"bytes"`
+			MempoolMinFee float64 `json:"mempoolminfee"`
+		}
+		if err := json.Unmarshal(raw, &mempoolInfo); err != nil {
+			level.Error(logger).Log("msg", "Error decoding mempool info: "+err.Error())
+			return
+		}
+		trace.add("getmempoolinfo", string(raw), strconv.FormatInt(mempoolInfo.Size, 10), traceMetric("probe_btcrpc_mempool_size"))
+
+		mempoolSizeGaugeVec.WithLabelValues(target).Set(float64(mempoolInfo.Size))
+		mempoolBytesGaugeVec.WithLabelValues(target).Set(float64(mempoolInfo.Bytes))
+		mempoolMinFeeGaugeVec.WithLabelValues(target).Set(mempoolInfo.MempoolMinFee)
+	case "btc_fee_estimate":
+		var (
+			feePerKbGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_fee_per_kb",
+				Help: "Fee rate in BTC/kvB estimated by estimatesmartfee, NaN if the node has no estimate",
+			}, []string{"target", "conf_target"})
+		)
+		registry.MustRegister(feePerKbGaugeVec)
+
+		confTarget := params.Get("blocks")
+		if confTarget == "" {
+			confTarget = "6"
+		}
+		blocks, err := strconv.Atoi(confTarget)
+		if err != nil || blocks <= 0 {
+			level.Error(logger).Log("msg", "blocks '"+confTarget+"' is not a valid confirmation target")
+			return
+		}
+		raw, err := client.RawRequest("estimatesmartfee", []json.RawMessage{json.RawMessage(strconv.Itoa(blocks))})
+		if err != nil {
+			level.Error(logger).Log("msg", "Error estimating fee: "+err.Error())
+			return
+		}
+		var estimate struct {
+			FeeRate *float64 `json:"feerate"`
+			Errors  []string `json:"errors"`
+		}
+		if err := json.Unmarshal(raw, &estimate); err != nil {
+			level.Error(logger).Log("msg", "Error decoding fee estimate: "+err.Error())
+			return
+		}
+		// A node without enough data, e.g. right after startup, answers with
+		// errors and no feerate.
+		if estimate.FeeRate == nil {
+			level.Debug(logger).Log("msg", "no fee estimate available", "errors", strings.Join(estimate.Errors, "; "))
+			trace.add("estimatesmartfee", string(raw), "NaN", traceMetric("probe_btcrpc_fee_per_kb", "conf_target", strconv.Itoa(blocks)))
+			feePerKbGaugeVec.WithLabelValues(target, strconv.Itoa(blocks)).Set(math.NaN())
+		} else {
+			trace.add("estimatesmartfee", string(raw), strconv.FormatFloat(*estimate.FeeRate, 'g', -1, 64), traceMetric("probe_btcrpc_fee_per_kb", "conf_target", strconv.Itoa(blocks)))
+			feePerKbGaugeVec.WithLabelValues(target, strconv.Itoa(blocks)).Set(*estimate.FeeRate)
+		}
+	case "btc_tip":
+		var (
+			tipHashGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_tip_hash",
+				Help: "Last 48 bits of the best block hash, changing with the tip",
+			}, []string{"target"})
+			tipHashInfoGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_btcrpc_tip_hash_info",
+				Help: "Best block hash reported by the node",
+			}, []string{"target", "hash"})
+		)
+		registry.MustRegister(tipHashGaugeVec)
+		registry.MustRegister(tipHashInfoGaugeVec)
+
+		hash, err := client.GetBestBlockHash()
+		if err != nil {
+			level.Error(logger).Log("msg", "Error fetching best block hash: "+err.Error())
+			return
+		}
+		// The hash starts with the zeros of the proof of work, its end is
+		// folded into a value a float64 holds exactly.
+		hex := hash.String()
+		fold, err := strconv.ParseUint(hex[len(hex)-12:], 16, 64)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error decoding best block hash: "+err.Error())
+			return
+		}
+		trace.add("getbestblockhash", hex, strconv.FormatUint(fold, 10), traceMetric("probe_btcrpc_tip_hash"))
+		tipHashGaugeVec.WithLabelValues(target).Set(float64(fold))
+		tipHashInfoGaugeVec.WithLabelValues(target, hex).Set(1)
+	default:
+		level.Error(logger).Log("msg", "Unknown module '"+params.Get("module")+"'")
+		return
 	}
 
 	return true
@@ -0,0 +1,368 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// newBTCRPCTestServer starts a bitcoind style JSON-RPC 1.0 server requiring
+// basic auth, answering with results[method].
+func newBTCRPCTestServer(t *testing.T, results map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "rpcuser" || pass != "rpcpass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+			return
+		}
+		resp := map[string]interface{}{"id": req.ID, "result": nil, "error": nil}
+		if result, ok := results[req.Method]; ok {
+			resp["result"] = result
+		} else {
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "Method not found"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func probeBTCRPC(t *testing.T, target string, params url.Values) (bool, []*dto.MetricFamily) {
+	registry := prometheus.NewRegistry()
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := ProbeBTCRPC(testCTX, target, params, config.Module{Timeout: time.Second}, registry, log.NewNopLogger())
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, mfs
+}
+
+func TestBTCRPCChainInfo(t *testing.T) {
+	ts := newBTCRPCTestServer(t, map[string]interface{}{
+		"getblockcount": 830000,
+		"getblockchaininfo": map[string]interface{}{
+			"chain":                "main",
+			"blocks":               830000,
+			"headers":              842000,
+			"verificationprogress": 0.9812,
+			"initialblockdownload": true,
+		},
+	})
+	defer ts.Close()
+
+	result, mfs := probeBTCRPC(t, ts.URL, url.Values{
+		"module": {"btc_chain_info"},
+		"user":   {"rpcuser"},
+		"pass":   {"rpcpass"},
+	})
+	if !result {
+		t.Fatalf("btc_chain_info probe failed unexpectedly")
+	}
+	for name, want := range map[string]float64{
+		"probe_btcrpc_block_number":           830000,
+		"probe_btcrpc_verification_progress":  0.9812,
+		"probe_btcrpc_initial_block_download": 1,
+	} {
+		if got := gaugeValues(mfs, name, "target")[ts.URL]; got != want {
+			t.Errorf("expected %s %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestBTCRPCMempoolInfo(t *testing.T) {
+	ts := newBTCRPCTestServer(t, map[string]interface{}{
+		"getmempoolinfo": map[string]interface{}{
+			"loaded":        true,
+			"size":          4213,
+			"bytes":         2107456,
+			"usage":         9876543,
+			"maxmempool":    300000000,
+			"mempoolminfee": 0.00001,
+			"minrelaytxfee": 0.00001,
+		},
+	})
+	defer ts.Close()
+
+	result, mfs := probeBTCRPC(t, ts.URL, url.Values{
+		"module": {"btc_mempool_info"},
+		"user":   {"rpcuser"},
+		"pass":   {"rpcpass"},
+	})
+	if !result {
+		t.Fatalf("btc_mempool_info probe failed unexpectedly")
+	}
+	for name, want := range map[string]float64{
+		"probe_btcrpc_mempool_size":    4213,
+		"probe_btcrpc_mempool_bytes":   2107456,
+		"probe_btcrpc_mempool_min_fee": 0.00001,
+	} {
+		if got := gaugeValues(mfs, name, "target")[ts.URL]; got != want {
+			t.Errorf("expected %s %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestBTCRPCFeeEstimate(t *testing.T) {
+	tests := []struct {
+		name     string
+		estimate map[string]interface{}
+		blocks   string
+		label    string
+		want     float64
+	}{
+		{
+			name:     "default target",
+			estimate: map[string]interface{}{"feerate": 0.00012, "blocks": 6},
+			label:    "6",
+			want:     0.00012,
+		},
+		{
+			name:     "explicit target",
+			estimate: map[string]interface{}{"feerate": 0.0003, "blocks": 2},
+			blocks:   "2",
+			label:    "2",
+			want:     0.0003,
+		},
+		{
+			name:     "insufficient data",
+			estimate: map[string]interface{}{"errors": []string{"Insufficient data or no feerate found"}, "blocks": 0},
+			label:    "6",
+			want:     math.NaN(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := newBTCRPCTestServer(t, map[string]interface{}{"estimatesmartfee": test.estimate})
+			defer ts.Close()
+
+			params := url.Values{
+				"module": {"btc_fee_estimate"},
+				"user":   {"rpcuser"},
+				"pass":   {"rpcpass"},
+			}
+			if test.blocks != "" {
+				params.Set("blocks", test.blocks)
+			}
+			result, mfs := probeBTCRPC(t, ts.URL, params)
+			if !result {
+				t.Fatalf("btc_fee_estimate probe failed unexpectedly")
+			}
+			got, ok := gaugeValues(mfs, "probe_btcrpc_fee_per_kb", "conf_target")[test.label]
+			if !ok {
+				t.Fatalf("expected probe_btcrpc_fee_per_kb with conf_target %s", test.label)
+			}
+			if got != test.want && !(math.IsNaN(got) && math.IsNaN(test.want)) {
+				t.Errorf("expected fee rate %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestBTCRPCFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		module  string
+		handler http.HandlerFunc
+	}{
+		{
+			name:   "malformed JSON",
+			module: "btc_chain_info",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"result": 830000,`))
+			},
+		},
+		{
+			name:   "non-200 response",
+			module: "btc_chain_info",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			},
+		},
+		{
+			name:   "missing block height",
+			module: "btc_chain_info",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					ID json.RawMessage `json:"id"`
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": nil, "error": nil})
+			},
+		},
+		{
+			name:   "unknown module",
+			module: "btc_unknown",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected request for an unknown module")
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(test.handler)
+			defer ts.Close()
+
+			result, mfs := probeBTCRPC(t, ts.URL, url.Values{
+				"module": {test.module},
+				"user":   {"rpcuser"},
+				"pass":   {"rpcpass"},
+			})
+			if result {
+				t.Errorf("expected the probe to fail")
+			}
+			if got := gaugeValues(mfs, "probe_btcrpc_block_number", "target"); len(got) != 0 {
+				t.Errorf("expected no probe_btcrpc_block_number, got %v", got)
+			}
+		})
+	}
+}
+
+func TestBTCRPCTip(t *testing.T) {
+	const tip = "00000000000000000002a7c4c1e48d76c5a37902165a270156b7a8d72728a054"
+	ts := newBTCRPCTestServer(t, map[string]interface{}{"getbestblockhash": tip})
+	defer ts.Close()
+
+	result, mfs := probeBTCRPC(t, ts.URL, url.Values{
+		"module": {"btc_tip"},
+		"user":   {"rpcuser"},
+		"pass":   {"rpcpass"},
+	})
+	if !result {
+		t.Fatalf("btc_tip probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_btcrpc_tip_hash", "target")[ts.URL]; got != 0xa8d72728a054 {
+		t.Errorf("expected tip hash fold %v, got %v", float64(0xa8d72728a054), got)
+	}
+	if got := gaugeValues(mfs, "probe_btcrpc_tip_hash_info", "hash"); got[tip] != 1 {
+		t.Errorf("expected probe_btcrpc_tip_hash_info for %s, got %v", tip, got)
+	}
+}
+
+func TestBTCRPCUp(t *testing.T) {
+	ts := newBTCRPCTestServer(t, map[string]interface{}{"getbestblockhash": "00000000000000000002a7c4c1e48d76c5a37902165a270156b7a8d72728a054"})
+	defer ts.Close()
+
+	for _, test := range []struct {
+		pass string
+		want float64
+	}{
+		{pass: "rpcpass", want: 1},
+		{pass: "wrong", want: 0},
+	} {
+		result, mfs := probeBTCRPC(t, ts.URL, url.Values{
+			"module": {"btc_tip"},
+			"user":   {"rpcuser"},
+			"pass":   {test.pass},
+		})
+		if result != (test.want == 1) {
+			t.Errorf("pass %s: unexpected probe result %t", test.pass, result)
+		}
+		if got, ok := gaugeValues(mfs, "probe_btcrpc_up", "target")[ts.URL]; !ok || got != test.want {
+			t.Errorf("pass %s: expected probe_btcrpc_up %v, got %v", test.pass, test.want, got)
+		}
+	}
+}
+
+func TestBTCRPCCredentialsFromConfig(t *testing.T) {
+	ts := newBTCRPCTestServer(t, map[string]interface{}{"getmempoolinfo": map[string]interface{}{"size": 1}})
+	defer ts.Close()
+
+	module := config.Module{Timeout: time.Second, BTCRPC: config.BTCRPCProbe{User: "rpcuser", Password: "rpcpass"}}
+	// The configured credentials take precedence over the params.
+	params := url.Values{
+		"module": {"btc_mempool_info"},
+		"user":   {"rpcuser"},
+		"pass":   {"wrong"},
+	}
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !ProbeBTCRPC(testCTX, ts.URL, params, module, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Fatalf("btc_mempool_info probe failed with credentials from config")
+	}
+}
+
+func TestBTCRPCCredentialsHidden(t *testing.T) {
+	// The server echoes the credentials back, ending up in the logged error.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("bad credentials " + user + ":" + pass))
+	}))
+	defer ts.Close()
+
+	conf := &config.Config{Modules: map[string]config.Module{
+		"btc_chain_info": {Prober: "btcrpc", Timeout: time.Second},
+	}}
+	req, err := http.NewRequest("GET", "?debug=true&module=btc_chain_info&user=rpcuser&pass=hunter2&target="+url.QueryEscape(ts.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	Handler(rr, req, conf, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+
+	body := rr.Body.String()
+	if strings.Contains(body, "hunter2") {
+		t.Errorf("password exposed in debug output: %v", body)
+	}
+	if !strings.Contains(body, "rpcuser:<secret>") {
+		t.Errorf("expected only the password to be redacted in debug output: %v", body)
+	}
+}
+
+func TestBTCRPCDebugOutputCalls(t *testing.T) {
+	ts := newBTCRPCTestServer(t, map[string]interface{}{"getmempoolinfo": map[string]interface{}{"size": 3, "bytes": 1200}})
+	defer ts.Close()
+
+	conf := &config.Config{Modules: map[string]config.Module{
+		"btc_mempool_info": {Prober: "btcrpc", Timeout: time.Second},
+	}}
+	req, err := http.NewRequest("GET", "?debug=true&module=btc_mempool_info&user=rpcuser&pass=rpcpass&target="+url.QueryEscape(ts.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	Handler(rr, req, conf, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+
+	body := rr.Body.String()
+	for _, want := range []string{"Calls made by the probe:", "getmempoolinfo", "probe_btcrpc_mempool_size{}"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in debug output: %v", want, body)
+		}
+	}
+}
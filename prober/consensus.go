@@ -0,0 +1,109 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// ProbeConsensus reads eth_blockNumber from every provider of a chain, given
+// as repeated target params, in parallel and reports how far each one is
+// behind the highest block seen. Providers that cannot be read fail the
+// probe and are left out of the comparison.
+func ProbeConsensus(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		blockNumberGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ethrpc_block_number",
+			Help: "Number of the latest block of the provider",
+		}, []string{"target"})
+		blockLagGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ethrpc_block_lag_vs_max",
+			Help: "Number of blocks the provider is behind the highest block of all providers",
+		}, []string{"target"})
+	)
+	registry.MustRegister(blockNumberGaugeVec)
+	registry.MustRegister(blockLagGaugeVec)
+
+	targets := params["target"]
+	if len(targets) == 0 {
+		targets = []string{target}
+	}
+	headers, err := ethRPCHeaders(params, module)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		blockNumbers = make(map[string]uint64)
+	)
+	success = true
+	for _, endpoint := range targets {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			n, err := readBlockNumber(ctx, endpoint, headers, module)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				level.Error(logger).Log("msg", "get block number failed, "+err.Error(), "target", endpoint)
+				success = false
+				return
+			}
+			blockNumbers[endpoint] = n
+		}(endpoint)
+	}
+	wg.Wait()
+
+	var max uint64
+	for _, n := range blockNumbers {
+		if n > max {
+			max = n
+		}
+	}
+	for endpoint, n := range blockNumbers {
+		blockNumberGaugeVec.WithLabelValues(endpoint).Set(float64(n))
+		blockLagGaugeVec.WithLabelValues(endpoint).Set(float64(max - n))
+	}
+	return success
+}
+
+func readBlockNumber(ctx context.Context, endpoint string, headers http.Header, module config.Module) (uint64, error) {
+	eth, _, err := dialETHRPC(ctx, endpoint, headers, module)
+	if err != nil {
+		return 0, err
+	}
+	defer eth.Close()
+	var n hexutil.Uint64
+	if err := eth.Client().CallContext(ctx, &n, "eth_blockNumber"); err != nil {
+		return 0, err
+	}
+	return uint64(n), nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func newBlockNumberTestServer(t *testing.T, blockNumber string) string {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		if method == "eth_blockNumber" {
+			return blockNumber, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestConsensus(t *testing.T) {
+	ahead := newBlockNumberTestServer(t, "0x64")
+	behind := newBlockNumberTestServer(t, "0x5f")
+	// A closed server makes the provider unreachable.
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	down := ts.URL
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := ProbeConsensus(ctx, ahead, url.Values{
+		"target": {ahead, behind, down},
+	}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger())
+	if result {
+		t.Errorf("expected an unreachable provider to fail the probe")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := gaugeValues(mfs, "probe_ethrpc_block_number", "target")
+	if len(numbers) != 2 || numbers[ahead] != 100 || numbers[behind] != 95 {
+		t.Errorf("unexpected block numbers %v", numbers)
+	}
+	lags := gaugeValues(mfs, "probe_ethrpc_block_lag_vs_max", "target")
+	if len(lags) != 2 || lags[ahead] != 0 || lags[behind] != 5 {
+		t.Errorf("unexpected block lags %v", lags)
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	pconfig "github.com/prometheus/common/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// cosmosSyncInfo is the sync_info of a Tendermint /status response.
+type cosmosSyncInfo struct {
+	LatestBlockHeight string    `json:"latest_block_height"`
+	LatestBlockTime   time.Time `json:"latest_block_time"`
+	CatchingUp        bool      `json:"catching_up"`
+}
+
+// ProbeCosmos reads the sync status of a Tendermint or CometBFT node from
+// its RPC /status endpoint, which is appended to the target unless already
+// there. With transport=grpc, it is read instead from the gRPC endpoint of
+// the Cosmos SDK, given as host:port, over TLS with tls=true.
+//
+// With validator params, the commission and jailing of those validators,
+// given by operator address, are read from the staking module of the REST
+// API at the api param, by default the target.
+//
+// HTTP requests use the http_client_config, headers and body_size_limit of
+// the module's http section.
+func ProbeCosmos(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		latestBlockHeightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_cosmos_latest_block_height",
+			Help: "Height of the latest block of the node",
+		})
+		catchingUpGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_cosmos_catching_up",
+			Help: "1 if the node reports it is catching up, 0 otherwise",
+		})
+		blockLagGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_cosmos_block_lag_seconds",
+			Help: "Seconds between now and the time of the latest block",
+		})
+	)
+	registry.MustRegister(latestBlockHeightGauge)
+	registry.MustRegister(catchingUpGauge)
+	registry.MustRegister(blockLagGauge)
+	var (
+		validatorCommissionGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_cosmos_validator_commission",
+			Help: "Commission rate of the validator, between 0 and 1",
+		}, []string{"validator"})
+		validatorJailedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_cosmos_validator_jailed",
+			Help: "1 if the validator is jailed, 0 otherwise",
+		}, []string{"validator"})
+	)
+	validators := params["validator"]
+	if len(validators) > 0 {
+		registry.MustRegister(validatorCommissionGaugeVec)
+		registry.MustRegister(validatorJailedGaugeVec)
+	}
+	api := params.Get("api")
+	if api == "" {
+		api = strings.TrimSuffix(strings.TrimSuffix(target, "/"), "/status")
+	}
+	if !strings.HasPrefix(api, "http://") && !strings.HasPrefix(api, "https://") {
+		api = "http://" + api
+	}
+
+	client, err := newAPIClient(module, "cosmos_probe")
+	if err != nil {
+		level.Error(logger).Log("msg", "Error generating HTTP client", "err", err)
+		return false
+	}
+
+	var syncInfo *cosmosSyncInfo
+	switch transport := params.Get("transport"); transport {
+	case "", "http":
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			target = "http://" + target
+		}
+		if !strings.HasSuffix(strings.TrimSuffix(target, "/"), "/status") {
+			target = strings.TrimSuffix(target, "/") + "/status"
+		}
+		syncInfo, err = cosmosStatus(ctx, client, target)
+	case "grpc":
+		syncInfo, err = cosmosGRPCStatus(ctx, target, params.Get("tls") == "true", module)
+	default:
+		level.Error(logger).Log("msg", "transport '"+transport+"' is not valid, must be http or grpc")
+		return false
+	}
+	if err != nil {
+		level.Error(logger).Log("msg", "get status failed, "+err.Error())
+		return false
+	}
+	height, err := strconv.ParseUint(syncInfo.LatestBlockHeight, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("msg", "latest_block_height '"+syncInfo.LatestBlockHeight+"' is not a number")
+		return false
+	}
+	latestBlockHeightGauge.Set(float64(height))
+	if syncInfo.CatchingUp {
+		catchingUpGauge.Set(1)
+	}
+	blockLagGauge.Set(time.Since(syncInfo.LatestBlockTime).Seconds())
+
+	success = true
+	for _, validator := range validators {
+		v, err := cosmosValidator(ctx, client, api, validator)
+		if err != nil {
+			level.Error(logger).Log("msg", "get validator failed, "+err.Error(), "validator", validator)
+			success = false
+			continue
+		}
+		commission, err := strconv.ParseFloat(v.Commission.CommissionRates.Rate, 64)
+		if err != nil {
+			level.Error(logger).Log("msg", "commission rate '"+v.Commission.CommissionRates.Rate+"' is not a number", "validator", validator)
+			success = false
+			continue
+		}
+		validatorCommissionGaugeVec.WithLabelValues(validator).Set(commission)
+		jailed := 0.0
+		if v.Jailed {
+			jailed = 1
+		}
+		validatorJailedGaugeVec.WithLabelValues(validator).Set(jailed)
+	}
+	return success
+}
+
+// cosmosValidatorInfo is a validator as served by the staking module of the
+// Cosmos SDK REST API. Decimals are encoded as strings.
+type cosmosValidatorInfo struct {
+	Jailed     bool `json:"jailed"`
+	Commission struct {
+		CommissionRates struct {
+			Rate string `json:"rate"`
+		} `json:"commission_rates"`
+	} `json:"commission"`
+}
+
+// cosmosValidator reads the validator with the operator address addr from
+// the REST API at api.
+func cosmosValidator(ctx context.Context, client *apiClient, api, addr string) (*cosmosValidatorInfo, error) {
+	var resp struct {
+		Validator *cosmosValidatorInfo `json:"validator"`
+	}
+	if err := cosmosGet(ctx, client, strings.TrimSuffix(api, "/")+"/cosmos/staking/v1beta1/validators/"+url.PathEscape(addr), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Validator == nil {
+		return nil, fmt.Errorf("no validator in response")
+	}
+	return resp.Validator, nil
+}
+
+// cosmosStatus fetches statusURL and returns its sync_info. Tendermint wraps
+// it in a JSON-RPC result, some gateways serve it unwrapped.
+func cosmosStatus(ctx context.Context, client *apiClient, statusURL string) (*cosmosSyncInfo, error) {
+	var status struct {
+		Result *struct {
+			SyncInfo *cosmosSyncInfo `json:"sync_info"`
+		} `json:"result"`
+		SyncInfo *cosmosSyncInfo `json:"sync_info"`
+	}
+	if err := cosmosGet(ctx, client, statusURL, &status); err != nil {
+		return nil, err
+	}
+	switch {
+	case status.Result != nil && status.Result.SyncInfo != nil:
+		return status.Result.SyncInfo, nil
+	case status.SyncInfo != nil:
+		return status.SyncInfo, nil
+	}
+	return nil, fmt.Errorf("no sync_info in response")
+}
+
+// cosmosGet fetches u and decodes the JSON response into v.
+func cosmosGet(ctx context.Context, client *apiClient, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	body, err := client.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// The Cosmos SDK gRPC methods read with transport=grpc. Their messages are
+// decoded by field number, see cosmosGRPCStatus.
+const (
+	cosmosGetLatestBlockMethod = "/cosmos.base.tendermint.v1beta1.Service/GetLatestBlock"
+	cosmosGetSyncingMethod     = "/cosmos.base.tendermint.v1beta1.Service/GetSyncing"
+)
+
+// cosmosGRPCStatus reads the latest block and the syncing status of a node
+// from the Cosmos SDK gRPC service at target.
+func cosmosGRPCStatus(ctx context.Context, target string, useTLS bool, module config.Module) (*cosmosSyncInfo, error) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		tlsConfig, err := pconfig.NewTLSConfig(&module.GRPC.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// GetLatestBlockResponse has the block as block (2), and from v0.47 also
+	// as sdk_block (3). Both have the header as field 1, with the height as
+	// field 3 and the time as field 4.
+	var resp []byte
+	if err := conn.Invoke(ctx, cosmosGetLatestBlockMethod, &[]byte{}, &resp, grpc.ForceCodec(rawProtoCodec{})); err != nil {
+		return nil, err
+	}
+	block, ok := protoBytesField(resp, 3)
+	if !ok {
+		if block, ok = protoBytesField(resp, 2); !ok {
+			return nil, fmt.Errorf("no block in GetLatestBlock response")
+		}
+	}
+	header, ok := protoBytesField(block, 1)
+	if !ok {
+		return nil, fmt.Errorf("no block header in GetLatestBlock response")
+	}
+	height, ok := protoVarintField(header, 3)
+	if !ok {
+		return nil, fmt.Errorf("no height in GetLatestBlock block header")
+	}
+	timestamp, ok := protoBytesField(header, 4)
+	if !ok {
+		return nil, fmt.Errorf("no time in GetLatestBlock block header")
+	}
+	seconds, ok := protoVarintField(timestamp, 1)
+	if !ok {
+		return nil, fmt.Errorf("no seconds in GetLatestBlock block time")
+	}
+	// proto3 leaves out zero values: the timestamp being well formed, as
+	// seconds was found, a missing nanos is 0.
+	nanos, _ := protoVarintField(timestamp, 2)
+	syncInfo := &cosmosSyncInfo{
+		LatestBlockHeight: strconv.FormatInt(int64(height), 10),
+		LatestBlockTime:   time.Unix(int64(seconds), int64(nanos)),
+	}
+
+	// GetSyncingResponse has the syncing flag as field 1.
+	resp = nil
+	if err := conn.Invoke(ctx, cosmosGetSyncingMethod, &[]byte{}, &resp, grpc.ForceCodec(rawProtoCodec{})); err != nil {
+		return nil, err
+	}
+	syncing, _ := protoVarintField(resp, 1)
+	syncInfo.CatchingUp = syncing != 0
+	return syncInfo, nil
+}
+
+// rawProtoCodec passes protobuf messages through as bytes, for services
+// whose generated code is not vendored.
+type rawProtoCodec struct{}
+
+func (rawProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawProtoCodec) Name() string {
+	return "proto"
+}
+
+// protoBytesField returns the last occurrence of the length-delimited field
+// num of a protobuf message, which is not found in a malformed message.
+func protoBytesField(msg []byte, num protowire.Number) ([]byte, bool) {
+	var (
+		value []byte
+		found bool
+	)
+	wellFormed := protoFields(msg, func(n protowire.Number, typ protowire.Type, b []byte) int {
+		if n != num || typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(n, typ, b)
+		}
+		v, l := protowire.ConsumeBytes(b)
+		value, found = v, l >= 0
+		return l
+	})
+	return value, found && wellFormed
+}
+
+// protoVarintField returns the last occurrence of the varint field num of a
+// protobuf message, which is not found in a malformed message.
+func protoVarintField(msg []byte, num protowire.Number) (uint64, bool) {
+	var (
+		value uint64
+		found bool
+	)
+	wellFormed := protoFields(msg, func(n protowire.Number, typ protowire.Type, b []byte) int {
+		if n != num || typ != protowire.VarintType {
+			return protowire.ConsumeFieldValue(n, typ, b)
+		}
+		v, l := protowire.ConsumeVarint(b)
+		value, found = v, l >= 0
+		return l
+	})
+	return value, found && wellFormed
+}
+
+// protoFields calls consume with each field of msg and the bytes following
+// its tag. consume returns the length of the field's value, negative when
+// it is malformed, which stops the iteration. It returns false when msg is
+// malformed.
+func protoFields(msg []byte, consume func(protowire.Number, protowire.Type, []byte) int) bool {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return false
+		}
+		msg = msg[n:]
+		n = consume(num, typ, msg)
+		if n < 0 {
+			return false
+		}
+		msg = msg[n:]
+	}
+	return true
+}
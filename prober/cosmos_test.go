@@ -0,0 +1,254 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestCosmosStatus(t *testing.T) {
+	blockTime := time.Now().Add(-30 * time.Second).UTC().Format(time.RFC3339Nano)
+	syncInfo := fmt.Sprintf(`"sync_info":{"latest_block_height":"19876543","latest_block_time":%q,"catching_up":true}`, blockTime)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"JSONRPC", `{"jsonrpc":"2.0","id":-1,"result":{"node_info":{},` + syncInfo + `}}`},
+		{"Unwrapped", `{"node_info":{},` + syncInfo + `}`},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/status" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(test.body))
+		}))
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result := ProbeCosmos(ctx, ts.URL, url.Values{}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger())
+		cancel()
+		ts.Close()
+		if !result {
+			t.Fatalf("%s: cosmos probe failed unexpectedly", test.name)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gaugeValues(mfs, "probe_cosmos_latest_block_height", ""); got[""] != 19876543 {
+			t.Errorf("%s: unexpected height %v", test.name, got)
+		}
+		if got := gaugeValues(mfs, "probe_cosmos_catching_up", ""); got[""] != 1 {
+			t.Errorf("%s: expected catching up, got %v", test.name, got)
+		}
+		if got := gaugeValues(mfs, "probe_cosmos_block_lag_seconds", ""); got[""] < 30 || got[""] > 40 {
+			t.Errorf("%s: unexpected block lag %v", test.name, got)
+		}
+	}
+}
+
+func TestCosmosHTTPConfig(t *testing.T) {
+	blockTime := time.Now().UTC().Format(time.RFC3339Nano)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"sync_info":{"latest_block_height":"100","latest_block_time":%q,"catching_up":false}}`, blockTime)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		http    config.HTTPProbe
+		success bool
+	}{
+		{"NoHeaders", config.HTTPProbe{}, false},
+		{"Headers", config.HTTPProbe{Headers: map[string]string{"X-Api-Key": "secret"}}, true},
+		{"BodySizeLimit", config.HTTPProbe{Headers: map[string]string{"X-Api-Key": "secret"}, BodySizeLimit: 16}, false},
+	}
+	for _, test := range tests {
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result := ProbeCosmos(ctx, ts.URL, url.Values{}, config.Module{Timeout: 5 * time.Second, HTTP: test.http}, registry, log.NewNopLogger())
+		cancel()
+		if result != test.success {
+			t.Errorf("%s: expected success %v, got %v", test.name, test.success, result)
+		}
+	}
+}
+
+func TestCosmosValidatorStatus(t *testing.T) {
+	const (
+		active  = "cosmosvaloper1sjllsnramtg3ewxqwwrwjxfgc4n4ef9u2lcnj0"
+		jailed  = "cosmosvaloper156gqf9837u7d4c4678yt3rl4ls9c5vuursrrzf"
+		unknown = "cosmosvaloper1unknown"
+	)
+	blockTime := time.Now().UTC().Format(time.RFC3339Nano)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			fmt.Fprintf(w, `{"result":{"sync_info":{"latest_block_height":"100","latest_block_time":%q,"catching_up":false}}}`, blockTime)
+		case "/cosmos/staking/v1beta1/validators/" + active:
+			fmt.Fprintf(w, `{"validator":{"operator_address":%q,"jailed":false,"status":"BOND_STATUS_BONDED","commission":{"commission_rates":{"rate":"0.050000000000000000","max_rate":"0.200000000000000000"}}}}`, active)
+		case "/cosmos/staking/v1beta1/validators/" + jailed:
+			fmt.Fprintf(w, `{"validator":{"operator_address":%q,"jailed":true,"status":"BOND_STATUS_UNBONDING","commission":{"commission_rates":{"rate":"0.100000000000000000","max_rate":"0.200000000000000000"}}}}`, jailed)
+		default:
+			http.Error(w, `{"code":5,"message":"validator not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := ProbeCosmos(ctx, ts.URL, url.Values{"validator": {active, jailed, unknown}}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger())
+	if result {
+		t.Errorf("expected an unknown validator to fail the probe")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commissions := gaugeValues(mfs, "probe_cosmos_validator_commission", "validator")
+	if len(commissions) != 2 || commissions[active] != 0.05 || commissions[jailed] != 0.1 {
+		t.Errorf("unexpected commissions %v", commissions)
+	}
+	jailedFlags := gaugeValues(mfs, "probe_cosmos_validator_jailed", "validator")
+	if len(jailedFlags) != 2 || jailedFlags[active] != 0 || jailedFlags[jailed] != 1 {
+		t.Errorf("unexpected jailed flags %v", jailedFlags)
+	}
+}
+
+// newCosmosGRPCTestServer serves GetLatestBlock and GetSyncing of the Cosmos
+// SDK tendermint service, encoding the responses by hand.
+func newCosmosGRPCTestServer(t *testing.T, height int64, blockTime time.Time, syncing bool) string {
+	var timestamp, header []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(blockTime.Unix()))
+	timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(blockTime.Nanosecond()))
+	header = protowire.AppendTag(header, 2, protowire.BytesType)
+	header = protowire.AppendString(header, "cosmoshub-4")
+	header = protowire.AppendTag(header, 3, protowire.VarintType)
+	header = protowire.AppendVarint(header, uint64(height))
+	header = protowire.AppendTag(header, 4, protowire.BytesType)
+	header = protowire.AppendBytes(header, timestamp)
+	return newCosmosGRPCHeaderTestServer(t, header, syncing)
+}
+
+// newCosmosGRPCHeaderTestServer serves GetLatestBlock with the encoded block
+// header, and GetSyncing.
+func newCosmosGRPCHeaderTestServer(t *testing.T, header []byte, syncing bool) string {
+	var block, latestBlock, syncingResp []byte
+	block = protowire.AppendTag(block, 1, protowire.BytesType)
+	block = protowire.AppendBytes(block, header)
+	latestBlock = protowire.AppendTag(latestBlock, 3, protowire.BytesType)
+	latestBlock = protowire.AppendBytes(latestBlock, block)
+	if syncing {
+		syncingResp = protowire.AppendTag(syncingResp, 1, protowire.VarintType)
+		syncingResp = protowire.AppendVarint(syncingResp, 1)
+	}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.ForceServerCodec(rawProtoCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		switch method {
+		case cosmosGetLatestBlockMethod:
+			return stream.SendMsg(&latestBlock)
+		case cosmosGetSyncingMethod:
+			return stream.SendMsg(&syncingResp)
+		}
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String()
+}
+
+func TestCosmosGRPCStatus(t *testing.T) {
+	target := newCosmosGRPCTestServer(t, 19876543, time.Now().Add(-30*time.Second), true)
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !ProbeCosmos(ctx, target, url.Values{"transport": {"grpc"}}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger()) {
+		t.Fatalf("cosmos grpc probe failed unexpectedly")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValues(mfs, "probe_cosmos_latest_block_height", ""); got[""] != 19876543 {
+		t.Errorf("unexpected height %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_cosmos_catching_up", ""); got[""] != 1 {
+		t.Errorf("expected catching up, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_cosmos_block_lag_seconds", ""); got[""] < 30 || got[""] > 40 {
+		t.Errorf("unexpected block lag %v", got)
+	}
+}
+
+func TestCosmosGRPCStatusIncompleteHeader(t *testing.T) {
+	var timestamp []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(time.Now().Unix()))
+	withHeight := protowire.AppendTag(nil, 3, protowire.VarintType)
+	withHeight = protowire.AppendVarint(withHeight, 19876543)
+	withTime := protowire.AppendTag(nil, 4, protowire.BytesType)
+	withTime = protowire.AppendBytes(withTime, timestamp)
+	// The timestamp is cut in the middle of the seconds varint.
+	truncatedTime := protowire.AppendTag(nil, 4, protowire.BytesType)
+	truncatedTime = protowire.AppendBytes(truncatedTime, timestamp[:len(timestamp)-1])
+
+	tests := map[string][]byte{
+		"no height":      withTime,
+		"no time":        withHeight,
+		"truncated time": append(append([]byte(nil), withHeight...), truncatedTime...),
+		"truncated":      append(append([]byte(nil), withHeight...), withTime[:len(withTime)-2]...),
+	}
+	for name, header := range tests {
+		target := newCosmosGRPCHeaderTestServer(t, header, false)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := cosmosGRPCStatus(ctx, target, false, config.Module{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		cancel()
+	}
+}
@@ -16,8 +16,12 @@ package prober
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
@@ -25,10 +29,14 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
+	"go/ast"
+	"math"
 	"math/big"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ValidCallParam struct {
@@ -36,6 +44,8 @@ type ValidCallParam struct {
 	ContractAddress string
 	MethodName      string
 	MethodArgs      string
+	OutputType      string
+	Outputs         abi.Arguments
 }
 
 type ValidAccount struct {
@@ -43,21 +53,403 @@ type ValidAccount struct {
 	AccountAddress string
 }
 
+// rpcBlockHeader holds the block fields used by the modules below. It is
+// decoded straight from eth_getBlockByNumber rather than through
+// ethclient.HeaderByNumber, whose strict decoding rejects the non-standard
+// headers some L2 chains return.
+type rpcBlockHeader struct {
+	Number        *hexutil.Big   `json:"number"`
+	Timestamp     hexutil.Uint64 `json:"timestamp"`
+	GasUsed       hexutil.Uint64 `json:"gasUsed"`
+	GasLimit      hexutil.Uint64 `json:"gasLimit"`
+	BaseFeePerGas *hexutil.Big   `json:"baseFeePerGas"`
+}
+
+// rpcLog identifies a log entry independently of how it was retrieved.
+type rpcLog struct {
+	BlockHash       common.Hash    `json:"blockHash"`
+	BlockNumber     hexutil.Uint64 `json:"blockNumber"`
+	TransactionHash common.Hash    `json:"transactionHash"`
+	LogIndex        hexutil.Uint64 `json:"logIndex"`
+}
+
+// maxEventSearchBlocks bounds the window searched for the event of the
+// erc20balance module.
+const maxEventSearchBlocks = 10000
+
+// l2OutputOracleABI holds the OP Stack L2OutputOracle getters read by the
+// l2_output module.
+const l2OutputOracleABI = `[
+	{"name":"latestOutputIndex","type":"function","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"name":"getL2Output","type":"function","inputs":[{"name":"_l2OutputIndex","type":"uint256"}],"outputs":[{"name":"","type":"tuple","components":[{"name":"outputRoot","type":"bytes32"},{"name":"timestamp","type":"uint128"},{"name":"l2BlockNumber","type":"uint128"}]}]}
+]`
+
+const chainlinkAggregatorABI = `[
+	{"name":"decimals","type":"function","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+	{"name":"latestRoundData","type":"function","inputs":[],"outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}]}
+]`
+
+// uniswapV2PairABI holds the Uniswap V2 style pair getters and the ERC20
+// decimals getter read by the pool_tvl module.
+const uniswapV2PairABI = `[
+	{"name":"getReserves","type":"function","inputs":[],"outputs":[{"name":"reserve0","type":"uint112"},{"name":"reserve1","type":"uint112"},{"name":"blockTimestampLast","type":"uint32"}]},
+	{"name":"token0","type":"function","inputs":[],"outputs":[{"name":"","type":"address"}]},
+	{"name":"token1","type":"function","inputs":[],"outputs":[{"name":"","type":"address"}]},
+	{"name":"decimals","type":"function","inputs":[],"outputs":[{"name":"","type":"uint8"}]}
+]`
+
+// transferTaxProbeCode is the runtime code that the transfer_tax module
+// places at transferTaxProbeAddress. Called with (token, recipient, amount)
+// as three words, it transfers amount of its own token balance to recipient
+// and returns the balance increase of recipient, reverting with the token's
+// revert data if a call fails:
+//
+//	pre = token.balanceOf(recipient)
+//	token.transfer(recipient, amount)
+//	return token.balanceOf(recipient) - pre
+const transferTaxProbeCode = "0x6370a0823160e01b60005260203560045260206080602460006000355afa15607d5763a9059cbb60e01b600052602035600452604035602452600060006044600060006000355af115607d576370a0823160e01b600052602035600452602060a0602460006000355afa15607d5760805160a0510360005260206000f35b3d600060003e3d6000fd"
+
+var (
+	// transferTaxProbeAddress holds transferTaxProbeCode during the
+	// simulated transfer, it is funded through a state override.
+	transferTaxProbeAddress = common.HexToAddress("0x00000000000000000000000000000000007a7800")
+	// transferTaxRecipient is the default recipient of the simulated
+	// transfer.
+	transferTaxRecipient = common.HexToAddress("0x00000000000000000000000000000000007a7801")
+)
+
+// eip1967ImplementationSlot is the storage slot holding the implementation
+// address of EIP-1967 proxies, keccak256("eip1967.proxy.implementation") - 1.
+var eip1967ImplementationSlot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+
+// rpcReceipt holds the transaction receipt fields read by the tx_receipt
+// module.
+type rpcReceipt struct {
+	Status      hexutil.Uint64 `json:"status"`
+	BlockNumber *hexutil.Big   `json:"blockNumber"`
+	GasUsed     hexutil.Uint64 `json:"gasUsed"`
+}
+
+// rpcBlockTxs holds the transactions of a block fetched with
+// eth_getBlockByNumber and full transactions.
+type rpcBlockTxs struct {
+	Transactions []struct {
+		Hash common.Hash     `json:"hash"`
+		To   *common.Address `json:"to"`
+	} `json:"transactions"`
+}
+
+const (
+	// maxContractGasBlocks bounds the window scanned by the contract_gas
+	// module.
+	maxContractGasBlocks = 100
+	// contractGasBatchSize is the number of requests sent per batch, most
+	// providers reject larger batches.
+	contractGasBatchSize = 50
+)
+
+// defaultRawMaxLength bounds the value label of probe_jsonrpc_raw unless
+// set by the rawMaxLength param.
+const defaultRawMaxLength = 64
+
+var getterNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type congestionComponent struct {
+	Value  float64
+	Weight float64
+}
+
 func ProbeETHRPC(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
-	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
-		target = "http://" + target
+	// target may list fallback endpoints, comma separated. Series are
+	// labelled with the whole list so that they do not change on failover,
+	// probe_jsonrpc_active_target tells which endpoint served the probe.
+	endpoints := strings.Split(target, ",")
+	for i, endpoint := range endpoints {
+		endpoint = strings.TrimSpace(endpoint)
+		if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") &&
+			!strings.HasPrefix(endpoint, "ws://") && !strings.HasPrefix(endpoint, "wss://") {
+			endpoint = "http://" + endpoint
+		}
+		endpoints[i] = endpoint
 	}
-	eth, err := ethclient.Dial(target)
+	target = strings.Join(endpoints, ",")
+	// Addresses in labels are normalized so that the same address always
+	// yields the same series, whatever case it was passed in.
+	addressLabel := func(address string) string {
+		return formatAddressLabel(address, module.ETHRPC.AddressLabelFormat)
+	}
+
+	headers, err := ethRPCHeaders(params, module)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+	retryPolicy, err := parseRPCRetryPolicy(params, module)
 	if err != nil {
-		level.Error(logger).Log("msg", "Error dialing rpc", target, err)
+		level.Error(logger).Log("msg", err.Error())
 		return false
 	}
-	chainIdBigInt, err := eth.ChainID(ctx)
+	rawMaxLength, err := rawMaxLengthParam(params)
 	if err != nil {
-		level.Error(logger).Log("msg", "get chainId failed ! "+err.Error())
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+	// Calls are traced for the debug output, keeping credentials out.
+	trace := callTraceFromContext(ctx)
+	for _, values := range headers {
+		trace.redact(values...)
+	}
+	for _, endpoint := range endpoints {
+		if u, err := url.Parse(endpoint); err == nil {
+			if password, ok := u.User.Password(); ok {
+				trace.redact(password)
+			}
+		}
+	}
+	transportGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ethrpc_transport",
+		Help: "Transport used to reach the endpoint, set to 1",
+	}, []string{"rpc", "transport"})
+	registry.MustRegister(transportGaugeVec)
+
+	// Failed calls are reported by type, see classifyRPCError, telling
+	// provider side errors from misconfiguration. tag is the block tag of
+	// the call, or empty for methods that take none.
+	rpcErrorGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_error",
+		Help: "Set to 1 for each type of error a JSON-RPC call of the probe failed with",
+	}, []string{"rpc", "method", "tag", "type"})
+	// The code of JSON-RPC error objects tells e.g. -32005, limit exceeded,
+	// from the generic -32000.
+	rpcErrorCodeGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_rpc_error_code",
+		Help: "Code of the JSON-RPC error object a call of the probe failed with",
+	}, []string{"rpc", "method", "tag"})
+	// Calls are also reported as succeeded or failed one by one, so that a
+	// probe making several of them tells which one broke.
+	callSuccessGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_call_success",
+		Help: "1 if the JSON-RPC call of the probe succeeded, 0 if it failed",
+	}, []string{"rpc", "method", "tag"})
+	registry.MustRegister(rpcErrorGaugeVec)
+	registry.MustRegister(rpcErrorCodeGaugeVec)
+	registry.MustRegister(callSuccessGaugeVec)
+	callSucceeded := func(method, tag string) {
+		callSuccessGaugeVec.WithLabelValues(target, method, tag).Set(1)
+	}
+	rpcFailed := func(method, tag, errType string) {
+		rpcErrorGaugeVec.WithLabelValues(target, method, tag, errType).Set(1)
+		if method != "" {
+			callSuccessGaugeVec.WithLabelValues(target, method, tag).Set(0)
+		}
+	}
+	rpcCallFailed := func(method, tag string, err error) {
+		rpcFailed(method, tag, classifyRPCError(err))
+		if code, ok := rpcErrorCode(err); ok {
+			rpcErrorCodeGaugeVec.WithLabelValues(target, method, tag).Set(float64(code))
+		}
+	}
+	// With retries set, failed calls are retried with a backoff depending
+	// on the reason of the failure, see retryReason, tag being the block
+	// tag as for probe_jsonrpc_error.
+	rpcRetriesGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_retries",
+		Help: "Number of retries used by the JSON-RPC calls of the probe",
+	}, []string{"rpc", "tag", "reason"})
+	if retryPolicy.retries > 0 {
+		registry.MustRegister(rpcRetriesGaugeVec)
+	}
+	withRetries := func(tag string, call func() error) error {
+		retries, err := retryPolicy.do(ctx, call)
+		for reason, n := range retries {
+			rpcRetriesGaugeVec.WithLabelValues(target, tag, reason).Add(float64(n))
+		}
+		return err
+	}
+	// With emitRaw=true, results are also exported as they were returned,
+	// e.g. the client version, cut to rawMaxLength characters to bound the
+	// series a changing value can create.
+	rawGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_raw",
+		Help: "Result of a JSON-RPC call of the probe as the value label, set to 1",
+	}, []string{"rpc", "method", "tag", "value"})
+	emitRaw := params.Get("emitRaw") == "true"
+	if emitRaw {
+		registry.MustRegister(rawGaugeVec)
+	}
+	rawResult := func(method, tag, value string) {
+		if !emitRaw {
+			return
+		}
+		if r := []rune(value); len(r) > rawMaxLength {
+			value = string(r[:rawMaxLength]) + "..."
+		}
+		rawGaugeVec.WithLabelValues(target, method, tag, value).Set(1)
+	}
+
+	billableRequestsGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_rpc_billable_requests",
+		Help: "Number of JSON-RPC requests made by the probe, as counted by the configured billing model",
+	}, []string{"rpc"})
+	bytesAllocatedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_rpc_bytes_allocated",
+		Help: "Number of response bytes read by the probe over HTTP",
+	}, []string{"rpc"})
+	latencyRegressionGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_rpc_latency_regression",
+		Help: "Duration of the probe relative to the mean of recent successful probes of the same target and module",
+	}, []string{"rpc"})
+	activeTargetGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_active_target",
+		Help: "Endpoint of the target that served the probe, set to 1",
+	}, []string{"rpc", "target"})
+	// HTTP connections are reused across probes for the module's
+	// client_idle_ttl.
+	clientCacheHitsGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_rpc_client_cache_hits",
+		Help: "Number of endpoints the probe reached over connections kept from earlier probes",
+	}, []string{"rpc"})
+	// Endpoints terminating TLS themselves are covered like by the http
+	// prober, for the endpoint that served the probe.
+	sslEarliestCertExpiryGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_jsonrpc_ssl_earliest_cert_expiry",
+		Help: helpSSLEarliestCertExpiry,
+	}, []string{"rpc"})
+	registry.MustRegister(billableRequestsGaugeVec)
+	registry.MustRegister(bytesAllocatedGaugeVec)
+	registry.MustRegister(clientCacheHitsGaugeVec)
+	registry.MustRegister(sslEarliestCertExpiryGaugeVec)
+	registry.MustRegister(latencyRegressionGaugeVec)
+	registry.MustRegister(activeTargetGaugeVec)
+
+	// HTTP clients connect lazily, for them the dial phase is only the
+	// client setup and connecting is part of the first call.
+	durationGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_ethrpc_duration_seconds",
+		Help: "Duration of the phases of the probe by module",
+	}, []string{"rpc", "module", "phase"})
+	registry.MustRegister(durationGaugeVec)
+	var (
+		eth           *ethclient.Client
+		ethTransport  *probeTransport
+		transports    []*probeTransport
+		dialDuration  time.Duration
+		chainIdBigInt *big.Int
+	)
+	start := time.Now()
+	defer func() {
+		var billable int
+		var responseBytes int64
+		for _, t := range transports {
+			billable += t.Count()
+			responseBytes += t.ResponseBytes()
+		}
+		durationGaugeVec.WithLabelValues(target, params.Get("module"), "call").Set((time.Since(start) - dialDuration).Seconds())
+		billableRequestsGaugeVec.WithLabelValues(target).Set(float64(billable))
+		bytesAllocatedGaugeVec.WithLabelValues(target).Set(float64(responseBytes))
+		if ethTransport != nil {
+			if state := ethTransport.TLSState(); state != nil {
+				sslEarliestCertExpiryGaugeVec.WithLabelValues(target).Set(float64(getEarliestCertExpiry(state).Unix()))
+			}
+		}
+		// Failed probes often return early, they are kept out of the
+		// baseline.
+		if !success {
+			return
+		}
+		if ratio, ok := rpcLatencyBaseline.observe(target+"|"+params.Get("module"), time.Since(start), time.Now()); ok {
+			latencyRegressionGaugeVec.WithLabelValues(target).Set(ratio)
+		}
+	}()
+	// The endpoints are tried in order, the first one answering eth_chainId
+	// serves the rest of the probe.
+	for _, endpoint := range endpoints {
+		dialStart := time.Now()
+		client, transport, err := dialETHRPC(ctx, endpoint, headers, module)
+		dialDuration += time.Since(dialStart)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error dialing rpc", endpoint, err)
+			rpcFailed("", "", rpcErrorDial)
+			continue
+		}
+		transports = append(transports, transport)
+		retryPolicy.retryAfter = transport.RetryAfter
+		if transport.cached {
+			clientCacheHitsGaugeVec.WithLabelValues(target).Inc()
+		}
+		err = withRetries("", func() (err error) {
+			chainIdBigInt, err = client.ChainID(ctx)
+			return err
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "get chainId failed ! "+err.Error(), "endpoint", endpoint)
+			rpcCallFailed("eth_chainId", "", err)
+			client.Close()
+			continue
+		}
+		callSucceeded("eth_chainId", "")
+		eth, ethTransport = client, transport
+		transportGaugeVec.WithLabelValues(target, ethRPCTransport(endpoint)).Set(1)
+		activeTargetGaugeVec.WithLabelValues(target, endpoint).Set(1)
+		trace.setTarget(endpoint)
+		break
+	}
+	durationGaugeVec.WithLabelValues(target, params.Get("module"), "dial").Set(dialDuration.Seconds())
+	if eth == nil {
 		return false
 	}
+	defer eth.Close()
 	chainId := strconv.FormatInt(chainIdBigInt.Int64(), 10)
+	trace.add("eth_chainId", hexutil.EncodeBig(chainIdBigInt), chainId, "")
+	rawResult("eth_chainId", "", hexutil.EncodeBig(chainIdBigInt))
+
+	// batchCall sends batch with retries and reports the outcome of each of
+	// its calls by method and tag, a failed batch failing them all. The
+	// errors of the elements are left to the caller to log.
+	reportBatch := func(tag string, batch []rpc.BatchElem, err error) error {
+		if err != nil {
+			for _, e := range batch {
+				rpcCallFailed(e.Method, tag, err)
+			}
+			return err
+		}
+		// Failures are reported last, so that a method called several
+		// times is failed if any of its calls is.
+		for _, e := range batch {
+			if e.Error == nil {
+				callSucceeded(e.Method, tag)
+			}
+		}
+		for _, e := range batch {
+			if e.Error != nil {
+				rpcCallFailed(e.Method, tag, e.Error)
+			}
+		}
+		return nil
+	}
+	batchCall := func(tag string, batch []rpc.BatchElem) error {
+		return reportBatch(tag, batch, withRetries(tag, func() error {
+			return eth.Client().BatchCallContext(ctx, batch)
+		}))
+	}
+	// call makes a single call with retries and reports its outcome.
+	call := func(tag string, result interface{}, method string, args ...interface{}) error {
+		err := withRetries(tag, func() error {
+			return eth.Client().CallContext(ctx, result, method, args...)
+		})
+		if err != nil {
+			rpcCallFailed(method, tag, err)
+			return err
+		}
+		callSucceeded(method, tag)
+		return nil
+	}
+	// latestBlockNumber reads eth_blockNumber through call.
+	latestBlockNumber := func() (uint64, error) {
+		var n hexutil.Uint64
+		err := call("", &n, "eth_blockNumber")
+		return uint64(n), err
+	}
 
 	switch params.Get("module") {
 	case "chain_info":
@@ -70,285 +462,2407 @@ func ProbeETHRPC(ctx context.Context, target string, params url.Values, module c
 				Name: "probe_ethrpc_block_number",
 				Help: "",
 			}, []string{"rpc", "chainId"})
+			blockTimestampGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_block_timestamp",
+				Help: "Unix timestamp of the latest block",
+			}, []string{"rpc", "chainId"})
+			blockLagGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_block_lag_seconds",
+				Help: "Seconds between now and the timestamp of the latest block",
+			}, []string{"rpc", "chainId"})
+			peerCountGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_peer_count",
+				Help: "Number of peers of the node, as reported by net_peerCount",
+			}, []string{"rpc", "chainId"})
+			syncingGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_syncing",
+				Help: "1 if eth_syncing reports the node as syncing, 0 otherwise",
+			}, []string{"rpc", "chainId"})
+			syncCurrentBlockGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_sync_current_block",
+				Help: "Current block of a syncing node",
+			}, []string{"rpc", "chainId"})
+			syncHighestBlockGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_sync_highest_block",
+				Help: "Highest block known to a syncing node",
+			}, []string{"rpc", "chainId"})
 		)
 		registry.MustRegister(gasPriceGaugeVec)
 		registry.MustRegister(blockNumberGaugeVec)
-		gasPrice, err := eth.SuggestGasPrice(ctx)
+		registry.MustRegister(blockTimestampGaugeVec)
+		registry.MustRegister(blockLagGaugeVec)
+		registry.MustRegister(peerCountGaugeVec)
+		registry.MustRegister(syncingGaugeVec)
+		registry.MustRegister(syncCurrentBlockGaugeVec)
+		registry.MustRegister(syncHighestBlockGaugeVec)
+		failed := false
+		var gasPrice *big.Int
+		err = withRetries("", func() (err error) {
+			gasPrice, err = eth.SuggestGasPrice(ctx)
+			return err
+		})
 		if err != nil {
 			level.Error(logger).Log("msg", "get gas price failed! "+err.Error())
+			rpcCallFailed("eth_gasPrice", "", err)
+			failed = true
+		} else {
+			callSucceeded("eth_gasPrice", "")
+			rawResult("eth_gasPrice", "", hexutil.EncodeBig(gasPrice))
+			price, _ := new(big.Float).SetInt(gasPrice).Float64()
+			gasPriceGaugeVec.WithLabelValues(target, chainId).Set(price)
 		}
-		blockNumber, err := eth.BlockNumber(ctx)
+		var blockNumber uint64
+		err = withRetries("", func() (err error) {
+			blockNumber, err = eth.BlockNumber(ctx)
+			return err
+		})
 		if err != nil {
 			level.Error(logger).Log("msg", "get block number failed! "+err.Error())
+			rpcCallFailed("eth_blockNumber", "", err)
+			failed = true
+		} else {
+			callSucceeded("eth_blockNumber", "")
+			rawResult("eth_blockNumber", "", hexutil.EncodeUint64(blockNumber))
+			blockNumberGaugeVec.WithLabelValues(target, chainId).Set(float64(blockNumber))
 		}
 
-		gasPriceGaugeVec.WithLabelValues(target, chainId).Set(float64(gasPrice.Int64()))
-		blockNumberGaugeVec.WithLabelValues(target, chainId).Set(float64(blockNumber))
+		var head rpcBlockHeader
+		err = withRetries("latest", func() error {
+			return eth.Client().CallContext(ctx, &head, "eth_getBlockByNumber", "latest", false)
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "get latest block failed, "+err.Error())
+			rpcCallFailed("eth_getBlockByNumber", "latest", err)
+		} else {
+			callSucceeded("eth_getBlockByNumber", "latest")
+			blockTime := time.Unix(int64(head.Timestamp), 0)
+			blockTimestampGaugeVec.WithLabelValues(target, chainId).Set(float64(blockTime.Unix()))
+			blockLagGaugeVec.WithLabelValues(target, chainId).Set(time.Since(blockTime).Seconds())
+		}
 
-	case "balance":
+		var peerCount hexutil.Uint64
+		err = eth.Client().CallContext(ctx, &peerCount, "net_peerCount")
+		if err != nil {
+			// Most public providers do not expose the net namespace.
+			level.Debug(logger).Log("msg", "net_peerCount unavailable, skipping peer count, "+err.Error())
+			callSuccessGaugeVec.WithLabelValues(target, "net_peerCount", "").Set(0)
+		} else {
+			callSucceeded("net_peerCount", "")
+			peerCountGaugeVec.WithLabelValues(target, chainId).Set(float64(peerCount))
+			rawResult("net_peerCount", "", peerCount.String())
+		}
+
+		// The client version has no numeric form, it is only read to be
+		// exported raw.
+		if emitRaw {
+			var clientVersion string
+			if err := eth.Client().CallContext(ctx, &clientVersion, "web3_clientVersion"); err != nil {
+				level.Error(logger).Log("msg", "get client version failed, "+err.Error())
+				rpcCallFailed("web3_clientVersion", "", err)
+			} else {
+				callSucceeded("web3_clientVersion", "")
+				rawResult("web3_clientVersion", "", clientVersion)
+			}
+		}
+
+		// eth_syncing returns false, or an object describing the sync.
+		var syncing json.RawMessage
+		err = withRetries("", func() error {
+			return eth.Client().CallContext(ctx, &syncing, "eth_syncing")
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "get syncing status failed, "+err.Error())
+			rpcCallFailed("eth_syncing", "", err)
+		} else if string(syncing) == "false" {
+			callSucceeded("eth_syncing", "")
+			syncingGaugeVec.WithLabelValues(target, chainId).Set(0)
+		} else {
+			var progress struct {
+				CurrentBlock hexutil.Uint64 `json:"currentBlock"`
+				HighestBlock hexutil.Uint64 `json:"highestBlock"`
+			}
+			if err := json.Unmarshal(syncing, &progress); err != nil {
+				level.Error(logger).Log("msg", "unexpected eth_syncing result "+string(syncing)+", "+err.Error())
+				rpcFailed("eth_syncing", "", rpcErrorDecode)
+			} else {
+				callSucceeded("eth_syncing", "")
+				syncingGaugeVec.WithLabelValues(target, chainId).Set(1)
+				syncCurrentBlockGaugeVec.WithLabelValues(target, chainId).Set(float64(progress.CurrentBlock))
+				syncHighestBlockGaugeVec.WithLabelValues(target, chainId).Set(float64(progress.HighestBlock))
+			}
+		}
+		if failed {
+			return false
+		}
+
+	case "gas_price":
 		var (
-			balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-				Name: "probe_ethrpc_balance",
-				Help: "",
-			}, []string{"rpc", "chainId", "accountAddress", "accountName"})
+			gasPriceGweiGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_gas_price_gwei",
+				Help: "Gas price returned by eth_gasPrice in gwei",
+			}, []string{"rpc", "chainId", "feeType"})
+			gasPriceWeiGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_gas_price_wei",
+				Help: "Gas price returned by eth_gasPrice in wei",
+			}, []string{"rpc", "chainId", "feeType"})
+			gasPriceUSDGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_gas_price_usd",
+				Help: "Gas price returned by eth_gasPrice in USD, valued at the price of the native token's Chainlink feed",
+			}, []string{"rpc", "chainId", "feeType"})
+			baseFeePerGasGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_base_fee_per_gas",
+				Help: "Base fee per gas of the latest block in gwei",
+			}, []string{"rpc", "chainId"})
 		)
-		registry.MustRegister(balanceGaugeVec)
-		accounts := params["account"]
-		if len(accounts) == 0 {
-			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+		registry.MustRegister(gasPriceGweiGaugeVec)
+		registry.MustRegister(gasPriceWeiGaugeVec)
+		registry.MustRegister(baseFeePerGasGaugeVec)
+
+		// With feed, the USD Chainlink feed of the native token, the gas
+		// price is also valued in USD. decimals are those of the native
+		// token.
+		feed := params.Get("feed")
+		decimals := 18
+		if feed != "" {
+			if !common.IsHexAddress(feed) {
+				level.Error(logger).Log("msg", "feed '"+feed+"' is not a valid address")
+				return false
+			}
+			if d := params.Get("decimals"); d != "" {
+				decimals, err = strconv.Atoi(d)
+				if err != nil || decimals < 0 {
+					level.Error(logger).Log("msg", "decimals '"+d+"' is not valid")
+					return false
+				}
+			}
+			registry.MustRegister(gasPriceUSDGaugeVec)
+		}
+
+		// Both calls are made even if the first fails, so that each reports
+		// its own probe_jsonrpc_call_success.
+		var gasPrice hexutil.Big
+		failed := false
+		if err := eth.Client().CallContext(ctx, &gasPrice, "eth_gasPrice"); err != nil {
+			level.Error(logger).Log("msg", "get gas price failed! "+err.Error())
+			rpcCallFailed("eth_gasPrice", "", err)
+			failed = true
+		} else {
+			callSucceeded("eth_gasPrice", "")
+		}
+		var head rpcBlockHeader
+		if err := eth.Client().CallContext(ctx, &head, "eth_getBlockByNumber", "latest", false); err != nil {
+			level.Error(logger).Log("msg", "get latest block failed, "+err.Error())
+			rpcCallFailed("eth_getBlockByNumber", "latest", err)
+			failed = true
+		} else {
+			callSucceeded("eth_getBlockByNumber", "latest")
+		}
+		if failed {
 			return false
 		}
-		var batch []rpc.BatchElem
-		var validAccounts []ValidAccount
-		for _, a := range accounts {
-			aa := strings.Split(a, ":")
-			if len(aa) != 2 {
-				level.Error(logger).Log("msg", "account params format is invalid, SKIP! valid format: accountName:accountAddress")
-				continue
+		// Chains without EIP-1559 have no base fee, eth_gasPrice is then the
+		// legacy gas price.
+		feeType := "legacy"
+		if head.BaseFeePerGas != nil {
+			feeType = "eip1559"
+			baseFee, _ := weiToGwei(head.BaseFeePerGas.ToInt()).Float64()
+			baseFeePerGasGaugeVec.WithLabelValues(target, chainId).Set(baseFee)
+		}
+		price, _ := weiToGwei(gasPrice.ToInt()).Float64()
+		gasPriceGweiGaugeVec.WithLabelValues(target, chainId, feeType).Set(price)
+		wei, _ := new(big.Float).SetInt(gasPrice.ToInt()).Float64()
+		gasPriceWeiGaugeVec.WithLabelValues(target, chainId, feeType).Set(wei)
+		if feed != "" {
+			usdPrice, err := readChainlinkPrice(ctx, eth.Client(), feed)
+			if err != nil {
+				level.Error(logger).Log("msg", "read native token price failed, "+err.Error(), "feed", feed)
+				return false
 			}
-			if !common.IsHexAddress(aa[1]) {
-				level.Error(logger).Log("msg", "account address "+aa[1]+" is invalid, SKIP this account!")
-				continue
+			native := new(big.Float).Quo(new(big.Float).SetInt(gasPrice.ToInt()), new(big.Float).SetInt(pow10(decimals)))
+			usd, _ := native.Mul(native, big.NewFloat(usdPrice)).Float64()
+			gasPriceUSDGaugeVec.WithLabelValues(target, chainId, feeType).Set(usd)
+		}
+
+	case "congestion":
+		var (
+			congestionScoreGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_congestion_score",
+				Help: "Weighted network congestion score between 0 and 1",
+			}, []string{"rpc", "chainId"})
+			baseFeeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_congestion_base_fee_gwei",
+				Help: "Base fee of the latest block in gwei",
+			}, []string{"rpc", "chainId"})
+			gasUsedRatioGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_congestion_gas_used_ratio",
+				Help: "gasUsed / gasLimit of the latest block",
+			}, []string{"rpc", "chainId"})
+			txpoolPendingGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_congestion_txpool_pending",
+				Help: "Number of pending transactions in the node's txpool",
+			}, []string{"rpc", "chainId"})
+		)
+		registry.MustRegister(congestionScoreGaugeVec)
+		registry.MustRegister(baseFeeGaugeVec)
+		registry.MustRegister(gasUsedRatioGaugeVec)
+		registry.MustRegister(txpoolPendingGaugeVec)
+
+		var weights [3]float64
+		var refs [2]float64
+		for i, p := range []struct {
+			name string
+			def  float64
+			dst  *float64
+		}{
+			{"baseFeeWeight", 0.4, &weights[0]},
+			{"gasUsedWeight", 0.4, &weights[1]},
+			{"txpoolWeight", 0.2, &weights[2]},
+			{"baseFeeRef", 100, &refs[0]},
+			{"txpoolRef", 10000, &refs[1]},
+		} {
+			v, err := floatParam(params, p.name, p.def)
+			if err != nil || v < 0 || (i >= 3 && v == 0) {
+				level.Error(logger).Log("msg", "invalid "+p.name+" param", "value", params.Get(p.name))
+				return false
 			}
-			if len(aa[0]) == 0 {
-				level.Error(logger).Log("msg", "account name "+aa[1]+" is invalid, SKIP this account!")
-				continue
+			*p.dst = v
+		}
+
+		var head rpcBlockHeader
+		err = eth.Client().CallContext(ctx, &head, "eth_getBlockByNumber", "latest", false)
+		if err != nil {
+			level.Error(logger).Log("msg", "get latest block failed, "+err.Error())
+			return false
+		}
+		if head.BaseFeePerGas == nil || head.GasLimit == 0 {
+			level.Error(logger).Log("msg", "latest block has no baseFeePerGas or gasLimit, chain does not look EIP-1559 enabled")
+			return false
+		}
+		baseFee, _ := weiToGwei(head.BaseFeePerGas.ToInt()).Float64()
+		gasUsedRatio := float64(head.GasUsed) / float64(head.GasLimit)
+		baseFeeGaugeVec.WithLabelValues(target, chainId).Set(baseFee)
+		gasUsedRatioGaugeVec.WithLabelValues(target, chainId).Set(gasUsedRatio)
+
+		components := []congestionComponent{
+			{Value: baseFee / refs[0], Weight: weights[0]},
+			{Value: gasUsedRatio, Weight: weights[1]},
+		}
+		var txpool struct {
+			Pending hexutil.Uint64 `json:"pending"`
+		}
+		err = eth.Client().CallContext(ctx, &txpool, "txpool_status")
+		if err != nil {
+			// Most public providers do not expose the txpool namespace, the
+			// score is then computed from the block based signals only.
+			level.Debug(logger).Log("msg", "txpool_status unavailable, leaving it out of the score, "+err.Error())
+		} else {
+			txpoolPendingGaugeVec.WithLabelValues(target, chainId).Set(float64(txpool.Pending))
+			components = append(components, congestionComponent{Value: float64(txpool.Pending) / refs[1], Weight: weights[2]})
+		}
+		congestionScoreGaugeVec.WithLabelValues(target, chainId).Set(congestionScore(components))
+
+	case "net_chain_check":
+		var (
+			netChainMismatchGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_net_chain_mismatch",
+				Help: "1 if net_version and eth_chainId disagree, 0 otherwise",
+			}, []string{"rpc", "chainId"})
+			netChainInfoGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_net_chain_info",
+				Help: "Network id and chain id reported by the node",
+			}, []string{"rpc", "chainId", "netVersion"})
+		)
+		registry.MustRegister(netChainMismatchGaugeVec)
+		registry.MustRegister(netChainInfoGaugeVec)
+
+		var netVersion string
+		if err := eth.Client().CallContext(ctx, &netVersion, "net_version"); err != nil {
+			level.Error(logger).Log("msg", "get net_version failed! "+err.Error())
+			rpcCallFailed("net_version", "", err)
+			return false
+		}
+		callSucceeded("net_version", "")
+		netChainInfoGaugeVec.WithLabelValues(target, chainId, netVersion).Set(1)
+		rawResult("net_version", "", netVersion)
+		// Some nodes report net_version in hex, compare numerically.
+		networkId, ok := parseNetVersion(netVersion)
+		if !ok {
+			level.Error(logger).Log("msg", "net_version '"+netVersion+"' is not a number")
+			return false
+		}
+		if networkId.Cmp(chainIdBigInt) != 0 {
+			level.Warn(logger).Log("msg", "net_version and eth_chainId disagree", "netVersion", netVersion, "chainId", chainId)
+			netChainMismatchGaugeVec.WithLabelValues(target, chainId).Set(1)
+		} else {
+			netChainMismatchGaugeVec.WithLabelValues(target, chainId).Set(0)
+		}
+
+	case "ws_subscription_liveness":
+		// By default the events are counted over the window, with
+		// until=first the probe stops at the first one, waiting for it up
+		// to the probe timeout, and times it.
+		var (
+			eventsReceivedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ws_events_received",
+				Help: "Number of subscription events received during the window",
+			}, []string{"rpc", "chainId", "subscription"})
+			subscriptionAliveGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ws_subscription_alive",
+				Help: "Whether the subscription delivered at least one event during the window",
+			}, []string{"rpc", "chainId", "subscription"})
+			subscriptionReceivedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_subscription_received",
+				Help: "Whether the subscription delivered a notification before the probe timed out",
+			}, []string{"rpc", "chainId", "subscription"})
+			firstNotificationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_first_notification_seconds",
+				Help: "Seconds between the subscription and its first notification",
+			}, []string{"rpc", "chainId", "subscription"})
+		)
+		untilFirst := false
+		switch until := params.Get("until"); until {
+		case "", "window":
+			registry.MustRegister(eventsReceivedGaugeVec)
+			registry.MustRegister(subscriptionAliveGaugeVec)
+		case "first":
+			untilFirst = true
+			registry.MustRegister(subscriptionReceivedGaugeVec)
+			registry.MustRegister(firstNotificationGaugeVec)
+		default:
+			level.Error(logger).Log("msg", "until '"+until+"' is not valid, must be window or first")
+			return false
+		}
+
+		subscription := params.Get("subscription")
+		if subscription == "" {
+			subscription = "newHeads"
+		}
+		if subscription != "newHeads" && subscription != "logs" {
+			level.Error(logger).Log("msg", "unsupported subscription "+subscription+", valid values: newHeads, logs")
+			return false
+		}
+		window := 10 * time.Second
+		if w := params.Get("window"); w != "" {
+			window, err = time.ParseDuration(w)
+			if err != nil || window <= 0 {
+				level.Error(logger).Log("msg", "invalid window param", "window", w)
+				return false
 			}
-			var result string
-			batch = append(batch, rpc.BatchElem{
-				Method: "eth_getBalance",
-				Args:   []interface{}{aa[1], "latest"},
-				Result: &result,
-				Error:  nil,
-			})
-			validAccounts = append(validAccounts, ValidAccount{
-				AccountName:    aa[0],
-				AccountAddress: aa[1],
-			})
 		}
+		// The window never outlives the probe timeout, which is the window
+		// of until=first unless one is given.
+		var (
+			windowCtx context.Context
+			cancel    context.CancelFunc
+		)
+		if untilFirst && params.Get("window") == "" {
+			windowCtx, cancel = context.WithCancel(ctx)
+		} else {
+			windowCtx, cancel = context.WithTimeout(ctx, window)
+		}
+		defer cancel()
 
-		err = eth.Client().BatchCall(batch)
+		// Events are decoded as raw JSON, only their arrival is of interest.
+		events := make(chan json.RawMessage, 16)
+		args := []interface{}{subscription}
+		if subscription == "logs" {
+			args = append(args, map[string]interface{}{})
+		}
+		sub, err := eth.Client().EthSubscribe(windowCtx, events, args...)
 		if err != nil {
-			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			level.Error(logger).Log("msg", "eth_subscribe failed, "+err.Error())
 			return false
 		}
-		for i, e := range batch {
-			r := *e.Result.(*string)
-			level.Debug(logger).Log("msg", "result "+r)
-			r = strings.ReplaceAll(r, "0x", "")
-			var value float64
-			n := new(big.Int)
-			n.SetString(r, 16)
-			value, _ = weiToEther(n).Float64()
-			balanceGaugeVec.WithLabelValues(
-				target,
-				chainId,
-				validAccounts[i].AccountAddress,
-				validAccounts[i].AccountName,
-			).Set(value)
+		defer sub.Unsubscribe()
+		subscribed := time.Now()
+
+		received := 0
+	wait:
+		for {
+			select {
+			case <-events:
+				received++
+				if untilFirst {
+					firstNotificationGaugeVec.WithLabelValues(target, chainId, subscription).Set(time.Since(subscribed).Seconds())
+					break wait
+				}
+			case err := <-sub.Err():
+				if err != nil {
+					level.Error(logger).Log("msg", "subscription dropped, "+err.Error())
+				}
+				break wait
+			case <-windowCtx.Done():
+				break wait
+			}
+		}
+		level.Debug(logger).Log("msg", "subscription window closed", "subscription", subscription, "received", received)
+		alive := 0.0
+		if received > 0 {
+			alive = 1
+		}
+		if untilFirst {
+			subscriptionReceivedGaugeVec.WithLabelValues(target, chainId, subscription).Set(alive)
+		} else {
+			eventsReceivedGaugeVec.WithLabelValues(target, chainId, subscription).Set(float64(received))
+			subscriptionAliveGaugeVec.WithLabelValues(target, chainId, subscription).Set(alive)
+		}
+		if received == 0 {
+			return false
+		}
+
+	case "logs_consistency":
+		var (
+			logsConsistentGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_logs_consistent",
+				Help: "Whether eth_getLogs and eth_getFilterLogs returned the same logs",
+			}, []string{"rpc", "chainId"})
+			logsCountGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_logs_count",
+				Help: "Number of logs returned for the probed block range",
+			}, []string{"rpc", "chainId", "method"})
+			filterSupportedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_logs_filter_supported",
+				Help: "Whether the endpoint supports eth_newFilter",
+			}, []string{"rpc", "chainId"})
+		)
+		registry.MustRegister(logsConsistentGaugeVec)
+		registry.MustRegister(logsCountGaugeVec)
+		registry.MustRegister(filterSupportedGaugeVec)
+
+		blocks := uint64(10)
+		if b := params.Get("blocks"); b != "" {
+			blocks, err = strconv.ParseUint(b, 10, 64)
+			if err != nil || blocks == 0 {
+				level.Error(logger).Log("msg", "blocks '"+b+"' is not valid")
+				return false
+			}
+		}
+		latest, err := eth.BlockNumber(ctx)
+		if err != nil {
+			level.Error(logger).Log("msg", "get block number failed! "+err.Error())
+			return false
+		}
+		// Both paths query the same fixed range so that new blocks arriving
+		// in between do not show up as an inconsistency.
+		from := uint64(0)
+		if latest >= blocks {
+			from = latest - blocks + 1
+		}
+		criteria := map[string]interface{}{
+			"fromBlock": hexutil.Uint64(from),
+			"toBlock":   hexutil.Uint64(latest),
+		}
+		if addresses := params["address"]; len(addresses) > 0 {
+			criteria["address"] = addresses
+		}
+		if topic := params.Get("topic"); topic != "" {
+			criteria["topics"] = []interface{}{topic}
+		}
+
+		var logs []rpcLog
+		if err := eth.Client().CallContext(ctx, &logs, "eth_getLogs", criteria); err != nil {
+			level.Error(logger).Log("msg", "eth_getLogs failed, "+err.Error())
+			return false
+		}
+		logsCountGaugeVec.WithLabelValues(target, chainId, "eth_getLogs").Set(float64(len(logs)))
+
+		var filterID string
+		if err := eth.Client().CallContext(ctx, &filterID, "eth_newFilter", criteria); err != nil {
+			// Many load balanced providers do not support stateful filters,
+			// there is nothing to compare against then.
+			level.Debug(logger).Log("msg", "eth_newFilter unsupported, skipping consistency check, "+err.Error())
+			filterSupportedGaugeVec.WithLabelValues(target, chainId).Set(0)
+			break
+		}
+		filterSupportedGaugeVec.WithLabelValues(target, chainId).Set(1)
+		var filterLogs []rpcLog
+		err = eth.Client().CallContext(ctx, &filterLogs, "eth_getFilterLogs", filterID)
+		var uninstalled bool
+		if err := eth.Client().CallContext(ctx, &uninstalled, "eth_uninstallFilter", filterID); err != nil {
+			level.Debug(logger).Log("msg", "eth_uninstallFilter failed, "+err.Error())
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "eth_getFilterLogs failed, "+err.Error())
+			return false
+		}
+		logsCountGaugeVec.WithLabelValues(target, chainId, "eth_getFilterLogs").Set(float64(len(filterLogs)))
+
+		if !sameLogs(logs, filterLogs) {
+			level.Warn(logger).Log("msg", "eth_getLogs and eth_getFilterLogs disagree", "getLogs", len(logs), "getFilterLogs", len(filterLogs))
+			logsConsistentGaugeVec.WithLabelValues(target, chainId).Set(0)
+		} else {
+			logsConsistentGaugeVec.WithLabelValues(target, chainId).Set(1)
+		}
+
+	case "logs":
+		var (
+			logCountGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_log_count",
+				Help: "Number of logs matching address and topic0 in the probed block range",
+			}, []string{"rpc", "chainId", "address", "topic0"})
+		)
+		registry.MustRegister(logCountGaugeVec)
+		address := params.Get("address")
+		if !common.IsHexAddress(address) {
+			level.Error(logger).Log("msg", "address '"+address+"' is not valid")
+			return false
+		}
+		// topic0 may be given as an event signature or a topic hash.
+		topic0 := params.Get("topic0")
+		criteria := map[string]interface{}{"address": address}
+		if topic0 != "" {
+			topic := crypto.Keccak256Hash([]byte(topic0))
+			if len(topic0) == 66 && strings.HasPrefix(topic0, "0x") {
+				topic = common.HexToHash(topic0)
+			}
+			criteria["topics"] = []interface{}{topic}
+		}
+
+		// The range is either fromBlock to toBlock, latest by default, or
+		// the last lookback blocks.
+		toBlock, err := parseBlockTag(params.Get("toBlock"))
+		if err != nil {
+			level.Error(logger).Log("msg", err.Error())
+			return false
+		}
+		if f := params.Get("fromBlock"); f != "" {
+			fromBlock, err := parseBlockTag(f)
+			if err != nil {
+				level.Error(logger).Log("msg", err.Error())
+				return false
+			}
+			criteria["fromBlock"] = fromBlock
+			criteria["toBlock"] = toBlock
+		} else {
+			lookback := uint64(100)
+			if l := params.Get("lookback"); l != "" {
+				lookback, err = strconv.ParseUint(l, 10, 64)
+				if err != nil || lookback == 0 || lookback > maxEventSearchBlocks {
+					level.Error(logger).Log("msg", "lookback '"+l+"' is not valid, must be between 1 and "+strconv.Itoa(maxEventSearchBlocks))
+					return false
+				}
+			}
+			latest, err := eth.BlockNumber(ctx)
+			if err != nil {
+				level.Error(logger).Log("msg", "get block number failed! "+err.Error())
+				rpcCallFailed("eth_blockNumber", "", err)
+				return false
+			}
+			from := uint64(0)
+			if latest >= lookback {
+				from = latest - lookback + 1
+			}
+			criteria["fromBlock"] = hexutil.Uint64(from)
+			criteria["toBlock"] = hexutil.Uint64(latest)
+			toBlock = hexutil.EncodeUint64(latest)
+		}
+
+		var logs []rpcLog
+		err = withRetries(toBlock, func() error {
+			return eth.Client().CallContext(ctx, &logs, "eth_getLogs", criteria)
+		})
+		if err != nil {
+			// Providers cap the block range or the number of results of
+			// eth_getLogs, answering -32005.
+			if code, ok := rpcErrorCode(err); ok && code == -32005 {
+				level.Error(logger).Log("msg", "eth_getLogs exceeded the provider's limit, narrow the block range, "+err.Error())
+			} else {
+				level.Error(logger).Log("msg", "eth_getLogs failed, "+err.Error())
+			}
+			rpcCallFailed("eth_getLogs", toBlock, err)
+			return false
+		}
+		logCountGaugeVec.WithLabelValues(target, chainId, addressLabel(address), topic0).Set(float64(len(logs)))
+	case "contract_gas":
+		var (
+			contractAvgGasGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_contract_avg_gas",
+				Help: "Average gas used by transactions to the contract over the scanned blocks, NaN if there were none",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+			contractCallsGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_contract_calls",
+				Help: "Number of transactions to the contract over the scanned blocks",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+		)
+		registry.MustRegister(contractAvgGasGaugeVec)
+		registry.MustRegister(contractCallsGaugeVec)
+		contracts := params["contract"]
+		if len(contracts) == 0 {
+			level.Error(logger).Log("msg", "no contracts specified! format: contractName:contractAddress")
+			return false
+		}
+		validContracts := parseNamedAddresses(contracts, "contract", logger)
+		if len(validContracts) == 0 {
+			return false
+		}
+
+		blocks := uint64(10)
+		if b := params.Get("blocks"); b != "" {
+			blocks, err = strconv.ParseUint(b, 10, 64)
+			if err != nil || blocks == 0 || blocks > maxContractGasBlocks {
+				level.Error(logger).Log("msg", "blocks '"+b+"' is not valid, must be between 1 and "+strconv.Itoa(maxContractGasBlocks))
+				return false
+			}
+		}
+		latest, err := eth.BlockNumber(ctx)
+		if err != nil {
+			level.Error(logger).Log("msg", "get block number failed! "+err.Error())
+			return false
+		}
+		from := uint64(0)
+		if latest >= blocks {
+			from = latest - blocks + 1
+		}
+
+		var batch []rpc.BatchElem
+		for n := from; n <= latest; n++ {
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getBlockByNumber",
+				Args:   []interface{}{hexutil.Uint64(n), true},
+				Result: new(rpcBlockTxs),
+			})
+		}
+		if err := batchCallChunked(ctx, eth.Client(), batch, contractGasBatchSize); err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		watched := make(map[common.Address]bool)
+		for _, c := range validContracts {
+			watched[common.HexToAddress(c.AccountAddress)] = true
+		}
+		var receipts []rpc.BatchElem
+		var receiptTo []common.Address
+		for _, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get block failed, "+e.Error.Error())
+				return false
+			}
+			for _, tx := range e.Result.(*rpcBlockTxs).Transactions {
+				if tx.To == nil || !watched[*tx.To] {
+					continue
+				}
+				receipts = append(receipts, rpc.BatchElem{
+					Method: "eth_getTransactionReceipt",
+					Args:   []interface{}{tx.Hash},
+					Result: new(rpcReceipt),
+				})
+				receiptTo = append(receiptTo, *tx.To)
+			}
+		}
+		if err := batchCallChunked(ctx, eth.Client(), receipts, contractGasBatchSize); err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		gasUsed := make(map[common.Address]uint64)
+		calls := make(map[common.Address]uint64)
+		for i, e := range receipts {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get receipt failed, "+e.Error.Error(), "txhash", e.Args[0].(common.Hash).Hex())
+				return false
+			}
+			gasUsed[receiptTo[i]] += uint64(e.Result.(*rpcReceipt).GasUsed)
+			calls[receiptTo[i]]++
+		}
+		for _, c := range validContracts {
+			address := common.HexToAddress(c.AccountAddress)
+			avg := math.NaN()
+			if calls[address] > 0 {
+				avg = float64(gasUsed[address]) / float64(calls[address])
+			}
+			contractAvgGasGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName).Set(avg)
+			contractCallsGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName).Set(float64(calls[address]))
+		}
+
+	case "estimate_gas":
+		var (
+			estimateGasGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_estimate_gas",
+				Help: "Gas estimated for the call, NaN if it reverts",
+			}, []string{"rpc", "chainId", "name"})
+		)
+		registry.MustRegister(estimateGasGaugeVec)
+		calls := params["call"]
+		if len(calls) == 0 {
+			level.Error(logger).Log("msg", "no calls specified! format: name:from:to:data")
+			return false
+		}
+		var names []string
+		var batch []rpc.BatchElem
+		for _, c := range calls {
+			cs := strings.Split(c, ":")
+			if len(cs) != 4 || cs[0] == "" {
+				level.Error(logger).Log("msg", "call params format is invalid, SKIP! valid format: name:from:to:data")
+				continue
+			}
+			// from may be left empty, the node then uses the zero address.
+			if (cs[1] != "" && !common.IsHexAddress(cs[1])) || !common.IsHexAddress(cs[2]) {
+				level.Error(logger).Log("msg", "address of call "+cs[0]+" is invalid, SKIP this call!")
+				continue
+			}
+			data, err := hexutil.Decode(cs[3])
+			if err != nil {
+				level.Error(logger).Log("msg", "data of call "+cs[0]+" is invalid, SKIP this call! "+err.Error())
+				continue
+			}
+			msg := map[string]interface{}{"to": cs[2], "data": hexutil.Bytes(data)}
+			if cs[1] != "" {
+				msg["from"] = cs[1]
+			}
+			var result hexutil.Uint64
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_estimateGas",
+				Args:   []interface{}{msg},
+				Result: &result,
+			})
+			names = append(names, cs[0])
+		}
+		err = batchCall("", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			if e.Error != nil {
+				failed = true
+				if reason, reverted := callReverted(e.Error); reverted {
+					level.Error(logger).Log("msg", "estimate gas reverted", "call", names[i])
+					level.Debug(logger).Log("msg", "revert reason", "call", names[i], "reason", reason)
+					estimateGasGaugeVec.WithLabelValues(target, chainId, names[i]).Set(math.NaN())
+					continue
+				}
+				level.Error(logger).Log("msg", "estimate gas failed, "+e.Error.Error(), "call", names[i])
+				continue
+			}
+			gas := uint64(*e.Result.(*hexutil.Uint64))
+			estimateGasGaugeVec.WithLabelValues(target, chainId, names[i]).Set(float64(gas))
+		}
+		if failed {
+			return false
+		}
+
+	case "tx_receipt":
+		var (
+			txStatusGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_tx_status",
+				Help: "Receipt status of the transaction, 1 for success, 0 for revert, NaN if there is no receipt yet",
+			}, []string{"rpc", "chainId", "txhash"})
+			txConfirmationsGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_tx_confirmations",
+				Help: "Latest block number minus the block number of the transaction receipt",
+			}, []string{"rpc", "chainId", "txhash"})
+		)
+		registry.MustRegister(txStatusGaugeVec)
+		registry.MustRegister(txConfirmationsGaugeVec)
+		txHashes := params["txhash"]
+		if len(txHashes) == 0 {
+			level.Error(logger).Log("msg", "no txhash specified!")
+			return false
+		}
+		var batch []rpc.BatchElem
+		var validHashes []string
+		for _, h := range txHashes {
+			if len(h) != 66 || !strings.HasPrefix(h, "0x") {
+				level.Error(logger).Log("msg", "txhash "+h+" is invalid, SKIP this txhash!")
+				continue
+			}
+			if _, err := hex.DecodeString(h[2:]); err != nil {
+				level.Error(logger).Log("msg", "txhash "+h+" is invalid, SKIP this txhash!")
+				continue
+			}
+			var receipt *rpcReceipt
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{h},
+				Result: &receipt,
+			})
+			validHashes = append(validHashes, strings.ToLower(h))
+		}
+		if len(batch) == 0 {
+			return false
+		}
+		blockNumber, err := latestBlockNumber()
+		if err != nil {
+			level.Error(logger).Log("msg", "get block number failed! "+err.Error())
+			return false
+		}
+		err = batchCall("", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get receipt failed, "+e.Error.Error(), "txhash", validHashes[i])
+				failed = true
+				continue
+			}
+			receipt := *e.Result.(**rpcReceipt)
+			// A pending or unknown transaction has no receipt.
+			if receipt == nil || receipt.BlockNumber == nil {
+				level.Error(logger).Log("msg", "no receipt for transaction, it is pending or unknown", "txhash", validHashes[i])
+				txStatusGaugeVec.WithLabelValues(target, chainId, validHashes[i]).Set(math.NaN())
+				failed = true
+				continue
+			}
+			txStatusGaugeVec.WithLabelValues(target, chainId, validHashes[i]).Set(float64(receipt.Status))
+			confirmations := float64(blockNumber) - float64(receipt.BlockNumber.ToInt().Uint64())
+			txConfirmationsGaugeVec.WithLabelValues(target, chainId, validHashes[i]).Set(math.Max(confirmations, 0))
+		}
+		if failed {
+			return false
+		}
+	case "balance":
+		var (
+			balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_balance",
+				Help: "",
+			}, []string{"rpc", "chainId", "accountAddress", "accountName", "block"})
+		)
+		registry.MustRegister(balanceGaugeVec)
+		accounts := params["account"]
+		if len(accounts) == 0 {
+			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+			return false
+		}
+		block, err := parseBlockTag(params.Get("block"))
+		if err != nil {
+			level.Error(logger).Log("msg", err.Error())
+			return false
+		}
+		var batch []rpc.BatchElem
+		var validAccounts []ValidAccount
+		for _, a := range accounts {
+			aa := strings.Split(a, ":")
+			if len(aa) != 2 {
+				level.Error(logger).Log("msg", "account params format is invalid, SKIP! valid format: accountName:accountAddress")
+				continue
+			}
+			if !common.IsHexAddress(aa[1]) {
+				level.Error(logger).Log("msg", "account address "+aa[1]+" is invalid, SKIP this account!")
+				continue
+			}
+			if len(aa[0]) == 0 {
+				level.Error(logger).Log("msg", "account name "+aa[1]+" is invalid, SKIP this account!")
+				continue
+			}
+			var result string
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getBalance",
+				Args:   []interface{}{aa[1], block},
+				Result: &result,
+				Error:  nil,
+			})
+			validAccounts = append(validAccounts, ValidAccount{
+				AccountName:    aa[0],
+				AccountAddress: aa[1],
+			})
+		}
+
+		err = withRetries(block, func() error {
+			return eth.Client().BatchCallContext(ctx, batch)
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			rpcCallFailed("eth_getBalance", block, err)
+			return false
+		}
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get balance failed, "+e.Error.Error(), "account", validAccounts[i].AccountName)
+				rpcCallFailed("eth_getBalance", block, e.Error)
+				continue
+			}
+			r := *e.Result.(*string)
+			level.Debug(logger).Log("msg", "result "+r)
+			r = strings.ReplaceAll(r, "0x", "")
+			var value float64
+			n := new(big.Int)
+			n.SetString(r, 16)
+			value, _ = weiToEther(n).Float64()
+			trace.add("eth_getBalance", *e.Result.(*string), strconv.FormatFloat(value, 'g', -1, 64),
+				traceMetric("probe_ethrpc_balance", "accountName", validAccounts[i].AccountName, "block", block))
+			balanceGaugeVec.WithLabelValues(
+				target,
+				chainId,
+				addressLabel(validAccounts[i].AccountAddress),
+				validAccounts[i].AccountName,
+				block,
+			).Set(value)
+		}
+	case "nonce":
+		var (
+			nonceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_nonce",
+				Help: "Transaction count of the account at the latest block",
+			}, []string{"rpc", "chainId", "accountAddress", "accountName"})
+		)
+		registry.MustRegister(nonceGaugeVec)
+		// With activation=true, nonces are remembered across probes to flag
+		// dormant accounts that start transacting.
+		var accountActivatedGaugeVec *prometheus.GaugeVec
+		if params.Get("activation") == "true" {
+			accountActivatedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_account_activated",
+				Help: "1 if the account nonce went from 0 to non-zero since the previous probe",
+			}, []string{"rpc", "chainId", "accountAddress", "accountName"})
+			registry.MustRegister(accountActivatedGaugeVec)
+		}
+		accounts := params["account"]
+		if len(accounts) == 0 {
+			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+			return false
+		}
+		validAccounts := parseNamedAddresses(accounts, "account", logger)
+		var batch []rpc.BatchElem
+		for _, a := range validAccounts {
+			var result hexutil.Uint64
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getTransactionCount",
+				Args:   []interface{}{a.AccountAddress, "latest"},
+				Result: &result,
+			})
+		}
+
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get transaction count failed, "+e.Error.Error(), "account", validAccounts[i].AccountName)
+				failed = true
+				continue
+			}
+			nonce := uint64(*e.Result.(*hexutil.Uint64))
+			labels := []string{
+				target,
+				chainId,
+				addressLabel(validAccounts[i].AccountAddress),
+				validAccounts[i].AccountName,
+			}
+			nonceGaugeVec.WithLabelValues(labels...).Set(float64(nonce))
+			if accountActivatedGaugeVec == nil {
+				continue
+			}
+			key := target + "|" + strings.ToLower(validAccounts[i].AccountAddress)
+			prev, seen := nonceHistory.swap(key, strconv.FormatUint(nonce, 10), time.Now())
+			activated := 0.0
+			if seen && prev == "0" && nonce > 0 {
+				level.Warn(logger).Log("msg", "dormant account became active", "account", validAccounts[i].AccountName, "nonce", nonce)
+				activated = 1
+			}
+			accountActivatedGaugeVec.WithLabelValues(labels...).Set(activated)
+		}
+		if failed {
+			return false
+		}
+	case "erc20balance":
+		var (
+			erc20balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_erc20balance",
+				Help: "",
+			}, []string{"rpc", "chainId", "accountAddress", "accountName", "tokenSymbol", "tokenAddress"})
+			erc20EventBlockGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_erc20balance_event_block",
+				Help: "Block of the most recent matching event, at which the balances were read",
+			}, []string{"rpc", "chainId", "tokenAddress", "event"})
+			erc20DecimalsGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_erc20_decimals",
+				Help: "Decimals of the token, by which balances are scaled",
+			}, []string{"rpc", "chainId", "tokenAddress", "tokenSymbol"})
+		)
+		registry.MustRegister(erc20balanceGaugeVec)
+		registry.MustRegister(erc20DecimalsGaugeVec)
+		accounts := params["account"]
+		tokenAddress := params.Get("token")
+		tokenSymbol := params.Get("symbol")
+		if len(accounts) == 0 {
+			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+			return false
+		}
+		if tokenAddress == "" {
+			level.Error(logger).Log("msg", "no token contract address given!")
+			return false
+		}
+
+		const erc20AbiDef = `[
+			{"name":"balanceOf","type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+			{"name":"decimals","type":"function","inputs":[],"outputs":[{"name":"","type":"uint8"}]}
+		]`
+		abiObj, err := abi.JSON(strings.NewReader(erc20AbiDef))
+
+		if err != nil {
+			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
+			return false
+		}
+
+		// With an event param, given as a signature or topic hash, balances
+		// are read at the block of the most recent matching log emitted by
+		// eventAddress (the token by default) within the last blocks.
+		block := "latest"
+		if event := params.Get("event"); event != "" {
+			registry.MustRegister(erc20EventBlockGaugeVec)
+			topic := crypto.Keccak256Hash([]byte(event))
+			if len(event) == 66 && strings.HasPrefix(event, "0x") {
+				topic = common.HexToHash(event)
+			}
+			eventAddress := params.Get("eventAddress")
+			if eventAddress == "" {
+				eventAddress = tokenAddress
+			}
+			blocks := uint64(1000)
+			if b := params.Get("blocks"); b != "" {
+				blocks, err = strconv.ParseUint(b, 10, 64)
+				if err != nil || blocks == 0 || blocks > maxEventSearchBlocks {
+					level.Error(logger).Log("msg", "blocks '"+b+"' is not valid, must be between 1 and "+strconv.Itoa(maxEventSearchBlocks))
+					return false
+				}
+			}
+			latest, err := latestBlockNumber()
+			if err != nil {
+				level.Error(logger).Log("msg", "get block number failed! "+err.Error())
+				return false
+			}
+			from := uint64(0)
+			if latest >= blocks {
+				from = latest - blocks + 1
+			}
+			var logs []rpcLog
+			err = call("", &logs, "eth_getLogs", map[string]interface{}{
+				"fromBlock": hexutil.Uint64(from),
+				"toBlock":   hexutil.Uint64(latest),
+				"address":   eventAddress,
+				"topics":    []interface{}{topic},
+			})
+			if err != nil {
+				level.Error(logger).Log("msg", "eth_getLogs failed, "+err.Error())
+				return false
+			}
+			if len(logs) == 0 {
+				level.Error(logger).Log("msg", "no matching event in the last "+strconv.FormatUint(blocks, 10)+" blocks", "event", event)
+				return false
+			}
+			eventBlock := logs[0].BlockNumber
+			for _, l := range logs[1:] {
+				eventBlock = max(eventBlock, l.BlockNumber)
+			}
+			block = hexutil.EncodeUint64(uint64(eventBlock))
+			erc20EventBlockGaugeVec.WithLabelValues(target, chainId, addressLabel(tokenAddress), event).Set(float64(eventBlock))
+		}
+
+		var batch []rpc.BatchElem
+		var multicalls []multicallCall
+		var validAccounts []ValidAccount
+		for _, a := range accounts {
+			aa := strings.Split(a, ":")
+			if len(aa) != 2 {
+				level.Error(logger).Log("msg", "account params format is invalid, SKIP! valid format: accountName:accountAddress")
+				continue
+			}
+			if !common.IsHexAddress(aa[1]) {
+				level.Error(logger).Log("msg", "account address "+aa[1]+" is invalid, SKIP this account!")
+				continue
+			}
+			if len(aa[0]) == 0 {
+				level.Error(logger).Log("msg", "account name "+aa[1]+" is invalid, SKIP this account!")
+				continue
+			}
+
+			callData, err := abiObj.Pack("balanceOf", common.HexToAddress(aa[1]))
+
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				continue
+			}
+
+			callMsg := struct {
+				To   string `json:"to"`
+				Data string `json:"data"`
+			}{
+				To:   tokenAddress,
+				Data: "0x" + hex.EncodeToString(callData),
+			}
+
+			var result string
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_call",
+				Args:   []interface{}{callMsg, block},
+				Result: &result,
+				Error:  nil,
+			})
+			multicalls = append(multicalls, multicallCall{Target: common.HexToAddress(tokenAddress), AllowFailure: true, CallData: callData})
+
+			validAccounts = append(validAccounts, ValidAccount{
+				AccountName:    aa[0],
+				AccountAddress: aa[1],
+			})
+		}
+		// The token's decimals are read once, along with the balances, and
+		// kept for the next scrapes.
+		decimalsKey := target + "|" + strings.ToLower(tokenAddress)
+		cachedDecimals, decimalsCached := tokenDecimals.get(decimalsKey, time.Now())
+		if !decimalsCached {
+			decimalsCallData, err := abiObj.Pack("decimals")
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+			batch = append(batch, newEthCallElem(tokenAddress, decimalsCallData, block))
+			multicalls = append(multicalls, multicallCall{Target: common.HexToAddress(tokenAddress), AllowFailure: true, CallData: decimalsCallData})
+		}
+
+		// With multicall=true all calls are aggregated into one eth_call to
+		// Multicall3, falling back to the batch where it is not deployed.
+		if params.Get("multicall") == "true" {
+			err = reportBatch(block, batch, withRetries(block, func() error {
+				return callViaMulticall(ctx, eth.Client(), params.Get("multicallAddress"), batch, multicalls, block, logger)
+			}))
+		} else {
+			err = batchCall(block, batch)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		// decimals() is optional in ERC20, tokens without it are assumed to
+		// have 18 like ether. Only the decimals read are cached.
+		decimals := uint8(18)
+		if decimalsCached {
+			d, _ := strconv.ParseUint(cachedDecimals, 10, 8)
+			decimals = uint8(d)
+		} else {
+			decimalsElem := batch[len(batch)-1]
+			batch = batch[:len(batch)-1]
+			if decimalsElem.Error != nil {
+				level.Warn(logger).Log("msg", "decimals call failed, assuming 18, "+decimalsElem.Error.Error(), "token", tokenAddress)
+			} else if d, err := unpackABIResult(abiObj, "decimals", *decimalsElem.Result.(*string)); err != nil {
+				level.Warn(logger).Log("msg", "unexpected decimals result, assuming 18, "+err.Error(), "token", tokenAddress)
+			} else {
+				decimals = d[0].(uint8)
+				tokenDecimals.observe(decimalsKey, strconv.Itoa(int(decimals)), time.Now())
+			}
+		}
+		erc20DecimalsGaugeVec.WithLabelValues(target, chainId, addressLabel(tokenAddress), tokenSymbol).Set(float64(decimals))
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "balanceOf call failed, "+e.Error.Error(), "account", validAccounts[i].AccountName)
+				continue
+			}
+			r := *e.Result.(*string)
+			level.Debug(logger).Log("msg", "result "+r)
+			r = strings.ReplaceAll(r, "0x", "")
+			var value float64
+			n := new(big.Int)
+			n.SetString(r, 16)
+			value, _ = new(big.Float).Quo(new(big.Float).SetInt(n), new(big.Float).SetInt(pow10(int(decimals)))).Float64()
+			erc20balanceGaugeVec.WithLabelValues(
+				target,
+				chainId,
+				addressLabel(validAccounts[i].AccountAddress),
+				validAccounts[i].AccountName,
+				tokenSymbol,
+				addressLabel(tokenAddress),
+			).Set(value)
+		}
+	case "transfer_tax":
+		var (
+			transferTaxGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_transfer_tax_bps",
+				Help: "Share of a simulated token transfer not received by the recipient, in basis points",
+			}, []string{"rpc", "chainId", "tokenAddress", "tokenSymbol"})
+		)
+		registry.MustRegister(transferTaxGaugeVec)
+		tokenAddress := params.Get("token")
+		tokenSymbol := params.Get("symbol")
+		if !common.IsHexAddress(tokenAddress) {
+			level.Error(logger).Log("msg", "token '"+tokenAddress+"' is not a valid address")
+			return false
+		}
+		// slot is the storage slot of the token's balances mapping, through
+		// which the sender is funded. Solidity lays out mapping entries at
+		// keccak256(key . slot).
+		slot, ok := new(big.Int).SetString(params.Get("slot"), 10)
+		if !ok || slot.Sign() < 0 {
+			level.Error(logger).Log("msg", "slot '"+params.Get("slot")+"' is not valid, must be the storage slot of the balances mapping")
+			return false
+		}
+		amount := pow10(18)
+		if a := params.Get("amount"); a != "" {
+			amount, ok = new(big.Int).SetString(a, 10)
+			if !ok || amount.Sign() <= 0 {
+				level.Error(logger).Log("msg", "amount '"+a+"' is not valid, must be a positive number of token units")
+				return false
+			}
+		}
+		recipient := transferTaxRecipient
+		if r := params.Get("recipient"); r != "" {
+			if !common.IsHexAddress(r) {
+				level.Error(logger).Log("msg", "recipient '"+r+"' is not a valid address")
+				return false
+			}
+			recipient = common.HexToAddress(r)
+		}
+		block, err := parseBlockTag(params.Get("block"))
+		if err != nil {
+			level.Error(logger).Log("msg", err.Error())
+			return false
+		}
+
+		token := common.HexToAddress(tokenAddress)
+		balanceKey := crypto.Keccak256Hash(common.LeftPadBytes(transferTaxProbeAddress.Bytes(), 32), common.LeftPadBytes(slot.Bytes(), 32))
+		overrides := map[common.Address]interface{}{
+			transferTaxProbeAddress: map[string]interface{}{
+				"code": transferTaxProbeCode,
+			},
+			token: map[string]interface{}{
+				"stateDiff": map[common.Hash]common.Hash{balanceKey: common.BigToHash(amount)},
+			},
+		}
+		var data []byte
+		data = append(data, common.LeftPadBytes(token.Bytes(), 32)...)
+		data = append(data, common.LeftPadBytes(recipient.Bytes(), 32)...)
+		data = append(data, common.LeftPadBytes(amount.Bytes(), 32)...)
+		msg := map[string]interface{}{
+			"to":   transferTaxProbeAddress,
+			"data": hexutil.Bytes(data),
+		}
+		var out hexutil.Bytes
+		err = withRetries(block, func() error {
+			return eth.Client().CallContext(ctx, &out, "eth_call", msg, block, overrides)
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "transfer simulation failed, "+err.Error(), "token", tokenAddress)
+			rpcCallFailed("eth_call", block, err)
+			return false
+		}
+		callSucceeded("eth_call", block)
+		if len(out) != 32 {
+			level.Error(logger).Log("msg", "unexpected transfer simulation result "+out.String(), "token", tokenAddress)
+			rpcFailed("eth_call", block, rpcErrorDecode)
+			return false
+		}
+		rawResult("eth_call", block, out.String())
+		// The received amount is a two's complement word, negative if the
+		// recipient's balance went down.
+		received := new(big.Int).SetBytes(out)
+		if out[0]&0x80 != 0 {
+			received.Sub(received, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		tax := new(big.Int).Sub(amount, received)
+		bps, _ := new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Mul(tax, big.NewInt(10000))), new(big.Float).SetInt(amount)).Float64()
+		if bps != 0 {
+			level.Warn(logger).Log("msg", "token takes a fee on transfer", "token", tokenAddress, "bps", bps)
+		}
+		transferTaxGaugeVec.WithLabelValues(target, chainId, addressLabel(tokenAddress), tokenSymbol).Set(bps)
+	case "erc721balance":
+		var (
+			erc721balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_erc721balance",
+				Help: "Number of tokens of the ERC-721 contract held by the account",
+			}, []string{"rpc", "chainId", "accountAddress", "accountName", "tokenSymbol", "tokenAddress"})
+			erc721OwnedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_erc721_owned",
+				Help: "1 if ownerOf(tokenId) is the account, 0 otherwise",
+			}, []string{"rpc", "chainId", "accountAddress", "accountName", "tokenAddress", "tokenId"})
+		)
+		registry.MustRegister(erc721balanceGaugeVec)
+		tokenAddress := params.Get("token")
+		tokenSymbol := params.Get("symbol")
+		if len(params["account"]) == 0 {
+			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+			return false
+		}
+		if !common.IsHexAddress(tokenAddress) {
+			level.Error(logger).Log("msg", "no valid token contract address given!")
+			return false
+		}
+		const erc721AbiDef = `[
+			{"name":"balanceOf","type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+			{"name":"ownerOf","type":"function","inputs":[{"name":"","type":"uint256"}],"outputs":[{"name":"","type":"address"}]}
+		]`
+		abiObj, err := abi.JSON(strings.NewReader(erc721AbiDef))
+		if err != nil {
+			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
+			return false
+		}
+
+		var batch []rpc.BatchElem
+		validAccounts := parseNamedAddresses(params["account"], "account", logger)
+		for _, a := range validAccounts {
+			callData, err := abiObj.Pack("balanceOf", common.HexToAddress(a.AccountAddress))
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+			batch = append(batch, newEthCallElem(tokenAddress, callData, "latest"))
+		}
+		// With a tokenId param, its owner is read along with the balances
+		// and compared to each account.
+		tokenId := params.Get("tokenId")
+		if tokenId != "" {
+			id, ok := new(big.Int).SetString(tokenId, 0)
+			if !ok || id.Sign() < 0 {
+				level.Error(logger).Log("msg", "tokenId '"+tokenId+"' is not valid")
+				return false
+			}
+			callData, err := abiObj.Pack("ownerOf", id)
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+			registry.MustRegister(erc721OwnedGaugeVec)
+			batch = append(batch, newEthCallElem(tokenAddress, callData, "latest"))
+		}
+
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, a := range validAccounts {
+			balance, err := unpackABIResult(abiObj, "balanceOf", *batch[i].Result.(*string))
+			if batch[i].Error != nil {
+				err = batch[i].Error
+			}
+			if err != nil {
+				level.Error(logger).Log("msg", "balanceOf call failed, "+err.Error(), "account", a.AccountName)
+				failed = true
+				continue
+			}
+			value, _ := new(big.Float).SetInt(balance[0].(*big.Int)).Float64()
+			erc721balanceGaugeVec.WithLabelValues(target, chainId, addressLabel(a.AccountAddress), a.AccountName, tokenSymbol, addressLabel(tokenAddress)).Set(value)
+		}
+		if tokenId != "" {
+			e := batch[len(batch)-1]
+			owner, err := unpackABIResult(abiObj, "ownerOf", *e.Result.(*string))
+			if e.Error != nil {
+				err = e.Error
+			}
+			if err != nil {
+				// ownerOf reverts for tokens that do not exist.
+				level.Error(logger).Log("msg", "ownerOf call failed, "+err.Error(), "tokenId", tokenId)
+				return false
+			}
+			for _, a := range validAccounts {
+				owned := 0.0
+				if owner[0].(common.Address) == common.HexToAddress(a.AccountAddress) {
+					owned = 1
+				}
+				erc721OwnedGaugeVec.WithLabelValues(target, chainId, addressLabel(a.AccountAddress), a.AccountName, addressLabel(tokenAddress), tokenId).Set(owned)
+			}
+		}
+		if failed {
+			return false
+		}
+	case "storage":
+		var (
+			storageGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_storage",
+				Help: "Value of the storage slot as an integer, implementation is set for the EIP-1967 implementation slot",
+			}, []string{"rpc", "chainId", "contractAddress", "name", "slot", "implementation"})
+		)
+		registry.MustRegister(storageGaugeVec)
+		slots := params["slot"]
+		if len(slots) == 0 {
+			level.Error(logger).Log("msg", "no slots specified! format: name:contractAddress:slot")
+			return false
+		}
+		type storageSlot struct {
+			name, address string
+			slot          common.Hash
+		}
+		var validSlots []storageSlot
+		var batch []rpc.BatchElem
+		for _, sp := range slots {
+			ss := strings.Split(sp, ":")
+			if len(ss) != 3 || ss[0] == "" {
+				level.Error(logger).Log("msg", "slot params format is invalid, SKIP! valid format: name:contractAddress:slot")
+				continue
+			}
+			if !common.IsHexAddress(ss[1]) {
+				level.Error(logger).Log("msg", "contract address "+ss[1]+" is invalid, SKIP this slot!")
+				continue
+			}
+			n, ok := new(big.Int).SetString(ss[2], 0)
+			if !ok || n.Sign() < 0 || n.BitLen() > 256 {
+				level.Error(logger).Log("msg", "slot "+ss[2]+" is invalid, SKIP this slot!")
+				continue
+			}
+			slot := common.BigToHash(n)
+			var result string
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getStorageAt",
+				Args:   []interface{}{ss[1], slot, "latest"},
+				Result: &result,
+			})
+			validSlots = append(validSlots, storageSlot{name: ss[0], address: ss[1], slot: slot})
+		}
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			sl := validSlots[i]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get storage failed, "+e.Error.Error(), "slot", sl.name)
+				failed = true
+				continue
+			}
+			word := common.HexToHash(*e.Result.(*string))
+			implementation := ""
+			if sl.slot == eip1967ImplementationSlot {
+				implementation = addressLabel(common.BytesToAddress(word.Bytes()).Hex())
+			}
+			value, _ := new(big.Float).SetInt(word.Big()).Float64()
+			trace.add("eth_getStorageAt", *e.Result.(*string), word.Big().String(),
+				traceMetric("probe_ethrpc_storage", "name", sl.name)+" "+strconv.FormatFloat(value, 'g', -1, 64))
+			storageGaugeVec.WithLabelValues(target, chainId, addressLabel(sl.address), sl.name, sl.slot.Hex(), implementation).Set(value)
+		}
+		if failed {
+			return false
+		}
+	case "proxy_impl":
+		var (
+			proxyImplHashGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_proxy_impl_hash",
+				Help: "Numeric hash of the EIP-1967 implementation address of the proxy, changes when the proxy is upgraded",
+			}, []string{"rpc", "chainId", "proxyAddress", "proxyName"})
+			proxyImplGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_proxy_impl",
+				Help: "EIP-1967 implementation address of the proxy, set to 1",
+			}, []string{"rpc", "chainId", "proxyAddress", "proxyName", "implementation"})
+		)
+		registry.MustRegister(proxyImplHashGaugeVec)
+		registry.MustRegister(proxyImplGaugeVec)
+		proxies := params["proxy"]
+		if len(proxies) == 0 {
+			level.Error(logger).Log("msg", "no proxies specified! format: proxyName:proxyAddress")
+			return false
+		}
+		validProxies := parseNamedAddresses(proxies, "proxy", logger)
+		var batch []rpc.BatchElem
+		for _, p := range validProxies {
+			var result string
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getStorageAt",
+				Args:   []interface{}{p.AccountAddress, eip1967ImplementationSlot, "latest"},
+				Result: &result,
+			})
+		}
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			p := validProxies[i]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "get implementation slot failed, "+e.Error.Error(), "proxy", p.AccountName)
+				failed = true
+				continue
+			}
+			implementation := common.BytesToAddress(common.HexToHash(*e.Result.(*string)).Bytes())
+			if implementation == (common.Address{}) {
+				level.Error(logger).Log("msg", "implementation slot is empty, not an EIP-1967 proxy", "proxy", p.AccountName)
+				failed = true
+				continue
+			}
+			// The first 6 bytes of the address' keccak256 are exactly
+			// representable as a float64.
+			hash := new(big.Int).SetBytes(crypto.Keccak256(implementation.Bytes())[:6])
+			proxyImplHashGaugeVec.WithLabelValues(target, chainId, addressLabel(p.AccountAddress), p.AccountName).Set(float64(hash.Uint64()))
+			proxyImplGaugeVec.WithLabelValues(target, chainId, addressLabel(p.AccountAddress), p.AccountName, addressLabel(implementation.Hex())).Set(1)
+		}
+		if failed {
+			return false
+		}
+	case "ownership":
+		var (
+			ownerGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_owner",
+				Help: "Current owner() of the contract, set to 1",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName", "owner"})
+			ownershipRenouncedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_ownership_renounced",
+				Help: "Whether owner() of the contract is the zero address",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+		)
+		registry.MustRegister(ownerGaugeVec)
+		registry.MustRegister(ownershipRenouncedGaugeVec)
+		contracts := params["contract"]
+		if len(contracts) == 0 {
+			level.Error(logger).Log("msg", "no contracts specified! format: contractName:contractAddress")
+			return false
+		}
+
+		callData, err := packGetter("owner", "address")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+
+		validContracts := parseNamedAddresses(contracts, "contract", logger)
+		var batch []rpc.BatchElem
+		for _, c := range validContracts {
+			batch = append(batch, newEthCallElem(c.AccountAddress, callData, "latest"))
+		}
+
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "owner call failed, "+e.Error.Error(), "contract", validContracts[i].AccountName)
+				failed = true
+				continue
+			}
+			r := *e.Result.(*string)
+			level.Debug(logger).Log("msg", "result "+r)
+			// An account without code answers eth_call with "0x", which must
+			// not be mistaken for a renounced (zero) owner.
+			if len(strings.TrimPrefix(r, "0x")) != 64 {
+				level.Error(logger).Log("msg", "unexpected owner() result "+r, "contract", validContracts[i].AccountName)
+				failed = true
+				continue
+			}
+			owner := common.HexToAddress(r)
+			renounced := 0.0
+			if owner == (common.Address{}) {
+				renounced = 1
+			}
+			ownerGaugeVec.WithLabelValues(
+				target,
+				chainId,
+				addressLabel(validContracts[i].AccountAddress),
+				validContracts[i].AccountName,
+				addressLabel(owner.Hex()),
+			).Set(1)
+			ownershipRenouncedGaugeVec.WithLabelValues(
+				target,
+				chainId,
+				addressLabel(validContracts[i].AccountAddress),
+				validContracts[i].AccountName,
+			).Set(renounced)
+		}
+		if failed {
+			return false
+		}
+	case "timelock":
+		var (
+			minDelayGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_timelock_min_delay_seconds",
+				Help: "Minimum delay enforced by the timelock in seconds",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+			guardianGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_guardian",
+				Help: "Current guardian of the contract, set to 1",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName", "guardian"})
+			guardianChangedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_guardian_changed",
+				Help: "1 if the guardian of the contract differs from the one seen by the previous probe",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+		)
+		registry.MustRegister(minDelayGaugeVec)
+		registry.MustRegister(guardianGaugeVec)
+		contracts := params["contract"]
+		if len(contracts) == 0 {
+			level.Error(logger).Log("msg", "no contracts specified! format: contractName:contractAddress")
+			return false
+		}
+
+		// OpenZeppelin's TimelockController exposes getMinDelay(), Compound's
+		// Timelock delay(); the guardian is only read when a getter is given.
+		delayGetter := params.Get("delayGetter")
+		if delayGetter == "" {
+			delayGetter = "getMinDelay"
+		}
+		delayCallData, err := packGetter(delayGetter, "uint256")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+		guardianGetter := params.Get("guardianGetter")
+		var guardianCallData []byte
+		if guardianGetter != "" {
+			guardianCallData, err = packGetter(guardianGetter, "address")
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+			registry.MustRegister(guardianChangedGaugeVec)
+		}
+
+		validContracts := parseNamedAddresses(contracts, "contract", logger)
+		var batch []rpc.BatchElem
+		for _, c := range validContracts {
+			batch = append(batch, newEthCallElem(c.AccountAddress, delayCallData, "latest"))
+			if guardianCallData != nil {
+				batch = append(batch, newEthCallElem(c.AccountAddress, guardianCallData, "latest"))
+			}
+		}
+
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		perContract := 1
+		if guardianCallData != nil {
+			perContract = 2
+		}
+		failed := false
+		for i, c := range validContracts {
+			e := batch[i*perContract]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", delayGetter+" call failed, "+e.Error.Error(), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			delay, ok := parseHexBig(*e.Result.(*string))
+			if !ok || !delay.IsUint64() {
+				level.Error(logger).Log("msg", "unexpected "+delayGetter+" result "+*e.Result.(*string), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			minDelayGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName).Set(float64(delay.Uint64()))
+
+			if guardianCallData == nil {
+				continue
+			}
+			e = batch[i*perContract+1]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", guardianGetter+" call failed, "+e.Error.Error(), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			r := *e.Result.(*string)
+			// As for owner(), an account without code answers "0x".
+			if len(strings.TrimPrefix(r, "0x")) != 64 {
+				level.Error(logger).Log("msg", "unexpected "+guardianGetter+" result "+r, "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			guardian := common.HexToAddress(r)
+			guardianGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName, addressLabel(guardian.Hex())).Set(1)
+			key := target + "|" + strings.ToLower(c.AccountAddress)
+			prev, seen := guardianHistory.swap(key, guardian.Hex(), time.Now())
+			changed := 0.0
+			if seen && prev != guardian.Hex() {
+				level.Warn(logger).Log("msg", "guardian changed", "contract", c.AccountName, "from", prev, "to", guardian.Hex())
+				changed = 1
+			}
+			guardianChangedGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName).Set(changed)
+		}
+		if failed {
+			return false
+		}
+	case "withdrawal_queue":
+		var (
+			pendingWithdrawalsGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_pending_withdrawals",
+				Help: "Length of the contract's pending withdrawal queue",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+			pendingWithdrawalsAmountGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_pending_withdrawals_amount",
+				Help: "Total amount pending withdrawal, in ether units",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+		)
+		registry.MustRegister(pendingWithdrawalsGaugeVec)
+		registry.MustRegister(pendingWithdrawalsAmountGaugeVec)
+		contracts := params["contract"]
+		if len(contracts) == 0 {
+			level.Error(logger).Log("msg", "no contracts specified! format: contractName:contractAddress")
+			return false
+		}
+
+		// Queue contracts do not share an interface, so the getters are
+		// always given explicitly; the amount is only read when asked for.
+		getter := params.Get("getter")
+		if getter == "" {
+			level.Error(logger).Log("msg", "no queue length getter specified!")
+			return false
+		}
+		lengthCallData, err := packGetter(getter, "uint256")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+		amountGetter := params.Get("amountGetter")
+		var amountCallData []byte
+		if amountGetter != "" {
+			amountCallData, err = packGetter(amountGetter, "uint256")
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+		}
+
+		validContracts := parseNamedAddresses(contracts, "contract", logger)
+		var batch []rpc.BatchElem
+		for _, c := range validContracts {
+			batch = append(batch, newEthCallElem(c.AccountAddress, lengthCallData, "latest"))
+			if amountCallData != nil {
+				batch = append(batch, newEthCallElem(c.AccountAddress, amountCallData, "latest"))
+			}
+		}
+
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		perContract := 1
+		if amountCallData != nil {
+			perContract = 2
+		}
+		failed := false
+		for i, c := range validContracts {
+			e := batch[i*perContract]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", getter+" call failed, "+e.Error.Error(), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			length, ok := parseHexBig(*e.Result.(*string))
+			if !ok {
+				level.Error(logger).Log("msg", "unexpected "+getter+" result "+*e.Result.(*string), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			value, _ := new(big.Float).SetInt(length).Float64()
+			pendingWithdrawalsGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName).Set(value)
+
+			if amountCallData == nil {
+				continue
+			}
+			e = batch[i*perContract+1]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", amountGetter+" call failed, "+e.Error.Error(), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			amount, ok := parseHexBig(*e.Result.(*string))
+			if !ok {
+				level.Error(logger).Log("msg", "unexpected "+amountGetter+" result "+*e.Result.(*string), "contract", c.AccountName)
+				failed = true
+				continue
+			}
+			// Decoded like a uint256 contract_call result.
+			value, _ = weiToEther(amount).Float64()
+			pendingWithdrawalsAmountGaugeVec.WithLabelValues(target, chainId, addressLabel(c.AccountAddress), c.AccountName).Set(value)
+		}
+		if failed {
+			return false
+		}
+	case "univ3_slot0":
+		var (
+			univ3PriceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_univ3_price",
+				Help: "Price of token0 in token1 derived from the pool's sqrtPriceX96, adjusted by token decimals",
+			}, []string{"rpc", "chainId", "poolAddress", "poolName"})
+			univ3TickGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_univ3_tick",
+				Help: "Current tick of the pool",
+			}, []string{"rpc", "chainId", "poolAddress", "poolName"})
+		)
+		registry.MustRegister(univ3PriceGaugeVec)
+		registry.MustRegister(univ3TickGaugeVec)
+		pools := params["pool"]
+		if len(pools) == 0 {
+			level.Error(logger).Log("msg", "no pools specified! format: poolName:poolAddress")
+			return false
+		}
+		var decimals [2]int
+		for i, name := range []string{"decimals0", "decimals1"} {
+			decimals[i] = 18
+			if d := params.Get(name); d != "" {
+				decimals[i], err = strconv.Atoi(d)
+				if err != nil || decimals[i] < 0 {
+					level.Error(logger).Log("msg", name+" '"+d+"' is not valid")
+					return false
+				}
+			}
+		}
+
+		callData, err := packGetter("slot0", "uint160")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+		validPools := parseNamedAddresses(pools, "pool", logger)
+		var batch []rpc.BatchElem
+		for _, p := range validPools {
+			batch = append(batch, newEthCallElem(p.AccountAddress, callData, "latest"))
+		}
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "slot0 call failed, "+e.Error.Error(), "pool", validPools[i].AccountName)
+				failed = true
+				continue
+			}
+			r := *e.Result.(*string)
+			level.Debug(logger).Log("msg", "result "+r)
+			sqrtPriceX96, tick, ok := decodeSlot0(r)
+			if !ok {
+				level.Error(logger).Log("msg", "unexpected slot0() result "+r, "pool", validPools[i].AccountName)
+				failed = true
+				continue
+			}
+			price, _ := univ3Price(sqrtPriceX96, decimals[0], decimals[1]).Float64()
+			univ3PriceGaugeVec.WithLabelValues(target, chainId, addressLabel(validPools[i].AccountAddress), validPools[i].AccountName).Set(price)
+			univ3TickGaugeVec.WithLabelValues(target, chainId, addressLabel(validPools[i].AccountAddress), validPools[i].AccountName).Set(float64(tick))
+		}
+		if failed {
+			return false
+		}
+	case "chainlink":
+		var (
+			chainlinkPriceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_chainlink_price",
+				Help: "Latest answer of the Chainlink feed, scaled by the feed's decimals",
+			}, []string{"rpc", "chainId", "feedAddress", "feedName"})
+			chainlinkUpdatedAtGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_chainlink_updated_at",
+				Help: "Unix timestamp of the latest round of the Chainlink feed",
+			}, []string{"rpc", "chainId", "feedAddress", "feedName"})
+			chainlinkStalenessGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_chainlink_staleness_seconds",
+				Help: "Seconds since the latest round of the Chainlink feed was updated",
+			}, []string{"rpc", "chainId", "feedAddress", "feedName"})
+		)
+		registry.MustRegister(chainlinkPriceGaugeVec)
+		registry.MustRegister(chainlinkUpdatedAtGaugeVec)
+		registry.MustRegister(chainlinkStalenessGaugeVec)
+		feedDeviationGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_ethrpc_feed_deviation_bps",
+			Help: "Absolute difference between the prices of the feed and the reference feed, in basis points of the reference",
+		}, []string{"rpc", "chainId", "feedName", "referenceName"})
+		feeds := params["feed"]
+		if len(feeds) == 0 {
+			level.Error(logger).Log("msg", "no feeds specified! format: feedName:feedAddress")
+			return false
+		}
+		abiObj, err := abi.JSON(strings.NewReader(chainlinkAggregatorABI))
+		if err != nil {
+			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
+			return false
+		}
+		decimalsCallData, err := abiObj.Pack("decimals")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+		roundCallData, err := abiObj.Pack("latestRoundData")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+
+		// Each feed takes two consecutive elements, decimals() then
+		// latestRoundData(). The feeds are read through Multicall3 so that
+		// they are compared at the same block, unless multicall=false.
+		validFeeds := parseNamedAddresses(feeds, "feed", logger)
+		// With mode=deviation, the price of the first feed is compared to
+		// the second one, the reference.
+		mode := params.Get("mode")
+		switch mode {
+		case "":
+		case "deviation":
+			if len(validFeeds) != 2 {
+				level.Error(logger).Log("msg", "mode deviation needs exactly two valid feeds, the second being the reference")
+				return false
+			}
+			registry.MustRegister(feedDeviationGaugeVec)
+		default:
+			level.Error(logger).Log("msg", "mode '"+mode+"' is not valid, must be deviation or empty")
+			return false
+		}
+		var batch []rpc.BatchElem
+		var multicalls []multicallCall
+		for _, f := range validFeeds {
+			batch = append(batch,
+				newEthCallElem(f.AccountAddress, decimalsCallData, "latest"),
+				newEthCallElem(f.AccountAddress, roundCallData, "latest"))
+			multicalls = append(multicalls,
+				multicallCall{Target: common.HexToAddress(f.AccountAddress), AllowFailure: true, CallData: decimalsCallData},
+				multicallCall{Target: common.HexToAddress(f.AccountAddress), AllowFailure: true, CallData: roundCallData})
+		}
+		if params.Get("multicall") != "false" {
+			err = reportBatch("latest", batch, withRetries("latest", func() error {
+				return callViaMulticall(ctx, eth.Client(), params.Get("multicallAddress"), batch, multicalls, "latest", logger)
+			}))
+		} else {
+			err = batchCall("latest", batch)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		now := time.Now()
+		prices := make([]float64, len(validFeeds))
+		for i, f := range validFeeds {
+			var decoded [2][]interface{}
+			for j, method := range []string{"decimals", "latestRoundData"} {
+				e := batch[2*i+j]
+				if e.Error != nil {
+					level.Error(logger).Log("msg", method+" call failed, "+e.Error.Error(), "feed", f.AccountName)
+					break
+				}
+				decoded[j], err = unpackABIResult(abiObj, method, *e.Result.(*string))
+				if err != nil {
+					level.Error(logger).Log("msg", "unexpected "+method+" result, "+err.Error(), "feed", f.AccountName)
+					break
+				}
+			}
+			if decoded[0] == nil || decoded[1] == nil {
+				failed = true
+				continue
+			}
+			decimals := decoded[0][0].(uint8)
+			answer := decoded[1][1].(*big.Int)
+			updatedAt := decoded[1][3].(*big.Int)
+			price, _ := new(big.Float).Quo(new(big.Float).SetInt(answer), new(big.Float).SetInt(pow10(int(decimals)))).Float64()
+			trace.add("eth_call "+f.AccountName+".latestRoundData", *batch[2*i+1].Result.(*string), answer.String(),
+				traceMetric("probe_ethrpc_chainlink_price", "feedName", f.AccountName)+" "+strconv.FormatFloat(price, 'g', -1, 64))
+			chainlinkPriceGaugeVec.WithLabelValues(target, chainId, addressLabel(f.AccountAddress), f.AccountName).Set(price)
+			chainlinkUpdatedAtGaugeVec.WithLabelValues(target, chainId, addressLabel(f.AccountAddress), f.AccountName).Set(float64(updatedAt.Int64()))
+			chainlinkStalenessGaugeVec.WithLabelValues(target, chainId, addressLabel(f.AccountAddress), f.AccountName).Set(now.Sub(time.Unix(updatedAt.Int64(), 0)).Seconds())
+			prices[i] = price
+		}
+		if failed {
+			return false
+		}
+		if mode == "deviation" {
+			if prices[1] == 0 {
+				level.Error(logger).Log("msg", "reference feed price is 0, cannot compute deviation", "feed", validFeeds[1].AccountName)
+				return false
+			}
+			deviation := math.Abs(prices[0]-prices[1]) / math.Abs(prices[1]) * 10000
+			feedDeviationGaugeVec.WithLabelValues(target, chainId, validFeeds[0].AccountName, validFeeds[1].AccountName).Set(deviation)
+		}
+	case "pool_tvl":
+		var (
+			poolTVLGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_pool_tvl_usd",
+				Help: "Sum of the pool's reserves valued at the USD price of their token's Chainlink feed",
+			}, []string{"rpc", "chainId", "poolAddress", "poolName"})
+		)
+		registry.MustRegister(poolTVLGaugeVec)
+		pools := params["pool"]
+		if len(pools) == 0 {
+			level.Error(logger).Log("msg", "no pools specified! format: poolName:poolAddress")
+			return false
+		}
+		feeds := params["feed"]
+		if len(feeds) == 0 {
+			level.Error(logger).Log("msg", "no feeds specified! format: tokenAddress:feedAddress")
+			return false
+		}
+		pairABI, err := abi.JSON(strings.NewReader(uniswapV2PairABI))
+		if err != nil {
+			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
+			return false
+		}
+		feedABI, err := abi.JSON(strings.NewReader(chainlinkAggregatorABI))
+		if err != nil {
+			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
+			return false
+		}
+		callData := make(map[string][]byte)
+		for _, method := range []string{"getReserves", "token0", "token1", "decimals"} {
+			callData[method], err = pairABI.Pack(method)
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+		}
+		callData["latestRoundData"], err = feedABI.Pack("latestRoundData")
+		if err != nil {
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+			return false
+		}
+
+		// Every token of the pools needs a USD feed, given as
+		// tokenAddress:feedAddress.
+		validPools := parseNamedAddresses(pools, "pool", logger)
+		var tokens []ValidAccount
+		for _, f := range parseNamedAddresses(feeds, "feed", logger) {
+			if !common.IsHexAddress(f.AccountName) {
+				level.Error(logger).Log("msg", "feed token address "+f.AccountName+" is invalid, SKIP this feed!")
+				continue
+			}
+			tokens = append(tokens, f)
+		}
+
+		// Pools take three consecutive elements, getReserves(), token0() and
+		// token1(), followed by three per token: the token's decimals(), then
+		// the feed's decimals() and latestRoundData(). They are all read in
+		// a single aggregate3 call so that reserves and prices are from the
+		// same block.
+		var batch []rpc.BatchElem
+		var multicalls []multicallCall
+		addCall := func(to, method string) {
+			batch = append(batch, newEthCallElem(to, callData[method], "latest"))
+			multicalls = append(multicalls, multicallCall{Target: common.HexToAddress(to), AllowFailure: true, CallData: callData[method]})
+		}
+		for _, p := range validPools {
+			addCall(p.AccountAddress, "getReserves")
+			addCall(p.AccountAddress, "token0")
+			addCall(p.AccountAddress, "token1")
+		}
+		for _, tf := range tokens {
+			addCall(tf.AccountName, "decimals")
+			addCall(tf.AccountAddress, "decimals")
+			addCall(tf.AccountAddress, "latestRoundData")
+		}
+		multicallAddress := params.Get("multicallAddress")
+		if multicallAddress == "" {
+			multicallAddress = defaultMulticall3Address
+		}
+		var results []multicallResult
+		err = withRetries("latest", func() (err error) {
+			results, err = multicall3(ctx, eth.Client(), multicallAddress, multicalls, "latest")
+			return err
+		})
+		if err != nil {
+			level.Error(logger).Log("msg", "multicall failed, "+err.Error(), "multicallAddress", multicallAddress)
+			rpcCallFailed("eth_call", "latest", err)
+			return false
+		}
+		fillBatchFromMulticall(batch, results)
+		decode := func(abiObj abi.ABI, method string, e rpc.BatchElem) ([]interface{}, error) {
+			if e.Error != nil {
+				return nil, e.Error
+			}
+			return unpackABIResult(abiObj, method, *e.Result.(*string))
+		}
+
+		// prices holds the USD value of one base unit of each token.
+		prices := make(map[common.Address]*big.Float)
+		for i, tf := range tokens {
+			elems := batch[3*len(validPools)+3*i:]
+			tokenDecimals, err := decode(pairABI, "decimals", elems[0])
+			if err != nil {
+				level.Error(logger).Log("msg", "token decimals call failed, "+err.Error(), "token", tf.AccountName)
+				continue
+			}
+			feedDecimals, err := decode(feedABI, "decimals", elems[1])
+			if err != nil {
+				level.Error(logger).Log("msg", "feed decimals call failed, "+err.Error(), "feed", tf.AccountAddress)
+				continue
+			}
+			round, err := decode(feedABI, "latestRoundData", elems[2])
+			if err != nil {
+				level.Error(logger).Log("msg", "latestRoundData call failed, "+err.Error(), "feed", tf.AccountAddress)
+				continue
+			}
+			// Base units are tiny fractions of the price, they are kept at a
+			// high precision so that the sum only rounds once to float64.
+			price := new(big.Float).SetPrec(256).SetInt(round[1].(*big.Int))
+			price.Quo(price, new(big.Float).SetInt(pow10(int(feedDecimals[0].(uint8))+int(tokenDecimals[0].(uint8)))))
+			prices[common.HexToAddress(tf.AccountName)] = price
+		}
+
+		failed := false
+		for i, p := range validPools {
+			reserves, err := decode(pairABI, "getReserves", batch[3*i])
+			if err != nil {
+				level.Error(logger).Log("msg", "getReserves call failed, "+err.Error(), "pool", p.AccountName)
+				failed = true
+				continue
+			}
+			tvl := new(big.Float).SetPrec(256)
+			for j, method := range []string{"token0", "token1"} {
+				token, err := decode(pairABI, method, batch[3*i+1+j])
+				if err != nil {
+					level.Error(logger).Log("msg", method+" call failed, "+err.Error(), "pool", p.AccountName)
+					tvl = nil
+					break
+				}
+				price, ok := prices[token[0].(common.Address)]
+				if !ok {
+					level.Error(logger).Log("msg", "no usable feed for "+method+" "+token[0].(common.Address).Hex(), "pool", p.AccountName)
+					tvl = nil
+					break
+				}
+				tvl.Add(tvl, new(big.Float).SetPrec(256).Mul(new(big.Float).SetInt(reserves[j].(*big.Int)), price))
+			}
+			if tvl == nil {
+				failed = true
+				continue
+			}
+			value, _ := tvl.Float64()
+			trace.add("eth_call "+p.AccountName+".getReserves", *batch[3*i].Result.(*string), reserves[0].(*big.Int).String()+","+reserves[1].(*big.Int).String(),
+				traceMetric("probe_ethrpc_pool_tvl_usd", "poolName", p.AccountName)+" "+strconv.FormatFloat(value, 'g', -1, 64))
+			poolTVLGaugeVec.WithLabelValues(target, chainId, addressLabel(p.AccountAddress), p.AccountName).Set(value)
 		}
-	case "erc20balance":
+		if failed {
+			return false
+		}
+	case "l2_output":
 		var (
-			erc20balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-				Name: "probe_ethrpc_erc20balance",
-				Help: "",
-			}, []string{"rpc", "chainId", "accountAddress", "accountName", "tokenSymbol", "tokenAddress"})
+			l2OutputAgeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_l2_output_age_seconds",
+				Help: "Seconds since the L2 timestamp of the latest output root posted to the L2OutputOracle",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+			l2OutputIndexGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_l2_output_index",
+				Help: "Index of the latest output root posted to the L2OutputOracle",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
+			l2OutputBlockGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_l2_output_block_number",
+				Help: "L2 block number of the latest output root posted to the L2OutputOracle",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName"})
 		)
-		registry.MustRegister(erc20balanceGaugeVec)
-		accounts := params["account"]
-		tokenAddress := params.Get("token")
-		tokenSymbol := params.Get("symbol")
-		if len(accounts) == 0 {
-			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+		registry.MustRegister(l2OutputAgeGaugeVec)
+		registry.MustRegister(l2OutputIndexGaugeVec)
+		registry.MustRegister(l2OutputBlockGaugeVec)
+		oracles := params["oracle"]
+		if len(oracles) == 0 {
+			level.Error(logger).Log("msg", "no oracles specified! format: oracleName:oracleAddress")
 			return false
 		}
-		if tokenAddress == "" {
-			level.Error(logger).Log("msg", "no token contract address given!")
+		abiObj, err := abi.JSON(strings.NewReader(l2OutputOracleABI))
+		if err != nil {
+			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
 			return false
 		}
-
-		const erc20AbiDef = `[{"name":"balanceOf","type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]`
-		abiObj, err := abi.JSON(strings.NewReader(erc20AbiDef))
-
+		indexCallData, err := abiObj.Pack("latestOutputIndex")
 		if err != nil {
-			level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error())
+			level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
 			return false
 		}
 
+		validOracles := parseNamedAddresses(oracles, "oracle", logger)
 		var batch []rpc.BatchElem
-		var validAccounts []ValidAccount
-		for _, a := range accounts {
-			aa := strings.Split(a, ":")
-			if len(aa) != 2 {
-				level.Error(logger).Log("msg", "account params format is invalid, SKIP! valid format: accountName:accountAddress")
-				continue
-			}
-			if !common.IsHexAddress(aa[1]) {
-				level.Error(logger).Log("msg", "account address "+aa[1]+" is invalid, SKIP this account!")
+		for _, o := range validOracles {
+			batch = append(batch, newEthCallElem(o.AccountAddress, indexCallData, "latest"))
+		}
+		err = batchCall("latest", batch)
+		if err != nil {
+			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			return false
+		}
+		failed := false
+		var outputBatch []rpc.BatchElem
+		var outputOracles []ValidAccount
+		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "latestOutputIndex call failed, "+e.Error.Error(), "oracle", validOracles[i].AccountName)
+				failed = true
 				continue
 			}
-			if len(aa[0]) == 0 {
-				level.Error(logger).Log("msg", "account name "+aa[1]+" is invalid, SKIP this account!")
+			index, ok := parseHexBig(*e.Result.(*string))
+			if !ok {
+				level.Error(logger).Log("msg", "unexpected latestOutputIndex result "+*e.Result.(*string), "oracle", validOracles[i].AccountName)
+				failed = true
 				continue
 			}
-
-			callData, err := abiObj.Pack("balanceOf", common.HexToAddress(aa[1]))
-
+			l2OutputIndexGaugeVec.WithLabelValues(target, chainId, addressLabel(validOracles[i].AccountAddress), validOracles[i].AccountName).Set(float64(index.Uint64()))
+			callData, err := abiObj.Pack("getL2Output", index)
 			if err != nil {
 				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+			outputBatch = append(outputBatch, newEthCallElem(validOracles[i].AccountAddress, callData, "latest"))
+			outputOracles = append(outputOracles, validOracles[i])
+		}
+		if len(outputBatch) > 0 {
+			err = batchCall("latest", outputBatch)
+			if err != nil {
+				level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+				return false
+			}
+		}
+		now := time.Now()
+		for i, e := range outputBatch {
+			o := outputOracles[i]
+			if e.Error != nil {
+				level.Error(logger).Log("msg", "getL2Output call failed, "+e.Error.Error(), "oracle", o.AccountName)
+				failed = true
 				continue
 			}
-
-			callMsg := struct {
-				To   string `json:"to"`
-				Data string `json:"data"`
-			}{
-				To:   tokenAddress,
-				Data: "0x" + hex.EncodeToString(callData),
+			// OutputProposal{bytes32 outputRoot, uint128 timestamp,
+			// uint128 l2BlockNumber} is static and encoded inline.
+			r := strings.TrimPrefix(*e.Result.(*string), "0x")
+			if len(r) != 3*64 {
+				level.Error(logger).Log("msg", "unexpected getL2Output result "+*e.Result.(*string), "oracle", o.AccountName)
+				failed = true
+				continue
+			}
+			timestamp, _ := new(big.Int).SetString(r[64:128], 16)
+			l2Block, _ := new(big.Int).SetString(r[128:], 16)
+			age := now.Sub(time.Unix(timestamp.Int64(), 0)).Seconds()
+			l2OutputAgeGaugeVec.WithLabelValues(target, chainId, addressLabel(o.AccountAddress), o.AccountName).Set(age)
+			l2OutputBlockGaugeVec.WithLabelValues(target, chainId, addressLabel(o.AccountAddress), o.AccountName).Set(float64(l2Block.Uint64()))
+		}
+		if failed {
+			return false
+		}
+	case "staking_rewards":
+		var (
+			pendingRewardsGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_pending_rewards",
+				Help: "Pending staking rewards of the account, scaled by decimals",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName", "accountAddress", "accountName"})
+			rewardsStalledGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_rewards_stalled",
+				Help: "Whether pending rewards have not increased since an earlier probe",
+			}, []string{"rpc", "chainId", "contractAddress", "contractName", "accountAddress", "accountName"})
+		)
+		registry.MustRegister(pendingRewardsGaugeVec)
+		registry.MustRegister(rewardsStalledGaugeVec)
+		contracts := parseNamedAddresses(params["contract"], "contract", logger)
+		if len(contracts) != 1 {
+			level.Error(logger).Log("msg", "exactly one staking contract must be specified! format: contractName:contractAddress")
+			return false
+		}
+		accounts := parseNamedAddresses(params["account"], "account", logger)
+		if len(accounts) == 0 {
+			level.Error(logger).Log("msg", "no accounts specified! format: accountName:accountAddress")
+			return false
+		}
+		getter := params.Get("getter")
+		if getter == "" {
+			getter = "earned"
+		}
+		decimals := 18
+		if d := params.Get("decimals"); d != "" {
+			decimals, err = strconv.Atoi(d)
+			if err != nil || decimals < 0 {
+				level.Error(logger).Log("msg", "decimals '"+d+"' is not valid")
+				return false
+			}
+		}
+		// window is how long rewards must stay unchanged before they are
+		// reported as stalled; by default a single probe without an
+		// increase is enough.
+		var window time.Duration
+		if w := params.Get("window"); w != "" {
+			window, err = time.ParseDuration(w)
+			if err != nil {
+				level.Error(logger).Log("msg", "window '"+w+"' is not a valid duration, "+err.Error())
+				return false
 			}
-
-			var result string
-			batch = append(batch, rpc.BatchElem{
-				Method: "eth_call",
-				Args:   []interface{}{callMsg, "latest"},
-				Result: &result,
-				Error:  nil,
-			})
-
-			validAccounts = append(validAccounts, ValidAccount{
-				AccountName:    aa[0],
-				AccountAddress: aa[1],
-			})
 		}
 
-		err = eth.Client().BatchCall(batch)
+		contract := contracts[0]
+		var batch []rpc.BatchElem
+		for _, a := range accounts {
+			callData, err := packAccountGetter(getter, common.HexToAddress(a.AccountAddress))
+			if err != nil {
+				level.Error(logger).Log("msg", "abi pack failed, "+err.Error())
+				return false
+			}
+			batch = append(batch, newEthCallElem(contract.AccountAddress, callData, "latest"))
+		}
+		err = batchCall("latest", batch)
 		if err != nil {
 			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
 			return false
 		}
+		now := time.Now()
+		scale := new(big.Float).SetInt(pow10(decimals))
+		failed := false
 		for i, e := range batch {
+			if e.Error != nil {
+				level.Error(logger).Log("msg", getter+" call failed, "+e.Error.Error(), "account", accounts[i].AccountName)
+				failed = true
+				continue
+			}
 			r := *e.Result.(*string)
 			level.Debug(logger).Log("msg", "result "+r)
-			r = strings.ReplaceAll(r, "0x", "")
-			var value float64
-			n := new(big.Int)
-			n.SetString(r, 16)
-			value, _ = weiToEther(n).Float64()
-			erc20balanceGaugeVec.WithLabelValues(
+			rewards, ok := parseHexBig(r)
+			if !ok {
+				level.Error(logger).Log("msg", "unexpected "+getter+"() result "+r, "account", accounts[i].AccountName)
+				failed = true
+				continue
+			}
+			labels := []string{
 				target,
 				chainId,
-				validAccounts[i].AccountAddress,
-				validAccounts[i].AccountName,
-				tokenSymbol,
-				tokenAddress,
-			).Set(value)
+				addressLabel(contract.AccountAddress),
+				contract.AccountName,
+				addressLabel(accounts[i].AccountAddress),
+				accounts[i].AccountName,
+			}
+			value, _ := new(big.Float).Quo(new(big.Float).SetInt(rewards), scale).Float64()
+			pendingRewardsGaugeVec.WithLabelValues(labels...).Set(value)
+
+			// Claiming resets pending rewards, only a value that stays the
+			// same counts as stalled.
+			key := strings.Join([]string{target, strings.ToLower(contract.AccountAddress), strings.ToLower(accounts[i].AccountAddress), getter}, "|")
+			unchangedFor, unchanged := rewardsHistory.observe(key, rewards.String(), now)
+			stalled := 0.0
+			if unchanged && unchangedFor >= window {
+				stalled = 1
+			}
+			rewardsStalledGaugeVec.WithLabelValues(labels...).Set(stalled)
+		}
+		if failed {
+			return false
 		}
 	case "contract_call":
 		var (
 			contractCallGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: "probe_ethrpc_contract_call",
 				Help: "",
-			}, []string{"rpc", "chainId", "contractAddress", "contractName", "methodName", "methodArgs"})
+			}, []string{"rpc", "chainId", "contractAddress", "contractName", "methodName", "methodArgs", "outputName"})
+			contractCallErrorGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_contract_call_error",
+				Help: "1 if the call spec is malformed, e.g. has invalid ABI JSON, 0 otherwise",
+			}, []string{"rpc", "chainId", "contractName"})
+			contractCallRevertedGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_ethrpc_contract_call_reverted",
+				Help: "1 if the call reverted, 0 otherwise",
+			}, []string{"rpc", "chainId", "contractName", "methodName"})
 		)
 		registry.MustRegister(contractCallGaugeVec)
+		registry.MustRegister(contractCallErrorGaugeVec)
+		registry.MustRegister(contractCallRevertedGaugeVec)
 		callParams := params["call"]
 		if len(callParams) <= 0 {
 			level.Error(logger).Log("msg", "no call args for module")
 			return false
 		}
 		var batch []rpc.BatchElem
+		var multicalls []multicallCall
 		var validCallParams []ValidCallParam
-		var methodName string
-		var outputType string
 
+		// Malformed call specs are reported and skipped, the others are
+		// still called but the probe fails.
+		malformed := false
+		invalidSpec := func(contractName, msg, callParam string) {
+			level.Error(logger).Log("msg", msg, "callParam", callParam)
+			contractCallErrorGaugeVec.WithLabelValues(target, chainId, contractName).Set(1)
+			malformed = true
+		}
 		for _, callParam := range callParams {
-			p := strings.Split(callParam, "|")
-			if len(p) < 3 {
-				level.Error(logger).Log("msg", "Need to config at least ContractName|ContractAddress|AbiJson", "callParam", callParam)
-				continue
-			}
-			contractName := p[0]
-			//contractAddress := common.HexToAddress(p[1])
-			contractAddress := p[1]
-			abiJson := p[2]
-			contractArgsString := ""
-			var contractArgs []interface{}
-
-			abiObj, err := abi.JSON(strings.NewReader(abiJson))
-
-			if err != nil {
-				level.Error(logger).Log("msg", "Abi json decode failed, "+err.Error(), "callParam", callParam)
-				continue
-			}
-
-			if len(abiObj.Methods) != 1 {
-				level.Error(logger).Log("msg", "Only support one method, ", "callParam", callParam)
-				break
-			}
-
-			for n, def := range abiObj.Methods {
-				methodName = n
-
-				if len(def.Outputs) != 1 {
-					level.Error(logger).Log("msg", "Only support one method output, ", "callParam", callParam)
-					break
-				}
-
-				outputType = def.Outputs[0].Type.String()
-
-				if len(p) < 4 {
-					break
-				}
-
-				contractArgsString = p[3]
-				contractArgsStringArr := strings.Split(p[3], ",")
-
-				for i, arg := range def.Inputs {
-					inputArg := contractArgsStringArr[i]
-					typeString := arg.Type.String()
-					if arg.Type.String() == "address" {
-						contractArgs = append(contractArgs, common.HexToAddress(inputArg))
-					} else if strings.Contains(typeString, "int") {
-						n, _ := strconv.ParseInt(inputArg, 10, 64)
-						switch typeString {
-						case "int8":
-							contractArgs = append(contractArgs, int8(n))
-						case "int16":
-							contractArgs = append(contractArgs, int16(n))
-						case "int32":
-							contractArgs = append(contractArgs, int32(n))
-						case "int64":
-							contractArgs = append(contractArgs, int64(n))
-						case "uint8":
-							contractArgs = append(contractArgs, uint8(n))
-						case "uint16":
-							contractArgs = append(contractArgs, uint16(n))
-						case "uint32":
-							contractArgs = append(contractArgs, uint32(n))
-						case "uint64":
-							contractArgs = append(contractArgs, uint64(n))
-						default:
-							contractArgs = append(contractArgs, big.NewInt(n))
-						}
-					} else if typeString == "bool" {
-						r, err := strconv.ParseBool(inputArg)
-						if err != nil {
-							level.Error(logger).Log("msg", "not a bool value"+err.Error(), "arg", inputArg)
-						}
-						contractArgs = append(contractArgs, r)
-					} else {
-						contractArgs = append(contractArgs, inputArg)
-					}
-				}
-				break
-			}
-
-			callData, err := abiObj.Pack(methodName, contractArgs...)
-
+			callSpec, callData, err := parseContractCall(callParam)
 			if err != nil {
-				level.Error(logger).Log("msg", "abi pack failed, "+err.Error(), "callParam", callParam)
+				invalidSpec(callSpec.ContractName, err.Error(), callParam)
 				continue
 			}
+			contractName, contractAddress := callSpec.ContractName, callSpec.ContractAddress
+			contractCallErrorGaugeVec.WithLabelValues(target, chainId, contractName).Set(0)
 
 			callMsg := struct {
 				To   string `json:"to"`
@@ -364,48 +2878,422 @@ func ProbeETHRPC(ctx context.Context, target string, params url.Values, module c
 				Result: &result,
 				Error:  nil,
 			})
-
-			validCallParams = append(validCallParams, ValidCallParam{
-				ContractName:    contractName,
-				ContractAddress: contractAddress,
-				MethodName:      methodName,
-				MethodArgs:      contractArgsString,
+			multicalls = append(multicalls, multicallCall{
+				Target:       common.HexToAddress(contractAddress),
+				AllowFailure: true,
+				CallData:     callData,
 			})
 
+			validCallParams = append(validCallParams, callSpec)
+
+		}
+
+		// The optional assertion refers to call results by contract name, or
+		// by contractName.methodName when a contract is called more than once.
+		var assertion ast.Expr
+		if assert := params.Get("assert"); assert != "" {
+			assertion, err = parseContractCallAssert(assert, validCallParams)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid assert expression, "+err.Error(), "assert", assert)
+				return false
+			}
+		}
+
+		// The optional scale formula replaces the default decoding and is
+		// applied to the raw integer result, available as value.
+		var scale ast.Expr
+		if s := params.Get("scale"); s != "" {
+			scale, err = parseExpr(s, scaleExprVars)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid scale expression, "+err.Error(), "scale", s)
+				return false
+			}
 		}
-		err = eth.Client().BatchCall(batch)
+
+		// With multicall=true all calls are aggregated into one eth_call to
+		// Multicall3, falling back to the batch where it is not deployed.
+		err = withRetries("latest", func() error {
+			if params.Get("multicall") == "true" {
+				return callViaMulticall(ctx, eth.Client(), params.Get("multicallAddress"), batch, multicalls, "latest", logger)
+			}
+			return eth.Client().BatchCallContext(ctx, batch)
+		})
 		if err != nil {
 			level.Error(logger).Log("msg", "batchcall failed, "+err.Error())
+			rpcCallFailed("eth_call", "latest", err)
 			return false
 		}
+		values := make(map[string]float64)
 		for i, e := range batch {
+			if e.Error != nil {
+				rpcCallFailed("eth_call", "latest", e.Error)
+				// The reason a call reverts, e.g. a require message or a
+				// panic code, tells why it started failing.
+				if reason, reverted := callReverted(e.Error); reverted {
+					level.Error(logger).Log("msg", "call reverted", "reason", reason, "contract", validCallParams[i].ContractName, "method", validCallParams[i].MethodName)
+					contractCallRevertedGaugeVec.WithLabelValues(target, chainId, validCallParams[i].ContractName, validCallParams[i].MethodName).Set(1)
+					continue
+				}
+				level.Error(logger).Log("msg", "call failed, "+e.Error.Error(), "contract", validCallParams[i].ContractName, "method", validCallParams[i].MethodName)
+				continue
+			}
+			contractCallRevertedGaugeVec.WithLabelValues(target, chainId, validCallParams[i].ContractName, validCallParams[i].MethodName).Set(0)
 			r := *e.Result.(*string)
 			level.Info(logger).Log("msg", "result "+r)
-			r = strings.ReplaceAll(r, "0x", "")
-			var value float64
-			if outputType == "uint256" || outputType == "int256" {
+			// Tuples and arrays yield one series per numeric component, named
+			// by its path in the outputs. A single scalar output keeps an
+			// empty outputName.
+			var components []abiComponent
+			if isScalarABIOutput(validCallParams[i].Outputs) {
 				n := new(big.Int)
-				n.SetString(r, 16)
-				value, _ = weiToEther(n).Float64()
+				n.SetString(strings.ReplaceAll(r, "0x", ""), 16)
+				components = []abiComponent{{Type: validCallParams[i].OutputType, Value: n}}
 			} else {
-				n := new(big.Int)
-				n.SetString(r, 16)
-				value, _ = new(big.Float).SetInt(n).Float64()
+				data, err := hexutil.Decode(r)
+				if err == nil {
+					components, err = flattenABIOutputs(validCallParams[i].Outputs, data)
+				}
+				if err != nil {
+					level.Error(logger).Log("msg", "abi decode failed, "+err.Error(), "contract", validCallParams[i].ContractName, "method", validCallParams[i].MethodName)
+					rpcFailed("eth_call", "latest", rpcErrorDecode)
+					continue
+				}
 			}
-			contractCallGaugeVec.WithLabelValues(
-				target,
-				chainId,
-				validCallParams[i].ContractAddress,
-				validCallParams[i].ContractName,
-				validCallParams[i].MethodName,
-				validCallParams[i].MethodArgs,
-			).Set(value)
+			for _, c := range components {
+				var value float64
+				if scale != nil {
+					raw, _ := new(big.Float).SetInt(c.Value).Float64()
+					value, err = evalFloatExpr(scale, map[string]float64{"value": raw})
+					if err != nil {
+						level.Error(logger).Log("msg", "scale evaluation failed, "+err.Error(), "scale", params.Get("scale"))
+						return false
+					}
+				} else if c.Type == "uint256" || c.Type == "int256" {
+					value, _ = weiToEther(c.Value).Float64()
+				} else {
+					value, _ = new(big.Float).SetInt(c.Value).Float64()
+				}
+				trace.add("eth_call "+validCallParams[i].ContractName+"."+validCallParams[i].MethodName, *e.Result.(*string), c.Value.String(),
+					traceMetric("probe_ethrpc_contract_call", "contractName", validCallParams[i].ContractName, "methodName", validCallParams[i].MethodName, "outputName", c.Name)+" "+strconv.FormatFloat(value, 'g', -1, 64))
+				contractCallGaugeVec.WithLabelValues(
+					target,
+					chainId,
+					addressLabel(validCallParams[i].ContractAddress),
+					validCallParams[i].ContractName,
+					validCallParams[i].MethodName,
+					validCallParams[i].MethodArgs,
+					c.Name,
+				).Set(value)
+				if c.Name == "" {
+					values[validCallParams[i].ContractName] = value
+					values[validCallParams[i].ContractName+"."+validCallParams[i].MethodName] = value
+				}
+			}
+		}
+		if assertion != nil {
+			ok, err := evalBoolExpr(assertion, values)
+			if err != nil {
+				level.Error(logger).Log("msg", "assert evaluation failed, "+err.Error(), "assert", params.Get("assert"))
+				return false
+			}
+			if !ok {
+				level.Error(logger).Log("msg", "assert expression is false", "assert", params.Get("assert"))
+				return false
+			}
+		}
+		if malformed {
+			return false
 		}
+	default:
+		level.Error(logger).Log("msg", "Unknown module '"+params.Get("module")+"'")
+		return false
 	}
 
 	return true
 }
 
+// sameLogs reports whether a and b contain the same logs, ignoring order.
+func sameLogs(a, b []rpcLog) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[rpcLog]int, len(a))
+	for _, l := range a {
+		seen[l]++
+	}
+	for _, l := range b {
+		if seen[l] == 0 {
+			return false
+		}
+		seen[l]--
+	}
+	return true
+}
+
+// formatAddressLabel returns address in EIP-55 checksummed form, or all
+// lowercase when format is "lowercase". Values that are not addresses are
+// returned unchanged.
+func formatAddressLabel(address, format string) string {
+	if !common.IsHexAddress(address) {
+		return address
+	}
+	checksummed := common.HexToAddress(address).Hex()
+	if format == "lowercase" {
+		return strings.ToLower(checksummed)
+	}
+	return checksummed
+}
+
+// batchCallChunked sends batch in chunks of at most size requests.
+func batchCallChunked(ctx context.Context, c *rpc.Client, batch []rpc.BatchElem, size int) error {
+	for len(batch) > 0 {
+		n := min(size, len(batch))
+		if err := c.BatchCallContext(ctx, batch[:n]); err != nil {
+			return err
+		}
+		batch = batch[n:]
+	}
+	return nil
+}
+
+// parseNamedAddresses parses name:address params, skipping (and logging)
+// malformed entries. kind is used in log messages, e.g. "account".
+func parseNamedAddresses(values []string, kind string, logger log.Logger) []ValidAccount {
+	var valid []ValidAccount
+	for _, v := range values {
+		vv := strings.Split(v, ":")
+		if len(vv) != 2 {
+			level.Error(logger).Log("msg", kind+" params format is invalid, SKIP! valid format: "+kind+"Name:"+kind+"Address")
+			continue
+		}
+		if !common.IsHexAddress(vv[1]) {
+			level.Error(logger).Log("msg", kind+" address "+vv[1]+" is invalid, SKIP this "+kind+"!")
+			continue
+		}
+		if len(vv[0]) == 0 {
+			level.Error(logger).Log("msg", kind+" name "+vv[1]+" is invalid, SKIP this "+kind+"!")
+			continue
+		}
+		valid = append(valid, ValidAccount{
+			AccountName:    vv[0],
+			AccountAddress: vv[1],
+		})
+	}
+	return valid
+}
+
+// newEthCallElem returns an eth_call batch element whose result is decoded
+// into a hex string.
+func newEthCallElem(to string, data []byte, block string) rpc.BatchElem {
+	callMsg := struct {
+		To   string `json:"to"`
+		Data string `json:"data"`
+	}{
+		To:   to,
+		Data: "0x" + hex.EncodeToString(data),
+	}
+	var result string
+	return rpc.BatchElem{
+		Method: "eth_call",
+		Args:   []interface{}{callMsg, block},
+		Result: &result,
+	}
+}
+
+// parseHexBig parses a 0x-prefixed hex quantity or ABI word. An empty result
+// ("0x"), as returned by eth_call against an account without code, is not a
+// valid number.
+func parseHexBig(r string) (*big.Int, bool) {
+	r = strings.TrimPrefix(r, "0x")
+	if r == "" {
+		return nil, false
+	}
+	return new(big.Int).SetString(r, 16)
+}
+
+// parseBlockTag validates a block param and returns it as passed to the
+// node: a named tag, "latest" by default, or a block number, which may be
+// given in decimal and is converted to hex.
+func parseBlockTag(s string) (string, error) {
+	switch s {
+	case "":
+		return "latest", nil
+	case "latest", "finalized", "safe", "pending":
+		return s, nil
+	}
+	if strings.HasPrefix(s, "0x") {
+		n, ok := parseHexBig(s)
+		if ok && n.IsUint64() {
+			return hexutil.EncodeUint64(n.Uint64()), nil
+		}
+	} else if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return hexutil.EncodeUint64(n), nil
+	}
+	return "", fmt.Errorf("block '%s' is not valid, must be latest, finalized, safe, pending or a block number", s)
+}
+
+// rawMaxLengthParam returns the rawMaxLength param, defaultRawMaxLength if
+// unset.
+func rawMaxLengthParam(params url.Values) (int, error) {
+	l := params.Get("rawMaxLength")
+	if l == "" {
+		return defaultRawMaxLength, nil
+	}
+	n, err := strconv.Atoi(l)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("rawMaxLength '%s' is not valid", l)
+	}
+	return n, nil
+}
+
+// parseNetVersion parses a net_version result, which is normally a decimal
+// string but is returned hex encoded by some nodes.
+func parseNetVersion(v string) (*big.Int, bool) {
+	if strings.HasPrefix(v, "0x") {
+		return parseHexBig(v)
+	}
+	return new(big.Int).SetString(v, 10)
+}
+
+// decodeSlot0 decodes sqrtPriceX96 and tick, the first two words of a
+// Uniswap V3 pool's slot0() result.
+func decodeSlot0(r string) (*big.Int, int64, bool) {
+	r = strings.TrimPrefix(r, "0x")
+	if len(r) < 128 {
+		return nil, 0, false
+	}
+	sqrtPriceX96, ok := new(big.Int).SetString(r[:64], 16)
+	if !ok {
+		return nil, 0, false
+	}
+	tick, ok := new(big.Int).SetString(r[64:128], 16)
+	if !ok {
+		return nil, 0, false
+	}
+	// tick is an int24, sign extended to 256 bits.
+	if tick.Bit(255) == 1 {
+		tick.Sub(tick, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	if !tick.IsInt64() {
+		return nil, 0, false
+	}
+	return sqrtPriceX96, tick.Int64(), true
+}
+
+// univ3Price returns the price of token0 in token1, (sqrtPriceX96 / 2^96)^2
+// scaled by 10^(decimals0-decimals1).
+func univ3Price(sqrtPriceX96 *big.Int, decimals0, decimals1 int) *big.Float {
+	sq := new(big.Int).Mul(sqrtPriceX96, sqrtPriceX96)
+	price := new(big.Float).SetPrec(256).SetInt(sq)
+	price.SetMantExp(price, -192)
+	if decimals0 >= decimals1 {
+		return price.Mul(price, new(big.Float).SetInt(pow10(decimals0-decimals1)))
+	}
+	return price.Quo(price, new(big.Float).SetInt(pow10(decimals1-decimals0)))
+}
+
+// unpackABIResult decodes the hex encoded result of an eth_call to method.
+func unpackABIResult(abiObj abi.ABI, method, result string) ([]interface{}, error) {
+	data, err := hexutil.Decode(result)
+	if err != nil {
+		return nil, err
+	}
+	return abiObj.Unpack(method, data)
+}
+
+// readChainlinkPrice reads the latest answer of a Chainlink feed, scaled by
+// the feed's decimals.
+func readChainlinkPrice(ctx context.Context, client *rpc.Client, feed string) (float64, error) {
+	abiObj, err := abi.JSON(strings.NewReader(chainlinkAggregatorABI))
+	if err != nil {
+		return 0, err
+	}
+	decimalsCallData, err := abiObj.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+	roundCallData, err := abiObj.Pack("latestRoundData")
+	if err != nil {
+		return 0, err
+	}
+	batch := []rpc.BatchElem{
+		newEthCallElem(feed, decimalsCallData, "latest"),
+		newEthCallElem(feed, roundCallData, "latest"),
+	}
+	if err := client.BatchCallContext(ctx, batch); err != nil {
+		return 0, err
+	}
+	var decoded [2][]interface{}
+	for i, method := range []string{"decimals", "latestRoundData"} {
+		if batch[i].Error != nil {
+			return 0, fmt.Errorf("%s call failed, %w", method, batch[i].Error)
+		}
+		decoded[i], err = unpackABIResult(abiObj, method, *batch[i].Result.(*string))
+		if err != nil {
+			return 0, fmt.Errorf("unexpected %s result, %w", method, err)
+		}
+	}
+	decimals := decoded[0][0].(uint8)
+	answer := decoded[1][1].(*big.Int)
+	price, _ := new(big.Float).Quo(new(big.Float).SetInt(answer), new(big.Float).SetInt(pow10(int(decimals)))).Float64()
+	return price, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// packGetter packs the call data of a no-argument view function.
+func packGetter(name, outputType string) ([]byte, error) {
+	if !getterNameRE.MatchString(name) {
+		return nil, fmt.Errorf("invalid getter name %q", name)
+	}
+	abiObj, err := abi.JSON(strings.NewReader(fmt.Sprintf(`[{"name":%q,"type":"function","inputs":[],"outputs":[{"name":"","type":%q}]}]`, name, outputType)))
+	if err != nil {
+		return nil, err
+	}
+	return abiObj.Pack(name)
+}
+
+// packAccountGetter packs the call data of a view function taking a single
+// address, such as earned(address).
+func packAccountGetter(name string, account common.Address) ([]byte, error) {
+	if !getterNameRE.MatchString(name) {
+		return nil, fmt.Errorf("invalid getter name %q", name)
+	}
+	abiObj, err := abi.JSON(strings.NewReader(fmt.Sprintf(`[{"name":%q,"type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]`, name)))
+	if err != nil {
+		return nil, err
+	}
+	return abiObj.Pack(name, account)
+}
+
+// congestionScore returns the weighted mean of the components, each clamped to
+// [0, 1]. Weights of components that could not be read are simply left out.
+func congestionScore(components []congestionComponent) float64 {
+	var sum, weights float64
+	for _, c := range components {
+		sum += math.Min(math.Max(c.Value, 0), 1) * c.Weight
+		weights += c.Weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+func floatParam(params url.Values, name string, def float64) (float64, error) {
+	v := params.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+func weiToGwei(wei *big.Int) *big.Float {
+	return new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.GWei))
+}
+
 func weiToEther(wei *big.Int) *big.Float {
 	f := new(big.Float)
 	f.SetPrec(236) //  IEEE 754 octuple-precision binary floating-point format: binary256
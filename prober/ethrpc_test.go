@@ -0,0 +1,3511 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+type jsonRPCTestRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type jsonRPCTestError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+func (e *jsonRPCTestError) Error() string {
+	return e.Message
+}
+
+type jsonRPCTestResponse struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Result  interface{}       `json:"result,omitempty"`
+	Error   *jsonRPCTestError `json:"error,omitempty"`
+}
+
+// newJSONRPCTestServer starts a JSON-RPC 2.0 server answering both single and
+// batched requests with handle. Returning a *jsonRPCTestError from handle
+// produces a JSON-RPC error object; any other error becomes code -32000.
+func newJSONRPCTestServer(t *testing.T, handle func(method string, params []json.RawMessage) (interface{}, error)) *httptest.Server {
+	return httptest.NewServer(jsonRPCTestHandler(t, handle))
+}
+
+// jsonRPCTestHandler is the handler behind newJSONRPCTestServer, for tests
+// that need to wrap it.
+func jsonRPCTestHandler(t *testing.T, handle func(method string, params []json.RawMessage) (interface{}, error)) http.Handler {
+	answer := func(req jsonRPCTestRequest) jsonRPCTestResponse {
+		resp := jsonRPCTestResponse{JSONRPC: "2.0", ID: req.ID}
+		result, err := handle(req.Method, req.Params)
+		if err != nil {
+			rpcErr, ok := err.(*jsonRPCTestError)
+			if !ok {
+				rpcErr = &jsonRPCTestError{Code: -32000, Message: err.Error()}
+			}
+			resp.Error = rpcErr
+			return resp
+		}
+		resp.Result = result
+		if result == nil {
+			// A null result, e.g. an unknown transaction, must still be sent.
+			resp.Result = json.RawMessage("null")
+		}
+		return resp
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Error reading request body: %s", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && body[0] == '[' {
+			var reqs []jsonRPCTestRequest
+			if err := json.Unmarshal(body, &reqs); err != nil {
+				t.Errorf("Error decoding batch request: %s", err)
+				return
+			}
+			resps := make([]jsonRPCTestResponse, 0, len(reqs))
+			for _, req := range reqs {
+				resps = append(resps, answer(req))
+			}
+			json.NewEncoder(w).Encode(resps)
+			return
+		}
+		var req jsonRPCTestRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+			return
+		}
+		json.NewEncoder(w).Encode(answer(req))
+	})
+}
+
+// callTarget returns the "to" field of an eth_call/eth_estimateGas params list.
+func callTarget(params []json.RawMessage) string {
+	var msg struct {
+		To string `json:"to"`
+	}
+	if len(params) > 0 {
+		json.Unmarshal(params[0], &msg)
+	}
+	return strings.ToLower(msg.To)
+}
+
+// callSelector returns the 4-byte selector of an eth_call params list, as a
+// 0x-prefixed hex string.
+func callSelector(params []json.RawMessage) string {
+	var msg struct {
+		Data string `json:"data"`
+	}
+	if len(params) > 0 {
+		json.Unmarshal(params[0], &msg)
+	}
+	if len(msg.Data) < 10 {
+		return msg.Data
+	}
+	return msg.Data[:10]
+}
+
+// selector returns the 4-byte selector of a function signature such as
+// "balanceOf(address)".
+func selector(signature string) string {
+	return hexutil.Encode(crypto.Keccak256([]byte(signature))[:4])
+}
+
+// word left-pads a hex string to a single 32-byte ABI word.
+func word(hexValue string) string {
+	hexValue = strings.TrimPrefix(hexValue, "0x")
+	return strings.Repeat("0", 64-len(hexValue)) + hexValue
+}
+
+func probeETHRPC(t *testing.T, target string, params url.Values) (bool, []*dto.MetricFamily) {
+	return probeETHRPCModule(t, target, params, config.Module{Timeout: time.Second})
+}
+
+func probeETHRPCModule(t *testing.T, target string, params url.Values, module config.Module) (bool, []*dto.MetricFamily) {
+	registry := prometheus.NewRegistry()
+	testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := ProbeETHRPC(testCTX, target, params, module, registry, log.NewNopLogger())
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, mfs
+}
+
+// gaugeValues flattens the series of a metric family into a map keyed by the
+// value of label.
+func gaugeValues(mfs []*dto.MetricFamily, name, label string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.Metric {
+			key := ""
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					key = l.GetValue()
+				}
+			}
+			res[key] = m.GetGauge().GetValue()
+		}
+	}
+	return res
+}
+
+func TestETHRPCOwnership(t *testing.T) {
+	const (
+		renounced = "0x1111111111111111111111111111111111111111"
+		owned     = "0x2222222222222222222222222222222222222222"
+		owner     = "0x000000000000000000000000000000000000dEaD"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) == renounced {
+				return "0x" + word("0"), nil
+			}
+			return "0x" + word(owner), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":   {"ownership"},
+		"contract": {"Renounced:" + renounced, "Owned:" + owned},
+	})
+	if !result {
+		t.Fatalf("ownership probe failed unexpectedly")
+	}
+
+	got := gaugeValues(mfs, "probe_ethrpc_ownership_renounced", "contractName")
+	if got["Renounced"] != 1 {
+		t.Errorf("Expected Renounced to be renounced, got %v", got["Renounced"])
+	}
+	if got["Owned"] != 0 {
+		t.Errorf("Expected Owned not to be renounced, got %v", got["Owned"])
+	}
+	owners := gaugeValues(mfs, "probe_ethrpc_owner", "owner")
+	if _, ok := owners[owner]; !ok {
+		t.Errorf("Expected owner %s in probe_ethrpc_owner, got %v", owner, owners)
+	}
+}
+
+func TestETHRPCContractCallAssert(t *testing.T) {
+	const (
+		token  = "0x1111111111111111111111111111111111111111"
+		pauser = "0x2222222222222222222222222222222222222222"
+		holder = "0x3333333333333333333333333333333333333333"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) == token {
+				// 5 * 10^18
+				return "0x" + word("4563918244f40000"), nil
+			}
+			return "0x" + word("0"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	calls := []string{
+		"Token|" + token + `|[{"name":"balanceOf","type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]|` + holder,
+		"Pauser|" + pauser + `|[{"name":"paused","type":"function","inputs":[],"outputs":[{"name":"","type":"bool"}]}]`,
+	}
+
+	tests := []struct {
+		assert  string
+		success bool
+	}{
+		{"Token > 0 AND Pauser == false", true},
+		{"Token.balanceOf == 5 && !(Pauser == true)", true},
+		{"Token > 10 AND Pauser == false", false},
+		{"Token > 0 AND Pauser == true", false},
+		{"Unknown > 0", false},
+		{"Token >", false},
+	}
+	for _, test := range tests {
+		result, _ := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"contract_call"},
+			"call":   calls,
+			"assert": {test.assert},
+		})
+		if result != test.success {
+			t.Errorf("assert %q: expected success %v, got %v", test.assert, test.success, result)
+		}
+	}
+}
+
+func TestETHRPCCongestionScore(t *testing.T) {
+	tests := []struct {
+		txpool bool
+		params url.Values
+		score  float64
+	}{
+		// base fee at the reference (1.0), half full block (0.5), empty txpool (0).
+		{true, url.Values{}, 0.4*1 + 0.4*0.5 + 0.2*0},
+		// txpool_status unsupported: remaining weights are renormalized.
+		{false, url.Values{}, (0.4*1 + 0.4*0.5) / 0.8},
+		{true, url.Values{"baseFeeWeight": {"0"}, "gasUsedWeight": {"1"}, "txpoolWeight": {"0"}}, 0.5},
+		{true, url.Values{"baseFeeRef": {"200"}}, 0.4*0.5 + 0.4*0.5 + 0.2*0},
+	}
+	for i, test := range tests {
+		ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+			switch method {
+			case "eth_chainId":
+				return "0x1", nil
+			case "eth_getBlockByNumber":
+				return map[string]string{
+					"number":        "0x10",
+					"timestamp":     "0x6500000",
+					"gasUsed":       "0xe4e1c0",
+					"gasLimit":      "0x1c9c380",
+					"baseFeePerGas": "0x174876e800",
+				}, nil
+			case "txpool_status":
+				if test.txpool {
+					return map[string]string{"pending": "0x0", "queued": "0x0"}, nil
+				}
+			}
+			return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+		})
+
+		test.params.Set("module", "congestion")
+		result, mfs := probeETHRPC(t, ts.URL, test.params)
+		ts.Close()
+		if !result {
+			t.Fatalf("Test %d: congestion probe failed unexpectedly", i)
+		}
+		score := gaugeValues(mfs, "probe_ethrpc_congestion_score", "")[""]
+		if math.Abs(score-test.score) > 1e-9 {
+			t.Errorf("Test %d: expected score %v, got %v", i, test.score, score)
+		}
+	}
+}
+
+type testSubscriptionService struct {
+	heads int
+}
+
+func (s *testSubscriptionService) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(1))
+}
+
+func (s *testSubscriptionService) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	go func() {
+		for i := 1; i <= s.heads; i++ {
+			notifier.Notify(sub.ID, map[string]string{"number": hexutil.EncodeUint64(uint64(i))})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	return sub, nil
+}
+
+func newWSTestServer(t *testing.T, service interface{}) (*httptest.Server, string) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	return ts, "ws://" + strings.TrimPrefix(ts.URL, "http://")
+}
+
+func TestETHRPCWSSubscriptionLiveness(t *testing.T) {
+	tests := []struct {
+		heads   int
+		success bool
+	}{
+		{3, true},
+		{0, false},
+	}
+	for _, test := range tests {
+		ts, target := newWSTestServer(t, &testSubscriptionService{heads: test.heads})
+
+		result, mfs := probeETHRPC(t, target, url.Values{
+			"module": {"ws_subscription_liveness"},
+			"window": {"500ms"},
+		})
+		ts.Close()
+		if result != test.success {
+			t.Errorf("heads=%d: expected success %v, got %v", test.heads, test.success, result)
+		}
+		received := gaugeValues(mfs, "probe_ws_events_received", "subscription")["newHeads"]
+		if received != float64(test.heads) {
+			t.Errorf("heads=%d: expected %d events, got %v", test.heads, test.heads, received)
+		}
+		alive := gaugeValues(mfs, "probe_ws_subscription_alive", "subscription")["newHeads"]
+		if (alive == 1) != test.success {
+			t.Errorf("heads=%d: unexpected probe_ws_subscription_alive %v", test.heads, alive)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_subscription_received", "subscription"); len(got) != 0 {
+			t.Errorf("heads=%d: unexpected probe_ethrpc_subscription_received without until=first %v", test.heads, got)
+		}
+	}
+}
+
+func TestETHRPCWSSubscriptionUntilFirst(t *testing.T) {
+	tests := []struct {
+		heads   int
+		success bool
+	}{
+		{3, true},
+		{0, false},
+	}
+	for _, test := range tests {
+		ts, target := newWSTestServer(t, &testSubscriptionService{heads: test.heads})
+
+		// Without a window, the probe waits up to its timeout.
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		start := time.Now()
+		result := ProbeETHRPC(ctx, target, url.Values{
+			"module": {"ws_subscription_liveness"},
+			"until":  {"first"},
+		}, config.Module{Timeout: 500 * time.Millisecond}, registry, log.NewNopLogger())
+		elapsed := time.Since(start)
+		cancel()
+		ts.Close()
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != test.success {
+			t.Errorf("heads=%d: expected success %v, got %v", test.heads, test.success, result)
+		}
+		if test.success && elapsed > 250*time.Millisecond {
+			t.Errorf("heads=%d: expected the probe to stop at the first notification, took %v", test.heads, elapsed)
+		}
+		received, ok := gaugeValues(mfs, "probe_ethrpc_subscription_received", "subscription")["newHeads"]
+		if !ok || (received == 1) != test.success {
+			t.Errorf("heads=%d: unexpected probe_ethrpc_subscription_received %v", test.heads, received)
+		}
+		first, ok := gaugeValues(mfs, "probe_ethrpc_first_notification_seconds", "subscription")["newHeads"]
+		if ok != test.success || first < 0 || first > 0.5 {
+			t.Errorf("heads=%d: unexpected probe_ethrpc_first_notification_seconds %v", test.heads, first)
+		}
+		if got := gaugeValues(mfs, "probe_ws_subscription_alive", "subscription"); len(got) != 0 {
+			t.Errorf("heads=%d: unexpected probe_ws_subscription_alive with until=first %v", test.heads, got)
+		}
+	}
+}
+
+// testBlockingService answers eth_call only once the request is abandoned.
+type testBlockingService struct {
+	testSubscriptionService
+}
+
+func (s *testBlockingService) Call(ctx context.Context, msg map[string]interface{}, block string) (hexutil.Bytes, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// testNonceService answers eth_getTransactionCount over WebSocket.
+type testNonceService struct {
+	testSubscriptionService
+}
+
+func (s *testNonceService) GetTransactionCount(address string, block string) hexutil.Uint64 {
+	return 7
+}
+
+func TestETHRPCWSSingleConnection(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &testNonceService{}); err != nil {
+		t.Fatal(err)
+	}
+	var handshakes int32
+	wsHandler := server.WebsocketHandler([]string{"*"})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&handshakes, 1)
+		wsHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	// eth_chainId followed by a batch of eth_getTransactionCount.
+	result, mfs := probeETHRPC(t, "ws://"+strings.TrimPrefix(ts.URL, "http://"), url.Values{
+		"module":  {"nonce"},
+		"account": {"a:0x1111111111111111111111111111111111111111", "b:0x2222222222222222222222222222222222222222"},
+	})
+	if !result {
+		t.Fatalf("nonce probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_nonce", "accountName"); got["a"] != 7 || got["b"] != 7 {
+		t.Errorf("unexpected nonces %v", got)
+	}
+	if n := atomic.LoadInt32(&handshakes); n != 1 {
+		t.Errorf("expected all calls to share one WebSocket connection, got %d handshakes", n)
+	}
+}
+
+func TestETHRPCWSTransport(t *testing.T) {
+	ts, target := newWSTestServer(t, &testBlockingService{})
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := ProbeETHRPC(ctx, target, url.Values{
+		"module":   {"ownership"},
+		"contract": {"vault:0x1111111111111111111111111111111111111111"},
+	}, config.Module{Timeout: 300 * time.Millisecond}, registry, log.NewNopLogger())
+	if result {
+		t.Errorf("expected the probe to fail on timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("probe did not return after its timeout, took %s", elapsed)
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_transport", "transport"); got["ws"] != 1 || len(got) != 1 {
+		t.Errorf("expected transport ws, got %v", got)
+	}
+
+	hts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		return "0x1", nil
+	})
+	defer hts.Close()
+	_, mfs = probeETHRPC(t, strings.TrimPrefix(hts.URL, "http://"), url.Values{"module": {"net_chain_check"}})
+	if got := gaugeValues(mfs, "probe_ethrpc_transport", "transport"); got["http"] != 1 || len(got) != 1 {
+		t.Errorf("expected transport http for a target without scheme, got %v", got)
+	}
+}
+
+func TestETHRPCTimelock(t *testing.T) {
+	const (
+		timelock = "0x1111111111111111111111111111111111111111"
+		guardian = "0x000000000000000000000000000000000000dEaD"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			switch callSelector(params) {
+			case selector("getMinDelay()"), selector("delay()"):
+				// 2 days
+				return "0x" + word("2a300"), nil
+			case selector("guardian()"):
+				return "0x" + word(guardian), nil
+			}
+			return nil, &jsonRPCTestError{Code: -32000, Message: "execution reverted"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":         {"timelock"},
+		"contract":       {"Timelock:" + timelock},
+		"guardianGetter": {"guardian"},
+	})
+	if !result {
+		t.Fatalf("timelock probe failed unexpectedly")
+	}
+	if delay := gaugeValues(mfs, "probe_ethrpc_timelock_min_delay_seconds", "contractName")["Timelock"]; delay != 172800 {
+		t.Errorf("Expected min delay 172800, got %v", delay)
+	}
+	if _, ok := gaugeValues(mfs, "probe_ethrpc_guardian", "guardian")[guardian]; !ok {
+		t.Errorf("Expected guardian %s in probe_ethrpc_guardian", guardian)
+	}
+
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{
+		"module":      {"timelock"},
+		"contract":    {"Timelock:" + timelock},
+		"delayGetter": {"delay"},
+	})
+	if !result {
+		t.Fatalf("timelock probe with delay() getter failed unexpectedly")
+	}
+	if delay := gaugeValues(mfs, "probe_ethrpc_timelock_min_delay_seconds", "contractName")["Timelock"]; delay != 172800 {
+		t.Errorf("Expected min delay 172800, got %v", delay)
+	}
+
+	result, _ = probeETHRPC(t, ts.URL, url.Values{
+		"module":      {"timelock"},
+		"contract":    {"Timelock:" + timelock},
+		"delayGetter": {"minDelay"},
+	})
+	if result {
+		t.Errorf("Expected probe to fail when the delay getter reverts")
+	}
+}
+
+func TestETHRPCTimelockGuardianChanged(t *testing.T) {
+	const timelock = "0x1111111111111111111111111111111111111111"
+	guardianResult := "0x" + word("dead")
+	delayResult := "0x" + word("2a300")
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			switch callSelector(params) {
+			case selector("getMinDelay()"):
+				return delayResult, nil
+			case selector("guardian()"):
+				return guardianResult, nil
+			}
+			return nil, &jsonRPCTestError{Code: -32000, Message: "execution reverted"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+	params := url.Values{
+		"module":         {"timelock"},
+		"contract":       {"Timelock:" + timelock},
+		"guardianGetter": {"guardian"},
+	}
+
+	for i, test := range []struct {
+		guardian string
+		changed  float64
+	}{
+		{word("dead"), 0},
+		{word("dead"), 0},
+		{word("beef"), 1},
+		{word("beef"), 0},
+	} {
+		guardianResult = "0x" + test.guardian
+		result, mfs := probeETHRPC(t, ts.URL, params)
+		if !result {
+			t.Fatalf("probe %d: timelock probe failed unexpectedly", i)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_guardian_changed", "contractName"); len(got) != 1 || got["Timelock"] != test.changed {
+			t.Errorf("probe %d: expected guardian changed %v, got %v", i, test.changed, got)
+		}
+	}
+
+	// A contract without the getter answers "0x", not a zero guardian.
+	guardianResult = "0x"
+	if result, _ := probeETHRPC(t, ts.URL, params); result {
+		t.Errorf("expected an empty guardian result to fail the probe")
+	}
+
+	guardianResult = "0x" + word("dead")
+	delayResult = "0x" + word("10000000000000000")
+	if result, mfs := probeETHRPC(t, ts.URL, params); result {
+		t.Errorf("expected a delay over 64 bits to fail the probe, got %v", gaugeValues(mfs, "probe_ethrpc_timelock_min_delay_seconds", "contractName"))
+	}
+}
+
+func TestETHRPCBillableRequests(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_gasPrice":
+			return "0x3b9aca00", nil
+		case "eth_blockNumber":
+			return "0x10", nil
+		case "eth_getBalance":
+			return "0xde0b6b3a7640000", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	balance := url.Values{
+		"module": {"balance"},
+		"account": {
+			"a:0x1111111111111111111111111111111111111111",
+			"b:0x2222222222222222222222222222222222222222",
+			"c:0x3333333333333333333333333333333333333333",
+		},
+	}
+	chainInfo := url.Values{"module": {"chain_info"}}
+
+	tests := []struct {
+		params       url.Values
+		billingModel string
+		billable     float64
+	}{
+		// eth_chainId plus one batch of three eth_getBalance.
+		{balance, "", 4},
+		{balance, "per_method", 4},
+		{balance, "per_request", 2},
+		// eth_chainId, eth_gasPrice, eth_blockNumber, eth_getBlockByNumber,
+		// net_peerCount and eth_syncing sent individually.
+		{chainInfo, "per_method", 6},
+		{chainInfo, "per_request", 6},
+	}
+	for _, test := range tests {
+		module := config.Module{Timeout: time.Second, ETHRPC: config.ETHRPCProbe{BillingModel: test.billingModel}}
+		result, mfs := probeETHRPCModule(t, ts.URL, test.params, module)
+		if !result {
+			t.Fatalf("%s probe failed unexpectedly", test.params.Get("module"))
+		}
+		got := gaugeValues(mfs, "probe_rpc_billable_requests", "rpc")[ts.URL]
+		if got != test.billable {
+			t.Errorf("%s with billing model %q: expected %v billable requests, got %v", test.params.Get("module"), test.billingModel, test.billable, got)
+		}
+	}
+}
+
+func TestETHRPCClientCache(t *testing.T) {
+	ts := httptest.NewUnstartedServer(jsonRPCTestHandler(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "net_version":
+			return "1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	}))
+	var conns int32
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		ttl   time.Duration
+		conns int32
+		hits  []float64
+	}{
+		// Connections are closed with each probe.
+		{0, 3, []float64{0, 0, 0}},
+		// The connection of the first probe serves the next ones.
+		{time.Minute, 1, []float64{0, 1, 1}},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&conns, 0)
+		module := config.Module{Timeout: time.Second, ETHRPC: config.ETHRPCProbe{ClientIdleTTL: test.ttl}}
+		for i, want := range test.hits {
+			result, mfs := probeETHRPCModule(t, ts.URL, url.Values{"module": {"net_chain_check"}}, module)
+			if !result {
+				t.Fatalf("ttl %v: probe %d failed unexpectedly", test.ttl, i)
+			}
+			if got := gaugeValues(mfs, "probe_rpc_client_cache_hits", "rpc")[ts.URL]; got != want {
+				t.Errorf("ttl %v: probe %d expected %v cache hits, got %v", test.ttl, i, want, got)
+			}
+		}
+		if got := atomic.LoadInt32(&conns); got != test.conns {
+			t.Errorf("ttl %v: expected %d connections, got %d", test.ttl, test.conns, got)
+		}
+	}
+}
+
+func TestETHRPCRateLimit(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getBalance":
+			return "0xde0b6b3a7640000", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	// eth_chainId takes one of the two tokens, the batch of two
+	// eth_getBalance would have to wait a second for the other, past the
+	// deadline.
+	module := config.Module{Timeout: time.Second, ETHRPC: config.ETHRPCProbe{RateLimit: 1, RateLimitBurst: 2}}
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	result := ProbeETHRPC(ctx, ts.URL, url.Values{
+		"module":  {"balance"},
+		"account": {"a:0x1111111111111111111111111111111111111111", "b:0x2222222222222222222222222222222222222222"},
+	}, module, registry, log.NewNopLogger())
+	if result {
+		t.Errorf("expected the rate limited probe to fail")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_error", "type"); got[rpcErrorRateLimitedLocal] != 1 {
+		t.Errorf("expected a %s error, got %v", rpcErrorRateLimitedLocal, got)
+	}
+}
+
+func TestETHRPCAddressLabelNormalization(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getBalance":
+			return "0xde0b6b3a7640000", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", checksummed},
+		{"checksum", checksummed},
+		{"lowercase", strings.ToLower(checksummed)},
+	}
+	for _, test := range tests {
+		module := config.Module{Timeout: time.Second, ETHRPC: config.ETHRPCProbe{AddressLabelFormat: test.format}}
+		for _, input := range []string{checksummed, strings.ToLower(checksummed), "0x" + strings.ToUpper(checksummed[2:])} {
+			result, mfs := probeETHRPCModule(t, ts.URL, url.Values{
+				"module":  {"balance"},
+				"account": {"treasury:" + input},
+			}, module)
+			if !result {
+				t.Fatalf("balance probe failed unexpectedly")
+			}
+			got := gaugeValues(mfs, "probe_ethrpc_balance", "accountAddress")
+			if _, ok := got[test.want]; !ok || len(got) != 1 {
+				t.Errorf("format %q, input %s: expected accountAddress %s, got %v", test.format, input, test.want, got)
+			}
+		}
+	}
+}
+
+func TestETHRPCNetChainCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		netVersion string
+		mismatch   float64
+	}{
+		{"matching", "10", 0},
+		{"matching hex", "0xa", 0},
+		{"mismatched", "1", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+				switch method {
+				case "eth_chainId":
+					return "0xa", nil
+				case "net_version":
+					return test.netVersion, nil
+				}
+				return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+			})
+			defer ts.Close()
+
+			result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"net_chain_check"}})
+			if !result {
+				t.Fatalf("net_chain_check probe failed unexpectedly")
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_net_chain_mismatch", "chainId")["10"]; got != test.mismatch {
+				t.Errorf("expected probe_ethrpc_net_chain_mismatch %v, got %v", test.mismatch, got)
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_net_chain_info", "netVersion"); got[test.netVersion] != 1 {
+				t.Errorf("expected probe_ethrpc_net_chain_info with netVersion %s, got %v", test.netVersion, got)
+			}
+		})
+	}
+}
+
+func TestETHRPCEmitRaw(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_gasPrice":
+			return "0x3b9aca00", nil
+		case "eth_blockNumber":
+			return "0x10", nil
+		case "web3_clientVersion":
+			return "Geth/v1.13.12-stable-02eb36af/linux-amd64/go1.21.6", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}})
+	if !result {
+		t.Fatalf("chain_info probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_raw", "method"); len(got) != 0 {
+		t.Errorf("expected no raw results without emitRaw, got %v", got)
+	}
+
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}, "emitRaw": {"true"}, "rawMaxLength": {"20"}})
+	if !result {
+		t.Fatalf("chain_info probe failed unexpectedly")
+	}
+	raw := make(map[string]string)
+	for _, mf := range mfs {
+		if mf.GetName() != "probe_jsonrpc_raw" {
+			continue
+		}
+		for _, m := range mf.Metric {
+			var method, value string
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "method":
+					method = l.GetValue()
+				case "value":
+					value = l.GetValue()
+				}
+			}
+			raw[method] = value
+		}
+	}
+	expected := map[string]string{
+		"eth_chainId":        "0x1",
+		"eth_gasPrice":       "0x3b9aca00",
+		"eth_blockNumber":    "0x10",
+		"web3_clientVersion": "Geth/v1.13.12-stable...",
+	}
+	for method, want := range expected {
+		if raw[method] != want {
+			t.Errorf("expected raw %s %q, got %q", method, want, raw[method])
+		}
+	}
+}
+
+func TestETHRPCStakingRewards(t *testing.T) {
+	const (
+		staking  = "0x00000000000000000000000000000000000000aa"
+		accruing = "0x00000000000000000000000000000000000000b1"
+		stalled  = "0x00000000000000000000000000000000000000b2"
+	)
+	earned := selector("earned(address)")
+	calls := 0
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) != staking || callSelector(params) != earned {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+			}
+			var msg struct {
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			if strings.HasSuffix(msg.Data, accruing[2:]) {
+				calls++
+				// 1.5 tokens, growing by 1 wei per call.
+				return "0x" + word(new(big.Int).Add(big.NewInt(15e17), big.NewInt(int64(calls))).Text(16)), nil
+			}
+			return "0x" + word("de0b6b3a7640000"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	probeParams := url.Values{
+		"module":   {"staking_rewards"},
+		"contract": {"pool:" + staking},
+		"account":  {"accruing:" + accruing, "stalled:" + stalled},
+	}
+	result, mfs := probeETHRPC(t, ts.URL, probeParams)
+	if !result {
+		t.Fatalf("staking_rewards probe failed unexpectedly")
+	}
+	rewards := gaugeValues(mfs, "probe_ethrpc_pending_rewards", "accountName")
+	if rewards["stalled"] != 1 || math.Abs(rewards["accruing"]-1.5) > 1e-9 {
+		t.Errorf("unexpected pending rewards %v", rewards)
+	}
+	// Nothing to compare against on the first probe.
+	if got := gaugeValues(mfs, "probe_ethrpc_rewards_stalled", "accountName"); got["accruing"] != 0 || got["stalled"] != 0 {
+		t.Errorf("expected no stalled rewards on the first probe, got %v", got)
+	}
+
+	result, mfs = probeETHRPC(t, ts.URL, probeParams)
+	if !result {
+		t.Fatalf("staking_rewards probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_rewards_stalled", "accountName"); got["accruing"] != 0 || got["stalled"] != 1 {
+		t.Errorf("expected only the stalled account to be flagged, got %v", got)
+	}
+
+	// A window longer than the time between probes defers the flag.
+	probeParams.Set("window", "1h")
+	_, mfs = probeETHRPC(t, ts.URL, probeParams)
+	if got := gaugeValues(mfs, "probe_ethrpc_rewards_stalled", "accountName"); got["stalled"] != 0 {
+		t.Errorf("expected stalled rewards within the window not to be flagged, got %v", got)
+	}
+}
+
+func TestETHRPCLogsConsistency(t *testing.T) {
+	logEntry := func(index int) map[string]interface{} {
+		return map[string]interface{}{
+			"blockHash":       "0x" + word("b1"),
+			"transactionHash": "0x" + word("c1"),
+			"logIndex":        hexutil.EncodeUint64(uint64(index)),
+		}
+	}
+	tests := []struct {
+		name       string
+		filterLogs []map[string]interface{}
+		noFilter   bool
+		consistent map[string]float64
+		supported  float64
+	}{
+		{
+			name:       "consistent",
+			filterLogs: []map[string]interface{}{logEntry(1), logEntry(0)},
+			consistent: map[string]float64{"1": 1},
+			supported:  1,
+		},
+		{
+			name:       "missing log",
+			filterLogs: []map[string]interface{}{logEntry(0)},
+			consistent: map[string]float64{"1": 0},
+			supported:  1,
+		},
+		{
+			name:       "filters unsupported",
+			noFilter:   true,
+			consistent: map[string]float64{},
+			supported:  0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uninstalled := false
+			ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+				switch method {
+				case "eth_chainId":
+					return "0x1", nil
+				case "eth_blockNumber":
+					return "0x64", nil
+				case "eth_getLogs":
+					var criteria struct {
+						FromBlock string `json:"fromBlock"`
+						ToBlock   string `json:"toBlock"`
+					}
+					json.Unmarshal(params[0], &criteria)
+					if criteria.FromBlock != "0x5b" || criteria.ToBlock != "0x64" {
+						t.Errorf("unexpected block range %s-%s", criteria.FromBlock, criteria.ToBlock)
+					}
+					return []map[string]interface{}{logEntry(0), logEntry(1)}, nil
+				case "eth_newFilter":
+					if test.noFilter {
+						return nil, &jsonRPCTestError{Code: -32601, Message: "the method eth_newFilter does not exist/is not available"}
+					}
+					return "0x1", nil
+				case "eth_getFilterLogs":
+					return test.filterLogs, nil
+				case "eth_uninstallFilter":
+					uninstalled = true
+					return true, nil
+				}
+				return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+			})
+			defer ts.Close()
+
+			result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"logs_consistency"}})
+			if !result {
+				t.Fatalf("logs_consistency probe failed unexpectedly")
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_logs_consistent", "chainId"); len(got) != len(test.consistent) || got["1"] != test.consistent["1"] {
+				t.Errorf("expected probe_ethrpc_logs_consistent %v, got %v", test.consistent, got)
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_logs_filter_supported", "chainId")["1"]; got != test.supported {
+				t.Errorf("expected probe_ethrpc_logs_filter_supported %v, got %v", test.supported, got)
+			}
+			if !test.noFilter && !uninstalled {
+				t.Errorf("expected the filter to be uninstalled")
+			}
+		})
+	}
+}
+
+func TestETHRPCContractCallScale(t *testing.T) {
+	const pool = "0x4444444444444444444444444444444444444444"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			// sqrtPriceX96 for a price of 2.25: 1.5 * 2^96.
+			return "0x" + word("18"+strings.Repeat("0", 23)), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	call := "Pool|" + pool + `|[{"name":"sqrtPriceX96","type":"function","inputs":[],"outputs":[{"name":"","type":"uint160"}]}]`
+	tests := []struct {
+		scale string
+		want  float64
+	}{
+		{"", 1.5 * math.Pow(2, 96)},
+		{"value / 2^96", 1.5},
+		{"(value / 2^96) * (value / 2^96)", 2.25},
+		{"value / 2^96 / 1e18 * 1e18 + 1", 2.5},
+	}
+	for _, test := range tests {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"contract_call"},
+			"call":   {call},
+			"scale":  {test.scale},
+		})
+		if !result {
+			t.Fatalf("scale %q: contract_call probe failed unexpectedly", test.scale)
+		}
+		got := gaugeValues(mfs, "probe_ethrpc_contract_call", "contractName")["Pool"]
+		if math.Abs(got-test.want) > 1e-9*test.want {
+			t.Errorf("scale %q: expected %v, got %v", test.scale, test.want, got)
+		}
+	}
+
+	if result, _ := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"contract_call"},
+		"call":   {call},
+		"scale":  {"value / price"},
+	}); result {
+		t.Errorf("expected an unknown variable in scale to fail the probe")
+	}
+}
+
+func TestETHRPCHeaders(t *testing.T) {
+	var got http.Header
+	handler := jsonRPCTestHandler(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_gasPrice":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	module := config.Module{
+		Timeout: time.Second,
+		ETHRPC: config.ETHRPCProbe{
+			Headers: map[string]pconfig.Secret{"X-Api-Key": "configured"},
+		},
+	}
+	result, _ := probeETHRPCModule(t, ts.URL, url.Values{
+		"module": {"chain_info"},
+		"header": {"Authorization: Bearer xyz", "X-Api-Key:from-param"},
+	}, module)
+	if !result {
+		t.Fatalf("chain_info probe failed unexpectedly")
+	}
+	if v := got.Get("Authorization"); v != "Bearer xyz" {
+		t.Errorf("expected Authorization header %q, got %q", "Bearer xyz", v)
+	}
+	if v := got.Values("X-Api-Key"); len(v) != 2 || v[0] != "configured" || v[1] != "from-param" {
+		t.Errorf("expected accumulated X-Api-Key headers, got %v", v)
+	}
+
+	if result, _ := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"chain_info"},
+		"header": {"no-separator"},
+	}); result {
+		t.Errorf("expected an invalid header param to fail the probe")
+	}
+}
+
+func TestETHRPCUniV3Slot0(t *testing.T) {
+	const pool = "0x5555555555555555555555555555555555555555"
+	// sqrtPriceX96 = 2 * 2^96, a raw price of 4 token1 units per token0 unit.
+	sqrtPriceX96 := new(big.Int).Lsh(big.NewInt(2), 96)
+	// tick -201000, sign extended to 256 bits.
+	tick := new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(-201000))
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) != pool || callSelector(params) != selector("slot0()") {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+			}
+			return "0x" + word(sqrtPriceX96.Text(16)) + word(tick.Text(16)) + strings.Repeat(word("1"), 5), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		decimals0, decimals1 string
+		price                float64
+	}{
+		{"", "", 4},
+		{"18", "6", 4e12},
+		{"6", "18", 4e-12},
+	}
+	for _, test := range tests {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":    {"univ3_slot0"},
+			"pool":      {"usdc_weth:" + pool},
+			"decimals0": {test.decimals0},
+			"decimals1": {test.decimals1},
+		})
+		if !result {
+			t.Fatalf("univ3_slot0 probe failed unexpectedly")
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_univ3_price", "poolName")["usdc_weth"]; math.Abs(got-test.price) > 1e-9*test.price {
+			t.Errorf("decimals %s/%s: expected price %v, got %v", test.decimals0, test.decimals1, test.price, got)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_univ3_tick", "poolName")["usdc_weth"]; got != -201000 {
+			t.Errorf("expected tick -201000, got %v", got)
+		}
+	}
+}
+
+func TestETHRPCL2Output(t *testing.T) {
+	const oracle = "0x6666666666666666666666666666666666666666"
+	posted := time.Now().Add(-90 * time.Minute).Unix()
+	var requestedIndex string
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) != oracle {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+			}
+			var msg struct {
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			switch callSelector(params) {
+			case selector("latestOutputIndex()"):
+				return "0x" + word("1f4"), nil
+			case selector("getL2Output(uint256)"):
+				requestedIndex = msg.Data[10:]
+				return "0x" + word("abcd") + word(strconv.FormatInt(posted, 16)) + word("7a120"), nil
+			}
+			return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"l2_output"},
+		"oracle": {"optimism:" + oracle},
+	})
+	if !result {
+		t.Fatalf("l2_output probe failed unexpectedly")
+	}
+	if requestedIndex != word("1f4") {
+		t.Errorf("expected getL2Output to be called with the latest index, got %s", requestedIndex)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_l2_output_index", "contractName")["optimism"]; got != 500 {
+		t.Errorf("expected output index 500, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_l2_output_block_number", "contractName")["optimism"]; got != 500000 {
+		t.Errorf("expected L2 block 500000, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_l2_output_age_seconds", "contractName")["optimism"]; got < 5400 || got > 5460 {
+		t.Errorf("expected an output age of about 5400s, got %v", got)
+	}
+}
+
+func TestETHRPCTimeout(t *testing.T) {
+	handler := jsonRPCTestHandler(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		}
+		return "0x" + word("1"), nil
+	})
+	release := make(chan struct{})
+	defer close(release)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// Batches hang until the test ends, well past the probe deadline.
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := ProbeETHRPC(ctx, ts.URL, url.Values{
+		"module":   {"ownership"},
+		"contract": {"vault:0x1111111111111111111111111111111111111111"},
+	}, config.Module{Timeout: 300 * time.Millisecond}, registry, log.NewNopLogger())
+	if result {
+		t.Errorf("expected the probe to fail on timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("probe did not return promptly after its deadline, took %s", elapsed)
+	}
+}
+
+func TestETHRPCChainInfoGasPriceFailure(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x10", nil
+		case "eth_getBlockByNumber":
+			return map[string]string{"number": "0x10", "timestamp": "0x65f0a000"}, nil
+		case "eth_syncing":
+			return false, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}})
+	if result {
+		t.Errorf("expected a failed eth_gasPrice to fail the probe")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_gas_price", "chainId"); len(got) != 0 {
+		t.Errorf("expected no gas price, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_block_number", "chainId"); got["1"] != 16 {
+		t.Errorf("expected the block number to still be reported, got %v", got)
+	}
+}
+
+func TestETHRPCCallSuccess(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x10", nil
+		case "eth_getBlockByNumber":
+			return map[string]string{"number": "0x10", "timestamp": "0x65f0a000"}, nil
+		case "eth_syncing":
+			return false, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}})
+	if result {
+		t.Errorf("expected a failed eth_gasPrice to fail the probe")
+	}
+	got := gaugeValues(mfs, "probe_jsonrpc_call_success", "method")
+	expected := map[string]float64{
+		"eth_chainId":          1,
+		"eth_gasPrice":         0,
+		"eth_blockNumber":      1,
+		"eth_getBlockByNumber": 1,
+		"net_peerCount":        0,
+		"eth_syncing":          1,
+	}
+	for method, want := range expected {
+		if v, ok := got[method]; !ok || v != want {
+			t.Errorf("expected call success %v for %s, got %v", want, method, got)
+		}
+	}
+}
+
+func TestETHRPCResponseBytesLimit(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "net_version":
+			// A padded, oversized answer.
+			return strings.Repeat("0", 8192) + "1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		limit   units.Base2Bytes
+		success bool
+	}{
+		{0, true},
+		{16 * units.KiB, true},
+		{units.KiB, false},
+	}
+	for _, test := range tests {
+		module := config.Module{Timeout: time.Second, ETHRPC: config.ETHRPCProbe{ResponseBytesLimit: test.limit}}
+		result, mfs := probeETHRPCModule(t, ts.URL, url.Values{"module": {"net_chain_check"}}, module)
+		if result != test.success {
+			t.Errorf("limit %d: expected success %v, got %v", test.limit, test.success, result)
+		}
+		read := gaugeValues(mfs, "probe_rpc_bytes_allocated", "rpc")[ts.URL]
+		if read == 0 {
+			t.Errorf("limit %d: expected probe_rpc_bytes_allocated to be set", test.limit)
+		}
+		if !test.success && read <= float64(test.limit) {
+			t.Errorf("limit %d: expected the aborted probe to report more than the limit, got %v", test.limit, read)
+		}
+	}
+}
+
+func TestETHRPCWithdrawalQueue(t *testing.T) {
+	const queue = "0x7777777777777777777777777777777777777777"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) != queue {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+			}
+			switch callSelector(params) {
+			case selector("queueLength()"):
+				return "0x" + word("2a"), nil
+			case selector("unfinalizedStETH()"):
+				// 1234.5 * 10^18
+				return "0x" + word(new(big.Int).Mul(big.NewInt(12345), big.NewInt(1e17)).Text(16)), nil
+			}
+			return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		amountGetter string
+		success      bool
+		amount       map[string]float64
+	}{
+		{"", true, map[string]float64{}},
+		{"unfinalizedStETH", true, map[string]float64{"lido": 1234.5}},
+		{"missing", false, map[string]float64{}},
+	}
+	for _, test := range tests {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":       {"withdrawal_queue"},
+			"contract":     {"lido:" + queue},
+			"getter":       {"queueLength"},
+			"amountGetter": {test.amountGetter},
+		})
+		if result != test.success {
+			t.Errorf("amountGetter %q: expected success %v, got %v", test.amountGetter, test.success, result)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_pending_withdrawals", "contractName")["lido"]; got != 42 {
+			t.Errorf("amountGetter %q: expected 42 pending withdrawals, got %v", test.amountGetter, got)
+		}
+		got := gaugeValues(mfs, "probe_ethrpc_pending_withdrawals_amount", "contractName")
+		if len(got) != len(test.amount) || math.Abs(got["lido"]-test.amount["lido"]) > 1e-9 {
+			t.Errorf("amountGetter %q: expected amount %v, got %v", test.amountGetter, test.amount, got)
+		}
+	}
+
+	if result, _ := probeETHRPC(t, ts.URL, url.Values{
+		"module":   {"withdrawal_queue"},
+		"contract": {"lido:" + queue},
+	}); result {
+		t.Errorf("expected a missing getter to fail the probe")
+	}
+}
+
+func TestETHRPCGasPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		block    map[string]interface{}
+		feeType  string
+		baseFees map[string]float64
+	}{
+		{
+			name:     "eip1559",
+			block:    map[string]interface{}{"number": "0x10", "baseFeePerGas": "0x3b9aca00"},
+			feeType:  "eip1559",
+			baseFees: map[string]float64{"1": 1},
+		},
+		{
+			name:     "legacy",
+			block:    map[string]interface{}{"number": "0x10"},
+			feeType:  "legacy",
+			baseFees: map[string]float64{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+				switch method {
+				case "eth_chainId":
+					return "0x1", nil
+				case "eth_gasPrice":
+					// 20.5 gwei
+					return "0x4c5e52d00", nil
+				case "eth_getBlockByNumber":
+					return test.block, nil
+				}
+				return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+			})
+			defer ts.Close()
+
+			result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"gas_price"}})
+			if !result {
+				t.Fatalf("gas_price probe failed unexpectedly")
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_gas_price_gwei", "feeType"); len(got) != 1 || got[test.feeType] != 20.5 {
+				t.Errorf("expected a %s gas price of 20.5 gwei, got %v", test.feeType, got)
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_gas_price_wei", "feeType"); len(got) != 1 || got[test.feeType] != 20.5e9 {
+				t.Errorf("expected a %s gas price of 20.5e9 wei, got %v", test.feeType, got)
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_gas_price_usd", "feeType"); len(got) != 0 {
+				t.Errorf("expected no USD gas price without a feed, got %v", got)
+			}
+			if got := gaugeValues(mfs, "probe_ethrpc_base_fee_per_gas", "chainId"); len(got) != len(test.baseFees) || got["1"] != test.baseFees["1"] {
+				t.Errorf("expected base fee %v, got %v", test.baseFees, got)
+			}
+		})
+	}
+}
+
+func TestETHRPCGasPriceUSD(t *testing.T) {
+	const feed = "0x5f4ec3df9cbd43714fe2740f5e3616155c5b8419"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_gasPrice":
+			// 20.5 gwei
+			return "0x4c5e52d00", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{"number": "0x10", "baseFeePerGas": "0x3b9aca00"}, nil
+		case "eth_call":
+			if callTarget(params) != feed {
+				break
+			}
+			switch callSelector(params) {
+			case selector("decimals()"):
+				return "0x" + word("8"), nil
+			case selector("latestRoundData()"):
+				// 2000 with 8 decimals
+				return "0x" + word("12") + word("2e90edd000") + word("65f0a000") + word("65f0a0e0") + word("12"), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"gas_price"}, "feed": {feed}})
+	if !result {
+		t.Fatalf("gas_price probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_gas_price_wei", "feeType"); got["eip1559"] != 20.5e9 {
+		t.Errorf("expected a gas price of 20.5e9 wei, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_gas_price_gwei", "feeType"); got["eip1559"] != 20.5 {
+		t.Errorf("expected a gas price of 20.5 gwei, got %v", got)
+	}
+	// 20.5 gwei at 2000 USD per ETH.
+	if got := gaugeValues(mfs, "probe_ethrpc_gas_price_usd", "feeType"); math.Abs(got["eip1559"]-4.1e-5) > 1e-15 {
+		t.Errorf("expected a gas price of 4.1e-5 USD, got %v", got)
+	}
+}
+
+func TestETHRPCTxReceipt(t *testing.T) {
+	var (
+		minedHash    = "0x" + strings.Repeat("a", 64)
+		revertedHash = "0x" + strings.Repeat("b", 64)
+		pendingHash  = "0x" + strings.Repeat("c", 64)
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getTransactionReceipt":
+			var hash string
+			json.Unmarshal(params[0], &hash)
+			switch hash {
+			case minedHash:
+				return map[string]interface{}{"status": "0x1", "blockNumber": "0x5a"}, nil
+			case revertedHash:
+				return map[string]interface{}{"status": "0x0", "blockNumber": "0x60"}, nil
+			}
+			return nil, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"tx_receipt"},
+		"txhash": {minedHash, revertedHash},
+	})
+	if !result {
+		t.Fatalf("tx_receipt probe failed unexpectedly")
+	}
+	status := gaugeValues(mfs, "probe_ethrpc_tx_status", "txhash")
+	if status[minedHash] != 1 || status[revertedHash] != 0 {
+		t.Errorf("unexpected tx status %v", status)
+	}
+	confirmations := gaugeValues(mfs, "probe_ethrpc_tx_confirmations", "txhash")
+	if confirmations[minedHash] != 10 || confirmations[revertedHash] != 4 {
+		t.Errorf("unexpected tx confirmations %v", confirmations)
+	}
+
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{
+		"module": {"tx_receipt"},
+		"txhash": {pendingHash},
+	})
+	if result {
+		t.Errorf("expected a transaction without receipt to fail the probe")
+	}
+	if got, ok := gaugeValues(mfs, "probe_ethrpc_tx_status", "txhash")[pendingHash]; !ok || !math.IsNaN(got) {
+		t.Errorf("expected a NaN status for a pending transaction, got %v", got)
+	}
+	if _, ok := gaugeValues(mfs, "probe_ethrpc_tx_confirmations", "txhash")[pendingHash]; ok {
+		t.Errorf("expected no confirmations for a pending transaction")
+	}
+}
+
+func TestETHRPCNonce(t *testing.T) {
+	const (
+		relayer1 = "0x1111111111111111111111111111111111111111"
+		relayer2 = "0x2222222222222222222222222222222222222222"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getTransactionCount":
+			var address, block string
+			json.Unmarshal(params[0], &address)
+			json.Unmarshal(params[1], &block)
+			if block != "latest" {
+				t.Errorf("expected the latest block, got %s", block)
+			}
+			if address == relayer1 {
+				return "0x2a", nil
+			}
+			return "0x0", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":  {"nonce"},
+		"account": {"relayer1:" + relayer1, "relayer2:" + relayer2, "invalid"},
+	})
+	if !result {
+		t.Fatalf("nonce probe failed unexpectedly")
+	}
+	got := gaugeValues(mfs, "probe_ethrpc_nonce", "accountName")
+	if len(got) != 2 || got["relayer1"] != 42 || got["relayer2"] != 0 {
+		t.Errorf("unexpected nonces %v", got)
+	}
+}
+
+func TestETHRPCBalanceBlockTag(t *testing.T) {
+	const treasury = "0x1111111111111111111111111111111111111111"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getBalance":
+			var block string
+			json.Unmarshal(params[1], &block)
+			switch block {
+			case "latest":
+				return "0x29a2241af62c0000", nil // 3 ether
+			case "finalized", "0x10":
+				return "0x1bc16d674ec80000", nil // 2 ether
+			}
+			return nil, &jsonRPCTestError{Code: -32602, Message: "invalid block " + block}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		block   string
+		success bool
+		label   string
+		balance float64
+	}{
+		{"", true, "latest", 3},
+		{"finalized", true, "finalized", 2},
+		{"16", true, "0x10", 2},
+		{"0x10", true, "0x10", 2},
+		{"final", false, "", 0},
+		{"0xzz", false, "", 0},
+	}
+	for _, test := range tests {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":  {"balance"},
+			"account": {"treasury:" + treasury},
+			"block":   {test.block},
+		})
+		if result != test.success {
+			t.Errorf("block %q: expected success %v, got %v", test.block, test.success, result)
+			continue
+		}
+		if !test.success {
+			continue
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_balance", "block"); len(got) != 1 || got[test.label] != test.balance {
+			t.Errorf("block %q: expected balance %v with block label %s, got %v", test.block, test.balance, test.label, got)
+		}
+	}
+}
+
+func TestETHRPCAccountActivated(t *testing.T) {
+	const coldWallet = "0x9999999999999999999999999999999999999999"
+	nonce := "0x0"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getTransactionCount":
+			return nonce, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	probeParams := url.Values{
+		"module":     {"nonce"},
+		"account":    {"cold:" + coldWallet},
+		"activation": {"true"},
+	}
+	for i, step := range []struct {
+		nonce     string
+		activated float64
+	}{
+		{"0x0", 0},
+		{"0x0", 0},
+		// The 0 -> 1 transition.
+		{"0x1", 1},
+		{"0x2", 0},
+	} {
+		nonce = step.nonce
+		result, mfs := probeETHRPC(t, ts.URL, probeParams)
+		if !result {
+			t.Fatalf("step %d: nonce probe failed unexpectedly", i)
+		}
+		got, ok := gaugeValues(mfs, "probe_ethrpc_account_activated", "accountName")["cold"]
+		if !ok || got != step.activated {
+			t.Errorf("step %d: expected probe_ethrpc_account_activated %v, got %v", i, step.activated, got)
+		}
+	}
+
+	_, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"nonce"}, "account": {"cold:" + coldWallet}})
+	if got := gaugeValues(mfs, "probe_ethrpc_account_activated", "accountName"); len(got) != 0 {
+		t.Errorf("expected no activation metric without activation=true, got %v", got)
+	}
+}
+
+func TestETHRPCDurationByPhase(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			time.Sleep(50 * time.Millisecond)
+			return "0x1", nil
+		case "net_version":
+			return "1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"net_chain_check"}})
+	if !result {
+		t.Fatalf("net_chain_check probe failed unexpectedly")
+	}
+	phases := gaugeValues(mfs, "probe_ethrpc_duration_seconds", "phase")
+	if len(phases) != 2 {
+		t.Fatalf("expected dial and call phases, got %v", phases)
+	}
+	if phases["call"] < 0.05 {
+		t.Errorf("expected the call phase to include the slow eth_chainId, got %v", phases["call"])
+	}
+	if modules := gaugeValues(mfs, "probe_ethrpc_duration_seconds", "module"); len(modules) != 1 || modules["net_chain_check"] == 0 {
+		t.Errorf("expected durations labelled with the module, got %v", modules)
+	}
+}
+
+// answerMulticall3 answers an aggregate3 eth_call by running each call
+// through handleCall, as Multicall3 would.
+func answerMulticall3(t *testing.T, data string, handleCall func(to string, data []byte) ([]byte, bool)) string {
+	abiObj, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := abiObj.Methods["aggregate3"]
+	args, err := method.Inputs.Unpack(common.FromHex(data)[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := *abi.ConvertType(args[0], new([]multicallCall)).(*[]multicallCall)
+	results := make([]multicallResult, len(calls))
+	for i, c := range calls {
+		results[i].ReturnData, results[i].Success = handleCall(strings.ToLower(c.Target.Hex()), c.CallData)
+	}
+	out, err := method.Outputs.Pack(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(out)
+}
+
+func TestETHRPCContractCallMulticall(t *testing.T) {
+	const (
+		token  = "0x1111111111111111111111111111111111111111"
+		pauser = "0x2222222222222222222222222222222222222222"
+		holder = "0x3333333333333333333333333333333333333333"
+	)
+	multicallAddress := strings.ToLower(defaultMulticall3Address)
+	handleCall := func(to string, data []byte) ([]byte, bool) {
+		switch to {
+		case token:
+			// 5 * 10^18
+			return common.FromHex(word("4563918244f40000")), true
+		case pauser:
+			return common.FromHex(word("1")), true
+		}
+		return nil, false
+	}
+	var ethCalls int
+	deployed := true
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			ethCalls++
+			var msg struct {
+				To   string `json:"to"`
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			if strings.ToLower(msg.To) == multicallAddress {
+				if !deployed {
+					return "0x", nil
+				}
+				return answerMulticall3(t, msg.Data, handleCall), nil
+			}
+			if out, ok := handleCall(strings.ToLower(msg.To), common.FromHex(msg.Data)); ok {
+				return hexutil.Encode(out), nil
+			}
+			return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	calls := []string{
+		"Token|" + token + `|[{"name":"balanceOf","type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]|` + holder,
+		"Pauser|" + pauser + `|[{"name":"paused","type":"function","inputs":[],"outputs":[{"name":"","type":"bool"}]}]`,
+		"Missing|0x4444444444444444444444444444444444444444" + `|[{"name":"paused","type":"function","inputs":[],"outputs":[{"name":"","type":"bool"}]}]`,
+	}
+	tests := []struct {
+		deployed bool
+		ethCalls int
+	}{
+		// A single aggregate3 call.
+		{true, 1},
+		// The failed aggregate3 call plus the three individual calls.
+		{false, 4},
+	}
+	for _, test := range tests {
+		deployed = test.deployed
+		ethCalls = 0
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":    {"contract_call"},
+			"call":      calls,
+			"multicall": {"true"},
+		})
+		if !result {
+			t.Fatalf("deployed=%v: contract_call probe failed unexpectedly", test.deployed)
+		}
+		if ethCalls != test.ethCalls {
+			t.Errorf("deployed=%v: expected %d eth_call requests, got %d", test.deployed, test.ethCalls, ethCalls)
+		}
+		got := gaugeValues(mfs, "probe_ethrpc_contract_call", "contractName")
+		if len(got) != 2 || got["Token"] != 5 || got["Pauser"] != 1 {
+			t.Errorf("deployed=%v: unexpected results %v", test.deployed, got)
+		}
+	}
+}
+
+// revertData returns the Error(string) revert payload of reason.
+func revertData(t *testing.T, reason string) string {
+	stringTy, _ := abi.NewType("string", "", nil)
+	packed, err := abi.Arguments{{Type: stringTy}}.Pack(reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(append([]byte{0x08, 0xc3, 0x79, 0xa0}, packed...))
+}
+
+func TestETHRPCEstimateGas(t *testing.T) {
+	const (
+		router = "0x1111111111111111111111111111111111111111"
+		paused = "0x2222222222222222222222222222222222222222"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_estimateGas":
+			if callTarget(params) == paused {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted: Pausable: paused", Data: revertData(t, "Pausable: paused")}
+			}
+			return "0x1d4c0", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"estimate_gas"},
+		"call": {
+			"swap:0x3333333333333333333333333333333333333333:" + router + ":0x12345678",
+			"deposit::" + paused + ":0xd0e30db0",
+		},
+	})
+	if result {
+		t.Errorf("expected a reverting call to fail the probe")
+	}
+	got := gaugeValues(mfs, "probe_ethrpc_estimate_gas", "name")
+	if len(got) != 2 || got["swap"] != 120000 || !math.IsNaN(got["deposit"]) {
+		t.Errorf("unexpected estimates %v", got)
+	}
+}
+
+func TestETHRPCTLSConfig(t *testing.T) {
+	ts := httptest.NewUnstartedServer(jsonRPCTestHandler(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "net_version":
+			return "1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	}))
+	ts.TLS = &tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		minVersion   uint16
+		cipherSuites []string
+		success      bool
+	}{
+		{"defaults", 0, nil, true},
+		{"tls12", tls.VersionTLS12, nil, true},
+		{"tls13", tls.VersionTLS13, nil, false},
+		{"allowed cipher suite", tls.VersionTLS12, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, true},
+		{"disallowed cipher suite", tls.VersionTLS12, []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, false},
+	}
+	for _, test := range tests {
+		module := config.Module{Timeout: time.Second, ETHRPC: config.ETHRPCProbe{
+			TLSConfig: pconfig.TLSConfig{
+				InsecureSkipVerify: true,
+				MinVersion:         pconfig.TLSVersion(test.minVersion),
+			},
+			CipherSuites: test.cipherSuites,
+		}}
+		result, mfs := probeETHRPCModule(t, ts.URL, url.Values{"module": {"net_chain_check"}}, module)
+		if result != test.success {
+			t.Errorf("%s: expected success %v, got %v", test.name, test.success, result)
+		}
+		if !test.success {
+			continue
+		}
+		want := float64(ts.Certificate().NotAfter.Unix())
+		if got := gaugeValues(mfs, "probe_jsonrpc_ssl_earliest_cert_expiry", "rpc")[ts.URL]; got != want {
+			t.Errorf("%s: expected probe_jsonrpc_ssl_earliest_cert_expiry %v, got %v", test.name, want, got)
+		}
+	}
+}
+
+func TestETHRPCContractGas(t *testing.T) {
+	const (
+		router = "0x1111111111111111111111111111111111111111"
+		vault  = "0x2222222222222222222222222222222222222222"
+		other  = "0x3333333333333333333333333333333333333333"
+	)
+	type tx struct {
+		Hash string  `json:"hash"`
+		To   *string `json:"to"`
+	}
+	to := func(a string) *string { return &a }
+	// Block 2 falls outside a 3 block window ending at block 5.
+	blocks := map[string][]tx{
+		"0x2": {{"0x" + word("a1"), to(router)}},
+		"0x3": {{"0x" + word("b1"), to(router)}, {"0x" + word("b2"), to(other)}},
+		"0x4": {{"0x" + word("c1"), nil}},
+		"0x5": {{"0x" + word("d1"), to(router)}, {"0x" + word("d2"), to(router)}},
+	}
+	gasUsed := map[string]string{
+		"0x" + word("a1"): "0x1000000",
+		"0x" + word("b1"): "0x7530",
+		"0x" + word("b2"): "0x5208",
+		"0x" + word("c1"): "0x5208",
+		"0x" + word("d1"): "0xea60",
+		"0x" + word("d2"): "0xafc8",
+	}
+	var requested []string
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x5", nil
+		case "eth_getBlockByNumber":
+			var number string
+			json.Unmarshal(params[0], &number)
+			requested = append(requested, number)
+			return map[string]interface{}{"transactions": blocks[number]}, nil
+		case "eth_getTransactionReceipt":
+			var hash string
+			json.Unmarshal(params[0], &hash)
+			return map[string]string{"gasUsed": gasUsed[hash]}, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":   {"contract_gas"},
+		"contract": {"router:" + router, "vault:" + vault},
+		"blocks":   {"3"},
+	})
+	if !result {
+		t.Fatalf("contract_gas probe failed unexpectedly")
+	}
+	if len(requested) != 3 {
+		t.Errorf("expected 3 blocks to be fetched, got %v", requested)
+	}
+	calls := gaugeValues(mfs, "probe_ethrpc_contract_calls", "contractName")
+	if calls["router"] != 3 || calls["vault"] != 0 {
+		t.Errorf("unexpected call counts %v", calls)
+	}
+	avg := gaugeValues(mfs, "probe_ethrpc_contract_avg_gas", "contractName")
+	if avg["router"] != 45000 {
+		t.Errorf("expected an average of 45000 gas for router, got %v", avg["router"])
+	}
+	if !math.IsNaN(avg["vault"]) {
+		t.Errorf("expected a NaN average for a contract without calls, got %v", avg["vault"])
+	}
+
+	result, _ = probeETHRPC(t, ts.URL, url.Values{
+		"module":   {"contract_gas"},
+		"contract": {"router:" + router},
+		"blocks":   {"1000"},
+	})
+	if result {
+		t.Errorf("expected a window above the bound to fail the probe")
+	}
+}
+
+func TestETHRPCContractCallTupleOutputs(t *testing.T) {
+	const (
+		feed     = "0x5f4ec3df9cbd43714fe2740f5e3616155c5b8419"
+		settings = "0x6666666666666666666666666666666666666666"
+		splits   = "0x7777777777777777777777777777777777777777"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			switch callSelector(params) {
+			case selector("latestRoundData()"):
+				// A 2000 USD answer with 8 decimals.
+				return "0x" + word("12") + word("2e90edd000") + word("65f0a000") + word("65f0a0e0") + word("12"), nil
+			case selector("getConfig()"):
+				return "0x" + word("e10") + word("1"), nil
+			case selector("getShares()"):
+				return "0x" + word("20") + word("2") + word("1e") + word("46"), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"contract_call"},
+		"call": {
+			"EthUsd|" + feed + `|[{"name":"latestRoundData","type":"function","inputs":[],"outputs":[{"name":"roundId","type":"uint80"},{"name":"answer","type":"int256"},{"name":"startedAt","type":"uint256"},{"name":"updatedAt","type":"uint256"},{"name":"answeredInRound","type":"uint80"}]}]`,
+			"Settings|" + settings + `|[{"name":"getConfig","type":"function","inputs":[],"outputs":[{"name":"","type":"tuple","components":[{"name":"heartbeat","type":"uint32"},{"name":"paused","type":"bool"}]}]}]`,
+			"Splits|" + splits + `|[{"name":"getShares","type":"function","inputs":[],"outputs":[{"name":"","type":"uint16[]"}]}]`,
+		},
+		"scale": {"value"},
+	})
+	if !result {
+		t.Fatalf("contract_call probe failed unexpectedly")
+	}
+	got := gaugeValues(mfs, "probe_ethrpc_contract_call", "outputName")
+	want := map[string]float64{
+		"roundId":         18,
+		"answer":          200000000000,
+		"startedAt":       1710268416,
+		"updatedAt":       1710268640,
+		"answeredInRound": 18,
+		"heartbeat":       3600,
+		"paused":          1,
+		"0":               30,
+		"1":               70,
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d series, got %v", len(want), got)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("output %s: expected %v, got %v", name, v, got[name])
+		}
+	}
+}
+
+func TestETHRPCChainlink(t *testing.T) {
+	const (
+		ethUSD   = "0x5f4ec3df9cbd43714fe2740f5e3616155c5b8419"
+		stethETH = "0x86392dc19c0b719886221c78ab11eb8cf5c52812"
+		broken   = "0x8888888888888888888888888888888888888888"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callTarget(params) == broken {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+			}
+			switch callSelector(params) {
+			case selector("decimals()"):
+				if callTarget(params) == ethUSD {
+					return "0x" + word("8"), nil
+				}
+				return "0x" + word("12"), nil
+			case selector("latestRoundData()"):
+				answer := "2e90edd000" // 2000 with 8 decimals
+				if callTarget(params) == stethETH {
+					answer = "de0b6b3a7640000" // 1 with 18 decimals
+				}
+				return "0x" + word("12") + word(answer) + word("65f0a000") + word("65f0a0e0") + word("12"), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"chainlink"},
+		"feed":   {"ETH/USD:" + ethUSD, "stETH/ETH:" + stethETH},
+	})
+	if !result {
+		t.Fatalf("chainlink probe failed unexpectedly")
+	}
+	prices := gaugeValues(mfs, "probe_ethrpc_chainlink_price", "feedName")
+	if prices["ETH/USD"] != 2000 || prices["stETH/ETH"] != 1 {
+		t.Errorf("unexpected prices %v", prices)
+	}
+	updatedAt := gaugeValues(mfs, "probe_ethrpc_chainlink_updated_at", "feedName")
+	if updatedAt["ETH/USD"] != 1710268640 {
+		t.Errorf("unexpected updatedAt %v", updatedAt)
+	}
+
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{
+		"module": {"chainlink"},
+		"feed":   {"ETH/USD:" + ethUSD, "broken:" + broken},
+	})
+	if result {
+		t.Errorf("expected a reverting feed to fail the probe")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_chainlink_price", "feedName"); len(got) != 1 || got["ETH/USD"] != 2000 {
+		t.Errorf("expected the healthy feed to still be reported, got %v", got)
+	}
+}
+
+func TestETHRPCDebugOutputCalls(t *testing.T) {
+	const treasury = "0x1111111111111111111111111111111111111111"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getBalance":
+			return "0xde0b6b3a7640000", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	conf := &config.Config{Modules: map[string]config.Module{
+		"balance": {
+			Prober:  "ethrpc",
+			Timeout: time.Second,
+			ETHRPC:  config.ETHRPCProbe{Headers: map[string]pconfig.Secret{"Authorization": "Bearer configsecret"}},
+		},
+	}}
+	req, err := http.NewRequest("GET", "?debug=true&module=balance&account=treasury:"+treasury+"&header=X-Api-Key:paramsecret&target="+ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	Handler(rr, req, conf, log.NewNopLogger(), &ResultHistory{}, 0.5, nil, nil, level.AllowNone())
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"Calls made by the probe:",
+		"eth_chainId",
+		"eth_getBalance  0xde0b6b3a7640000  1      probe_ethrpc_balance{accountName=\"treasury\",block=\"latest\"}",
+		"Target: " + ts.URL,
+		"POST " + ts.URL,
+		`"method":"eth_getBalance"`,
+		`"result":"0xde0b6b3a7640000"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in debug output: %v", want, body)
+		}
+	}
+	for _, secret := range []string{"configsecret", "paramsecret"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("secret %q exposed in debug output: %v", secret, body)
+		}
+	}
+}
+
+func TestETHRPCChainInfoBlockLag(t *testing.T) {
+	blockTime := time.Now().Add(-90 * time.Second).Unix()
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_gasPrice":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x10", nil
+		case "eth_getBlockByNumber":
+			return map[string]string{"number": "0x10", "timestamp": hexutil.EncodeUint64(uint64(blockTime))}, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}})
+	if !result {
+		t.Fatalf("chain_info probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_block_timestamp", "rpc")[ts.URL]; got != float64(blockTime) {
+		t.Errorf("expected block timestamp %d, got %v", blockTime, got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_block_lag_seconds", "rpc")[ts.URL]; got < 90 || got > 100 {
+		t.Errorf("expected a block lag of about 90s, got %v", got)
+	}
+}
+
+func TestETHRPCChainlinkStaleness(t *testing.T) {
+	const (
+		fresh = "0x5f4ec3df9cbd43714fe2740f5e3616155c5b8419"
+		stale = "0x86392dc19c0b719886221c78ab11eb8cf5c52812"
+	)
+	now := time.Now().Unix()
+	updatedAt := map[string]int64{fresh: now - 60, stale: now - 7200}
+	handleCall := func(to string, data []byte) ([]byte, bool) {
+		switch hexutil.Encode(data[:4]) {
+		case selector("decimals()"):
+			return common.FromHex(word("8")), true
+		case selector("latestRoundData()"):
+			ts := strconv.FormatInt(updatedAt[to], 16)
+			return common.FromHex(word("12") + word("2e90edd000") + word(ts) + word(ts) + word("12")), true
+		}
+		return nil, false
+	}
+	var ethCalls int
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			ethCalls++
+			var msg struct {
+				To   string `json:"to"`
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			if strings.EqualFold(msg.To, defaultMulticall3Address) {
+				return answerMulticall3(t, msg.Data, handleCall), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"chainlink"},
+		"feed":   {"fresh:" + fresh, "stale:" + stale},
+	})
+	if !result {
+		t.Fatalf("chainlink probe failed unexpectedly")
+	}
+	if ethCalls != 1 {
+		t.Errorf("expected the feeds to be read in a single multicall, got %d eth_call", ethCalls)
+	}
+	staleness := gaugeValues(mfs, "probe_ethrpc_chainlink_staleness_seconds", "feedName")
+	if staleness["fresh"] < 60 || staleness["fresh"] > 70 {
+		t.Errorf("expected the fresh feed to be about 60s old, got %v", staleness["fresh"])
+	}
+	if staleness["stale"] < 7200 || staleness["stale"] > 7210 {
+		t.Errorf("expected the stale feed to be about 7200s old, got %v", staleness["stale"])
+	}
+}
+
+func TestETHRPCChainInfoSyncing(t *testing.T) {
+	var syncing interface{}
+	peerCount := true
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId", "eth_gasPrice", "eth_blockNumber":
+			return "0x1", nil
+		case "net_peerCount":
+			if !peerCount {
+				return nil, &jsonRPCTestError{Code: -32601, Message: "the method net_peerCount does not exist"}
+			}
+			return "0x19", nil
+		case "eth_syncing":
+			return syncing, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	syncing = false
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}})
+	if !result {
+		t.Fatalf("chain_info probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_peer_count", "rpc")[ts.URL]; got != 25 {
+		t.Errorf("expected 25 peers, got %v", got)
+	}
+	if got, ok := gaugeValues(mfs, "probe_ethrpc_syncing", "rpc")[ts.URL]; !ok || got != 0 {
+		t.Errorf("expected probe_ethrpc_syncing 0, got %v", got)
+	}
+	if _, ok := gaugeValues(mfs, "probe_ethrpc_sync_current_block", "rpc")[ts.URL]; ok {
+		t.Errorf("expected no sync progress for a synced node")
+	}
+
+	syncing = map[string]string{"startingBlock": "0x0", "currentBlock": "0x64", "highestBlock": "0xc8"}
+	peerCount = false
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{"module": {"chain_info"}})
+	if !result {
+		t.Fatalf("chain_info probe failed unexpectedly without net_peerCount")
+	}
+	if _, ok := gaugeValues(mfs, "probe_ethrpc_peer_count", "rpc")[ts.URL]; ok {
+		t.Errorf("expected no peer count when net_peerCount is unsupported")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_syncing", "rpc")[ts.URL]; got != 1 {
+		t.Errorf("expected probe_ethrpc_syncing 1, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_sync_current_block", "rpc")[ts.URL]; got != 100 {
+		t.Errorf("expected current block 100, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_sync_highest_block", "rpc")[ts.URL]; got != 200 {
+		t.Errorf("expected highest block 200, got %v", got)
+	}
+}
+
+func TestETHRPCChainlinkDeviation(t *testing.T) {
+	const (
+		chainlinkFeed = "0x5f4ec3df9cbd43714fe2740f5e3616155c5b8419"
+		otherFeed     = "0x9999999999999999999999999999999999999999"
+	)
+	handleCall := func(to string, data []byte) ([]byte, bool) {
+		switch hexutil.Encode(data[:4]) {
+		case selector("decimals()"):
+			// The feeds use different decimals, 8 and 18.
+			if to == chainlinkFeed {
+				return common.FromHex(word("8")), true
+			}
+			return common.FromHex(word("12")), true
+		case selector("latestRoundData()"):
+			// 2000 with 8 decimals, 2030 with 18 decimals.
+			answer := "2e90edd000"
+			if to == otherFeed {
+				answer = new(big.Int).Mul(big.NewInt(2030), pow10(18)).Text(16)
+			}
+			return common.FromHex(word("1") + word(answer) + word("1") + word("1") + word("1")), true
+		}
+		return nil, false
+	}
+	var ethCalls int
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			ethCalls++
+			var msg struct {
+				To   string `json:"to"`
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			if strings.EqualFold(msg.To, defaultMulticall3Address) {
+				return answerMulticall3(t, msg.Data, handleCall), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"chainlink"},
+		"mode":   {"deviation"},
+		"feed":   {"other:" + otherFeed, "chainlink:" + chainlinkFeed},
+	})
+	if !result {
+		t.Fatalf("chainlink deviation probe failed unexpectedly")
+	}
+	if ethCalls != 1 {
+		t.Errorf("expected both feeds to be read in a single multicall, got %d eth_call", ethCalls)
+	}
+	got := gaugeValues(mfs, "probe_ethrpc_feed_deviation_bps", "referenceName")["chainlink"]
+	if math.Abs(got-150) > 1e-9 {
+		t.Errorf("expected a deviation of 150bps, got %v", got)
+	}
+
+	result, _ = probeETHRPC(t, ts.URL, url.Values{
+		"module": {"chainlink"},
+		"mode":   {"deviation"},
+		"feed":   {"chainlink:" + chainlinkFeed},
+	})
+	if result {
+		t.Errorf("expected mode deviation with a single feed to fail the probe")
+	}
+}
+
+func TestETHRPCContractCallMalformedSpec(t *testing.T) {
+	const token = "0x1111111111111111111111111111111111111111"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			return "0x" + word("1"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	valid := "Pauser|" + token + `|[{"name":"paused","type":"function","inputs":[],"outputs":[{"name":"","type":"bool"}]}]`
+	tests := []struct {
+		name string
+		call string
+	}{
+		{"BadAbi", "BadAbi|" + token + `|[{"name":"paused","type":"function",`},
+		{"TooFewFields", "TooFewFields|" + token},
+		{"WrongArgs", "WrongArgs|" + token + `|[{"name":"balanceOf","type":"function","inputs":[{"name":"","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}]|` + token + "," + token},
+	}
+	for _, test := range tests {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"contract_call"},
+			"call":   {valid, test.call},
+		})
+		if result {
+			t.Errorf("%s: expected a malformed call spec to fail the probe", test.name)
+		}
+		errors := gaugeValues(mfs, "probe_ethrpc_contract_call_error", "contractName")
+		if errors[test.name] != 1 {
+			t.Errorf("%s: expected probe_ethrpc_contract_call_error 1, got %v", test.name, errors)
+		}
+		if v, ok := errors["Pauser"]; !ok || v != 0 {
+			t.Errorf("%s: expected probe_ethrpc_contract_call_error 0 for the valid spec, got %v", test.name, errors)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_contract_call", "contractName"); got["Pauser"] != 1 {
+			t.Errorf("%s: expected the valid spec to still be called, got %v", test.name, got)
+		}
+	}
+}
+
+func TestETHRPCERC20BalanceAtEvent(t *testing.T) {
+	const (
+		token  = "0x1111111111111111111111111111111111111111"
+		holder = "0x2222222222222222222222222222222222222222"
+	)
+	transfer := crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)")).Hex()
+	var callBlocks []string
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x100", nil
+		case "eth_getLogs":
+			var criteria struct {
+				FromBlock string   `json:"fromBlock"`
+				ToBlock   string   `json:"toBlock"`
+				Address   string   `json:"address"`
+				Topics    []string `json:"topics"`
+			}
+			json.Unmarshal(params[0], &criteria)
+			if criteria.FromBlock != "0xf7" || criteria.ToBlock != "0x100" {
+				t.Errorf("expected the range 0xf7-0x100, got %s-%s", criteria.FromBlock, criteria.ToBlock)
+			}
+			if criteria.Address != token || len(criteria.Topics) != 1 || criteria.Topics[0] != transfer {
+				t.Errorf("unexpected log filter %+v", criteria)
+			}
+			return []map[string]string{
+				{"blockHash": "0x" + word("b1"), "blockNumber": "0xfa", "transactionHash": "0x" + word("a1"), "logIndex": "0x0"},
+				{"blockHash": "0x" + word("b2"), "blockNumber": "0xf8", "transactionHash": "0x" + word("a2"), "logIndex": "0x3"},
+			}, nil
+		case "eth_call":
+			var block string
+			json.Unmarshal(params[1], &block)
+			callBlocks = append(callBlocks, block)
+			if callSelector(params) == selector("decimals()") {
+				return "0x" + word("12"), nil
+			}
+			// 2 * 10^18
+			return "0x" + word("1bc16d674ec80000"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":  {"erc20balance"},
+		"token":   {token},
+		"symbol":  {"TKN"},
+		"account": {"holder:" + holder},
+		"event":   {"Transfer(address,address,uint256)"},
+		"blocks":  {"10"},
+	})
+	if !result {
+		t.Fatalf("erc20balance probe failed unexpectedly")
+	}
+	if len(callBlocks) != 2 || callBlocks[0] != "0xfa" || callBlocks[1] != "0xfa" {
+		t.Errorf("expected the balance and decimals to be read at the event block 0xfa, got %v", callBlocks)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_erc20balance_event_block", "event")["Transfer(address,address,uint256)"]; got != 250 {
+		t.Errorf("expected event block 250, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_erc20balance", "accountName")["holder"]; got != 2 {
+		t.Errorf("expected a balance of 2, got %v", got)
+	}
+
+	result, _ = probeETHRPC(t, ts.URL, url.Values{
+		"module":  {"erc20balance"},
+		"token":   {token},
+		"account": {"holder:" + holder},
+		"event":   {transfer},
+		"blocks":  {"100000"},
+	})
+	if result {
+		t.Errorf("expected a search window above the bound to fail the probe")
+	}
+}
+
+func TestETHRPCErrorType(t *testing.T) {
+	const (
+		token  = "0x1111111111111111111111111111111111111111"
+		holder = "0x2222222222222222222222222222222222222222"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			return nil, &jsonRPCTestError{Code: -32005, Message: "limit exceeded"}
+		case "eth_getBalance":
+			// Balances are hex strings, a number cannot be decoded.
+			return 5, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+
+	tests := []struct {
+		name   string
+		target string
+		params url.Values
+		method string
+		tag    string
+		typ    string
+	}{
+		{"Reverted", ts.URL, url.Values{
+			"module": {"contract_call"},
+			"call":   {"Pauser|" + token + `|[{"name":"paused","type":"function","inputs":[],"outputs":[{"name":"","type":"bool"}]}]`},
+		}, "eth_call", "latest", "rpc_error"},
+		{"Undecodable", ts.URL, url.Values{
+			"module":  {"balance"},
+			"account": {"holder:" + holder},
+			"block":   {"safe"},
+		}, "eth_getBalance", "safe", "decode"},
+		{"RateLimited", limited.URL, url.Values{"module": {"chain_info"}}, "eth_chainId", "", "transport"},
+	}
+	for _, test := range tests {
+		_, mfs := probeETHRPC(t, test.target, test.params)
+		var found bool
+		for _, mf := range mfs {
+			if mf.GetName() != "probe_jsonrpc_error" {
+				continue
+			}
+			for _, m := range mf.Metric {
+				labels := make(map[string]string)
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labels["method"] != test.method || labels["tag"] != test.tag || labels["type"] != test.typ || m.GetGauge().GetValue() != 1 {
+					t.Errorf("%s: unexpected probe_jsonrpc_error %v", test.name, labels)
+					continue
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected probe_jsonrpc_error{method=%q,tag=%q,type=%q} 1", test.name, test.method, test.tag, test.typ)
+		}
+		// Only JSON-RPC error objects have a code.
+		codes := gaugeValues(mfs, "probe_jsonrpc_rpc_error_code", "method")
+		if test.typ == "rpc_error" {
+			if len(codes) != 1 || codes[test.method] != -32005 {
+				t.Errorf("%s: expected probe_jsonrpc_rpc_error_code -32005, got %v", test.name, codes)
+			}
+		} else if len(codes) != 0 {
+			t.Errorf("%s: expected no probe_jsonrpc_rpc_error_code, got %v", test.name, codes)
+		}
+	}
+}
+
+func TestETHRPCPoolTVL(t *testing.T) {
+	const (
+		pool    = "0x1111111111111111111111111111111111111111"
+		weth    = "0x2222222222222222222222222222222222222222"
+		usdc    = "0x3333333333333333333333333333333333333333"
+		ethUSD  = "0x4444444444444444444444444444444444444444"
+		usdcUSD = "0x5555555555555555555555555555555555555555"
+	)
+	handleCall := func(to string, data []byte) ([]byte, bool) {
+		sel := hexutil.Encode(data[:4])
+		switch to + sel {
+		case pool + selector("getReserves()"):
+			// 10 WETH and 20000 USDC.
+			return common.FromHex(word("8ac7230489e80000") + word("4a817c800") + word("65f0a0e0")), true
+		case pool + selector("token0()"):
+			return common.FromHex(word(weth[2:])), true
+		case pool + selector("token1()"):
+			return common.FromHex(word(usdc[2:])), true
+		case weth + selector("decimals()"):
+			return common.FromHex(word("12")), true
+		case usdc + selector("decimals()"):
+			return common.FromHex(word("6")), true
+		case ethUSD + selector("decimals()"), usdcUSD + selector("decimals()"):
+			return common.FromHex(word("8")), true
+		case ethUSD + selector("latestRoundData()"):
+			// 2000 with 8 decimals
+			return common.FromHex(word("12") + word("2e90edd000") + word("65f0a000") + word("65f0a0e0") + word("12")), true
+		case usdcUSD + selector("latestRoundData()"):
+			// 1 with 8 decimals
+			return common.FromHex(word("12") + word("5f5e100") + word("65f0a000") + word("65f0a0e0") + word("12")), true
+		}
+		return nil, false
+	}
+	var ethCalls int
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			ethCalls++
+			if callTarget(params) == strings.ToLower(defaultMulticall3Address) {
+				var msg struct {
+					Data string `json:"data"`
+				}
+				json.Unmarshal(params[0], &msg)
+				return answerMulticall3(t, msg.Data, handleCall), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"pool_tvl"},
+		"pool":   {"WETH/USDC:" + pool},
+		"feed":   {weth + ":" + ethUSD, usdc + ":" + usdcUSD},
+	})
+	if !result {
+		t.Fatalf("pool_tvl probe failed unexpectedly")
+	}
+	if ethCalls != 1 {
+		t.Errorf("expected a single eth_call, got %d", ethCalls)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_pool_tvl_usd", "poolName"); got["WETH/USDC"] != 40000 {
+		t.Errorf("expected a TVL of 40000, got %v", got)
+	}
+
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{
+		"module": {"pool_tvl"},
+		"pool":   {"WETH/USDC:" + pool},
+		"feed":   {weth + ":" + ethUSD},
+	})
+	if result {
+		t.Errorf("expected a token without a feed to fail the probe")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_pool_tvl_usd", "poolName"); len(got) != 0 {
+		t.Errorf("expected no TVL without a feed for every token, got %v", got)
+	}
+}
+
+func TestETHRPCRetries(t *testing.T) {
+	var requests, failures int
+	handler := jsonRPCTestHandler(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "net_version":
+			return "1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		retries string
+		success bool
+		used    float64
+	}{
+		{"3", true, 2},
+		{"1", false, 1},
+	}
+	for _, test := range tests {
+		requests, failures = 0, 2
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":         {"net_chain_check"},
+			"retries":        {test.retries},
+			"retryBackoffMs": {"1"},
+		})
+		if result != test.success {
+			t.Errorf("retries=%s: expected success %v, got %v", test.retries, test.success, result)
+		}
+		if got := gaugeValues(mfs, "probe_jsonrpc_retries", "reason"); len(got) != 1 || got[retryRateLimited] != test.used {
+			t.Errorf("retries=%s: expected %v rate limited retries used, got %v", test.retries, test.used, got)
+		}
+	}
+
+	requests, failures = 0, 1
+	if result, _ := probeETHRPC(t, ts.URL, url.Values{"module": {"net_chain_check"}}); result {
+		t.Errorf("expected calls not to be retried by default")
+	}
+}
+
+func TestETHRPCRetriesNonce(t *testing.T) {
+	const relayer = "0x1111111111111111111111111111111111111111"
+	var nonceRequests, failures int
+	handler := jsonRPCTestHandler(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getTransactionCount":
+			return "0x2a", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if bytes.Contains(body, []byte("eth_getTransactionCount")) {
+			nonceRequests++
+			if nonceRequests <= failures {
+				http.Error(w, "rate limited", http.StatusTooManyRequests)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	nonceRequests, failures = 0, 1
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":         {"nonce"},
+		"account":        {"relayer:" + relayer},
+		"retries":        {"1"},
+		"retryBackoffMs": {"1"},
+	})
+	if !result {
+		t.Fatalf("expected the nonce batch to be retried")
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_retries", "reason"); got[retryRateLimited] != 1 {
+		t.Errorf("expected 1 rate limited retry, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_call_success", "method"); got["eth_getTransactionCount"] != 1 {
+		t.Errorf("expected eth_getTransactionCount to succeed, got %v", got)
+	}
+
+	nonceRequests, failures = 0, 1
+	result, mfs = probeETHRPC(t, ts.URL, url.Values{
+		"module":  {"nonce"},
+		"account": {"relayer:" + relayer},
+	})
+	if result {
+		t.Errorf("expected the nonce probe to fail without retries")
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_call_success", "method"); len(got) != 2 || got["eth_getTransactionCount"] != 0 {
+		t.Errorf("expected eth_getTransactionCount to fail, got %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_error", "type"); got[rpcErrorTransport] != 1 {
+		t.Errorf("expected a transport error, got %v", got)
+	}
+}
+
+func TestETHRPCModuleCallErrors(t *testing.T) {
+	const proxy = "0x1111111111111111111111111111111111111111"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getStorageAt":
+			return nil, &jsonRPCTestError{Code: -32000, Message: "header not found"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"proxy_impl"},
+		"proxy":  {"bridge:" + proxy},
+	})
+	if result {
+		t.Errorf("expected the probe to fail")
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_call_success", "method"); got["eth_getStorageAt"] != 0 || got["eth_chainId"] != 1 {
+		t.Errorf("unexpected call success %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_rpc_error_code", "method"); got["eth_getStorageAt"] != -32000 {
+		t.Errorf("expected error code -32000, got %v", got)
+	}
+}
+
+func TestETHRPCUnknownModule(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		if method == "eth_chainId" {
+			return "0x1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	if result, _ := probeETHRPC(t, ts.URL, url.Values{"module": {"chain_infos"}}); result {
+		t.Errorf("expected an unknown module to fail the probe")
+	}
+}
+
+func TestETHRPCFallbackTargets(t *testing.T) {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "net_version":
+			return "1", nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	target := down.URL + "," + ts.URL
+	result, mfs := probeETHRPC(t, target, url.Values{"module": {"net_chain_check"}})
+	if !result {
+		t.Fatalf("expected the probe to fail over to the second endpoint")
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_active_target", "target"); len(got) != 1 || got[ts.URL] != 1 {
+		t.Errorf("expected %s to be the active target, got %v", ts.URL, got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_net_chain_info", "rpc"); got[target] != 1 {
+		t.Errorf("expected series labelled with the whole target list, got %v", got)
+	}
+
+	result, mfs = probeETHRPC(t, down.URL+","+down.URL, url.Values{"module": {"net_chain_check"}})
+	if result {
+		t.Errorf("expected the probe to fail when no endpoint is up")
+	}
+	if got := gaugeValues(mfs, "probe_jsonrpc_active_target", "target"); len(got) != 0 {
+		t.Errorf("expected no active target, got %v", got)
+	}
+}
+
+func TestETHRPCERC721Balance(t *testing.T) {
+	const (
+		collection = "0x1111111111111111111111111111111111111111"
+		alice      = "0x2222222222222222222222222222222222222222"
+		bob        = "0x3333333333333333333333333333333333333333"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			var msg struct {
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			switch callSelector(params) {
+			case selector("balanceOf(address)"):
+				if strings.HasSuffix(msg.Data, alice[2:]) {
+					return "0x" + word("3"), nil
+				}
+				return "0x" + word("0"), nil
+			case selector("ownerOf(uint256)"):
+				if strings.HasSuffix(msg.Data, word("2a")) {
+					return "0x" + word(alice[2:]), nil
+				}
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted: ERC721: invalid token ID"}
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	accounts := []string{"alice:" + alice, "bob:" + bob}
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module":  {"erc721balance"},
+		"token":   {collection},
+		"symbol":  {"PUNK"},
+		"account": accounts,
+		"tokenId": {"42"},
+	})
+	if !result {
+		t.Fatalf("erc721balance probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_erc721balance", "accountName"); got["alice"] != 3 || got["bob"] != 0 {
+		t.Errorf("unexpected balances %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_erc721_owned", "accountName"); got["alice"] != 1 || got["bob"] != 0 {
+		t.Errorf("unexpected ownership %v", got)
+	}
+
+	result, _ = probeETHRPC(t, ts.URL, url.Values{
+		"module":  {"erc721balance"},
+		"token":   {collection},
+		"account": accounts,
+		"tokenId": {"7"},
+	})
+	if result {
+		t.Errorf("expected a nonexistent tokenId to fail the probe")
+	}
+}
+
+func TestETHRPCTransferTax(t *testing.T) {
+	const (
+		taxed   = "0x1111111111111111111111111111111111111111"
+		untaxed = "0x2222222222222222222222222222222222222222"
+	)
+	balanceKey := crypto.Keccak256Hash(common.LeftPadBytes(transferTaxProbeAddress.Bytes(), 32), common.LeftPadBytes([]byte{3}, 32))
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if len(params) != 3 {
+				return nil, &jsonRPCTestError{Code: -32602, Message: "missing state override"}
+			}
+			var msg struct {
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			var overrides map[string]struct {
+				Code      string            `json:"code"`
+				StateDiff map[string]string `json:"stateDiff"`
+			}
+			json.Unmarshal(params[2], &overrides)
+			token := "0x" + msg.Data[26:66]
+			if overrides[strings.ToLower(transferTaxProbeAddress.Hex())].Code != transferTaxProbeCode {
+				return nil, &jsonRPCTestError{Code: -32602, Message: "probe code not overridden"}
+			}
+			// The sender must be funded with the amount, 1000000 units.
+			if overrides[token].StateDiff[balanceKey.Hex()] != "0x"+word("f4240") {
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted: transfer amount exceeds balance"}
+			}
+			if token == taxed {
+				// A 1% fee on transfer.
+				return "0x" + word("f1b30"), nil
+			}
+			return "0x" + word("f4240"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	for token, bps := range map[string]float64{taxed: 100, untaxed: 0} {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"transfer_tax"},
+			"token":  {token},
+			"slot":   {"3"},
+			"amount": {"1000000"},
+		})
+		if !result {
+			t.Fatalf("transfer_tax probe of %s failed unexpectedly", token)
+		}
+		got := gaugeValues(mfs, "probe_ethrpc_transfer_tax_bps", "tokenAddress")
+		if len(got) != 1 {
+			t.Fatalf("expected a single tax, got %v", got)
+		}
+		for _, v := range got {
+			if v != bps {
+				t.Errorf("expected a tax of %v bps for %s, got %v", bps, token, v)
+			}
+		}
+	}
+
+	if result, _ := probeETHRPC(t, ts.URL, url.Values{"module": {"transfer_tax"}, "token": {taxed}}); result {
+		t.Errorf("expected a missing slot to fail the probe")
+	}
+}
+
+func TestETHRPCERC20BalanceDecimals(t *testing.T) {
+	const (
+		usdc   = "0x1111111111111111111111111111111111111111"
+		legacy = "0x2222222222222222222222222222222222222222"
+		alice  = "0x3333333333333333333333333333333333333333"
+		bob    = "0x4444444444444444444444444444444444444444"
+	)
+	var decimalsCalls int
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			switch callSelector(params) {
+			case selector("decimals()"):
+				decimalsCalls++
+				if callTarget(params) == legacy {
+					return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+				}
+				return "0x" + word("6"), nil
+			case selector("balanceOf(address)"):
+				if callTarget(params) == legacy {
+					// 3 * 10^18
+					return "0x" + word("29a2241af62c0000"), nil
+				}
+				// 1 USDC
+				return "0x" + word("f4240"), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		token    string
+		decimals float64
+		balance  float64
+	}{
+		{usdc, 6, 1},
+		// Without decimals(), 18 is assumed.
+		{legacy, 18, 3},
+	}
+	for _, test := range tests {
+		decimalsCalls = 0
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":  {"erc20balance"},
+			"token":   {test.token},
+			"account": {"alice:" + alice, "bob:" + bob},
+		})
+		if !result {
+			t.Fatalf("%s: erc20balance probe failed unexpectedly", test.token)
+		}
+		if decimalsCalls != 1 {
+			t.Errorf("%s: expected decimals() to be called once, got %d", test.token, decimalsCalls)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_erc20_decimals", "tokenAddress"); got[test.token] != test.decimals {
+			t.Errorf("%s: expected %v decimals, got %v", test.token, test.decimals, got)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_erc20balance", "accountName"); got["alice"] != test.balance || got["bob"] != test.balance {
+			t.Errorf("%s: expected balances of %v, got %v", test.token, test.balance, got)
+		}
+	}
+}
+
+func TestETHRPCERC20BalanceMulticall(t *testing.T) {
+	const (
+		usdc  = "0x1111111111111111111111111111111111111111"
+		alice = "0x3333333333333333333333333333333333333333"
+		bob   = "0x4444444444444444444444444444444444444444"
+	)
+	multicallAddress := strings.ToLower(defaultMulticall3Address)
+	var ethCalls, decimalsCalls int
+	handleCall := func(to string, data []byte) ([]byte, bool) {
+		if to != usdc {
+			return nil, false
+		}
+		switch hexutil.Encode(data[:4]) {
+		case selector("decimals()"):
+			decimalsCalls++
+			return common.FromHex(word("6")), true
+		case selector("balanceOf(address)"):
+			// 1 USDC
+			return common.FromHex(word("f4240")), true
+		}
+		return nil, false
+	}
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			ethCalls++
+			var msg struct {
+				To   string `json:"to"`
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			if strings.ToLower(msg.To) == multicallAddress {
+				return answerMulticall3(t, msg.Data, handleCall), nil
+			}
+			if out, ok := handleCall(strings.ToLower(msg.To), common.FromHex(msg.Data)); ok {
+				return hexutil.Encode(out), nil
+			}
+			return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	// The first scrape reads the balances and decimals() in one aggregate3
+	// call, the next ones take the decimals from the cache.
+	for i, wantDecimalsCalls := range []int{1, 0} {
+		ethCalls, decimalsCalls = 0, 0
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module":    {"erc20balance"},
+			"token":     {usdc},
+			"account":   {"alice:" + alice, "bob:" + bob},
+			"multicall": {"true"},
+		})
+		if !result {
+			t.Fatalf("scrape %d: erc20balance probe failed unexpectedly", i)
+		}
+		if ethCalls != 1 {
+			t.Errorf("scrape %d: expected a single multicall, got %d eth_call", i, ethCalls)
+		}
+		if decimalsCalls != wantDecimalsCalls {
+			t.Errorf("scrape %d: expected %d decimals() calls, got %d", i, wantDecimalsCalls, decimalsCalls)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_erc20_decimals", "tokenAddress"); got[usdc] != 6 {
+			t.Errorf("scrape %d: expected 6 decimals, got %v", i, got)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_erc20balance", "accountName"); got["alice"] != 1 || got["bob"] != 1 {
+			t.Errorf("scrape %d: expected balances of 1, got %v", i, got)
+		}
+	}
+}
+
+func TestETHRPCLogs(t *testing.T) {
+	const heartbeat = "0x1111111111111111111111111111111111111111"
+	topic := crypto.Keccak256Hash([]byte("Heartbeat(uint256)")).Hex()
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_blockNumber":
+			return "0x1000", nil
+		case "eth_getLogs":
+			var criteria struct {
+				FromBlock string   `json:"fromBlock"`
+				ToBlock   string   `json:"toBlock"`
+				Topics    []string `json:"topics"`
+			}
+			json.Unmarshal(params[0], &criteria)
+			if len(criteria.Topics) != 1 || criteria.Topics[0] != topic {
+				t.Errorf("unexpected topics %v", criteria.Topics)
+			}
+			switch criteria.FromBlock + "-" + criteria.ToBlock {
+			case "0xf9d-0x1000", "0x64-0xc8":
+				return []map[string]string{
+					{"blockHash": "0x" + word("b1"), "blockNumber": "0xfa0", "transactionHash": "0x" + word("a1"), "logIndex": "0x0"},
+					{"blockHash": "0x" + word("b2"), "blockNumber": "0xff0", "transactionHash": "0x" + word("a2"), "logIndex": "0x1"},
+				}, nil
+			}
+			return nil, &jsonRPCTestError{Code: -32005, Message: "query returned more than 10000 results"}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		params  url.Values
+		success bool
+	}{
+		{"Lookback", url.Values{"lookback": {"100"}}, true},
+		{"Range", url.Values{"fromBlock": {"100"}, "toBlock": {"200"}}, true},
+		{"RangeTooLarge", url.Values{"fromBlock": {"0"}}, false},
+	}
+	for _, test := range tests {
+		test.params.Set("module", "logs")
+		test.params.Set("address", heartbeat)
+		test.params.Set("topic0", "Heartbeat(uint256)")
+		result, mfs := probeETHRPC(t, ts.URL, test.params)
+		if result != test.success {
+			t.Fatalf("%s: expected success %v, got %v", test.name, test.success, result)
+		}
+		counts := gaugeValues(mfs, "probe_ethrpc_log_count", "topic0")
+		codes := gaugeValues(mfs, "probe_jsonrpc_rpc_error_code", "method")
+		if test.success {
+			if counts["Heartbeat(uint256)"] != 2 {
+				t.Errorf("%s: expected 2 logs, got %v", test.name, counts)
+			}
+		} else if len(counts) != 0 || codes["eth_getLogs"] != -32005 {
+			t.Errorf("%s: expected no count and error code -32005, got %v and %v", test.name, counts, codes)
+		}
+	}
+}
+
+func TestETHRPCStorage(t *testing.T) {
+	const (
+		proxy = "0x1111111111111111111111111111111111111111"
+		impl  = "0x2222222222222222222222222222222222222222"
+	)
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getStorageAt":
+			var slot string
+			json.Unmarshal(params[1], &slot)
+			switch slot {
+			case eip1967ImplementationSlot.Hex():
+				return "0x" + word(impl[2:]), nil
+			case "0x" + word("5"):
+				return "0x" + word("1"), nil
+			}
+			return "0x" + word("0"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"storage"},
+		"slot": {
+			"paused:" + proxy + ":5",
+			"impl:" + proxy + ":" + eip1967ImplementationSlot.Hex(),
+		},
+	})
+	if !result {
+		t.Fatalf("storage probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_storage", "name"); got["paused"] != 1 || got["impl"] == 0 {
+		t.Errorf("unexpected storage values %v", got)
+	}
+	implementations := gaugeValues(mfs, "probe_ethrpc_storage", "implementation")
+	if len(implementations) != 2 {
+		t.Fatalf("expected an implementation label for the EIP-1967 slot only, got %v", implementations)
+	}
+	if _, ok := implementations[common.HexToAddress(impl).Hex()]; !ok {
+		t.Errorf("expected implementation %s, got %v", impl, implementations)
+	}
+}
+
+func TestETHRPCProxyImpl(t *testing.T) {
+	const (
+		proxy    = "0x1111111111111111111111111111111111111111"
+		notProxy = "0x3333333333333333333333333333333333333333"
+	)
+	impl := "0x2222222222222222222222222222222222222222"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_getStorageAt":
+			var address string
+			json.Unmarshal(params[0], &address)
+			if address == proxy {
+				return "0x" + word(impl[2:]), nil
+			}
+			return "0x" + word("0"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	probe := func() (bool, float64, map[string]float64) {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"proxy_impl"},
+			"proxy":  {"vault:" + proxy},
+		})
+		return result, gaugeValues(mfs, "probe_ethrpc_proxy_impl_hash", "proxyName")["vault"], gaugeValues(mfs, "probe_ethrpc_proxy_impl", "implementation")
+	}
+	result, hash, implementations := probe()
+	if !result {
+		t.Fatalf("proxy_impl probe failed unexpectedly")
+	}
+	if hash == 0 || hash > 1<<48 {
+		t.Errorf("expected a 48-bit hash, got %v", hash)
+	}
+	if implementations[common.HexToAddress(impl).Hex()] != 1 {
+		t.Errorf("expected implementation %s, got %v", impl, implementations)
+	}
+
+	// An upgrade changes the hash.
+	impl = "0x4444444444444444444444444444444444444444"
+	_, upgraded, _ := probe()
+	if upgraded == hash {
+		t.Errorf("expected the hash to change after an upgrade, got %v twice", hash)
+	}
+
+	result, _ = probeETHRPC(t, ts.URL, url.Values{
+		"module": {"proxy_impl"},
+		"proxy":  {"token:" + notProxy},
+	})
+	if result {
+		t.Errorf("expected a contract without implementation slot to fail the probe")
+	}
+}
+
+func TestETHRPCContractCallInputTypes(t *testing.T) {
+	const oracle = "0x1111111111111111111111111111111111111111"
+	const abiJSON = `[{"name":"quote","type":"function","inputs":[{"name":"amount","type":"uint256"},{"name":"inverse","type":"bool"},{"name":"key","type":"bytes32"},{"name":"pair","type":"string"}],"outputs":[{"name":"","type":"uint256"}]}]`
+	abiObj, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			var msg struct {
+				Data string `json:"data"`
+			}
+			json.Unmarshal(params[0], &msg)
+			args, err := abiObj.Methods["quote"].Inputs.Unpack(common.FromHex(msg.Data)[4:])
+			if err != nil {
+				return nil, err
+			}
+			key := args[2].([32]byte)
+			if !args[1].(bool) || key[0] != 0xab || args[3].(string) != "ETH,USD" {
+				t.Errorf("unexpected arguments %v", args)
+			}
+			// Twice the amount, as a uint256 with 18 decimals.
+			out := new(big.Int).Mul(args[0].(*big.Int), big.NewInt(2))
+			return "0x" + word(out.Text(16)), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	spec := "Oracle|" + oracle + "|" + abiJSON
+	result, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"contract_call"},
+		// One argument per field, so that the string can hold a comma.
+		"call": {spec + "|1500000000000000000|true|0xab|ETH,USD"},
+	})
+	if !result {
+		t.Fatalf("contract_call probe failed unexpectedly")
+	}
+	if got := gaugeValues(mfs, "probe_ethrpc_contract_call", "contractName"); got["Oracle"] != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+
+	for _, call := range []string{
+		spec + "|1500000000000000000|true|0xab",
+		spec + "|-1|true|0xab|ETH",
+		spec + "|1|yes|0xab|ETH",
+	} {
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"contract_call"},
+			"call":   {call},
+		})
+		if result {
+			t.Errorf("%s: expected invalid arguments to fail the probe", call)
+		}
+		if got := gaugeValues(mfs, "probe_ethrpc_contract_call_error", "contractName"); got["Oracle"] != 1 {
+			t.Errorf("%s: expected probe_ethrpc_contract_call_error 1, got %v", call, got)
+		}
+	}
+}
+
+func TestETHRPCContractCallGetReserves(t *testing.T) {
+	const pair = "0xb4e16d0168e52d35cacd2c6185b44281ec28c9dc"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			if callSelector(params) == selector("getReserves()") {
+				// 5000 and 2 with 18 decimals.
+				return "0x" + word("10f0cf064dd59200000") + word("1bc16d674ec80000") + word("65f0a0e0"), nil
+			}
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	tests := []struct {
+		names [3]string
+		want  map[string]float64
+	}{
+		{
+			[3]string{"reserve0", "reserve1", "blockTimestampLast"},
+			map[string]float64{"reserve0": 5000e18, "reserve1": 2e18, "blockTimestampLast": 1710268640},
+		},
+		// Unnamed outputs are labelled by their index.
+		{
+			[3]string{"", "", ""},
+			map[string]float64{"0": 5000e18, "1": 2e18, "2": 1710268640},
+		},
+	}
+	for _, test := range tests {
+		abiJSON := fmt.Sprintf(`[{"name":"getReserves","type":"function","inputs":[],"outputs":[{"name":%q,"type":"uint112"},{"name":%q,"type":"uint112"},{"name":%q,"type":"uint32"}]}]`, test.names[0], test.names[1], test.names[2])
+		result, mfs := probeETHRPC(t, ts.URL, url.Values{
+			"module": {"contract_call"},
+			"call":   {"Pair|" + pair + "|" + abiJSON},
+			"scale":  {"value"},
+		})
+		if !result {
+			t.Fatalf("%v: contract_call probe failed unexpectedly", test.names)
+		}
+		got := gaugeValues(mfs, "probe_ethrpc_contract_call", "outputName")
+		if len(got) != len(test.want) {
+			t.Errorf("%v: expected %d series, got %v", test.names, len(test.want), got)
+		}
+		for name, v := range test.want {
+			if math.Abs(got[name]-v) > 1e-9*v {
+				t.Errorf("%v: output %s: expected %v, got %v", test.names, name, v, got[name])
+			}
+		}
+	}
+}
+
+func TestETHRPCContractCallReverted(t *testing.T) {
+	const (
+		healthy  = "0x1111111111111111111111111111111111111111"
+		paused   = "0x2222222222222222222222222222222222222222"
+		overflow = "0x3333333333333333333333333333333333333333"
+	)
+	const abiJSON = `[{"name":"totalAssets","type":"function","inputs":[],"outputs":[{"name":"","type":"uint256"}]}]`
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0x1", nil
+		case "eth_call":
+			switch callTarget(params) {
+			case paused:
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted: Pausable: paused", Data: revertData(t, "Pausable: paused")}
+			case overflow:
+				return nil, &jsonRPCTestError{Code: 3, Message: "execution reverted", Data: "0x4e487b71" + word("11")}
+			}
+			return "0x" + word("2a"), nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	_, mfs := probeETHRPC(t, ts.URL, url.Values{
+		"module": {"contract_call"},
+		"call": {
+			"Healthy|" + healthy + "|" + abiJSON,
+			"Paused|" + paused + "|" + abiJSON,
+			"Overflow|" + overflow + "|" + abiJSON,
+		},
+	})
+	got := gaugeValues(mfs, "probe_ethrpc_contract_call_reverted", "contractName")
+	if len(got) != 3 || got["Healthy"] != 0 || got["Paused"] != 1 || got["Overflow"] != 1 {
+		t.Errorf("unexpected probe_ethrpc_contract_call_reverted %v", got)
+	}
+}
+
+func TestRevertReason(t *testing.T) {
+	tests := []struct {
+		data   string
+		reason string
+		ok     bool
+	}{
+		{revertData(t, "Pausable: paused"), "Pausable: paused", true},
+		{"0x4e487b71" + word("11"), "panic 0x11: arithmetic overflow or underflow", true},
+		{"0x4e487b71" + word("99"), "panic 0x99", true},
+		// A custom error, e.g. Unauthorized().
+		{"0x82b42900", "", false},
+		{"0x", "", false},
+	}
+	for _, test := range tests {
+		reason, ok := revertReason(common.FromHex(test.data))
+		if reason != test.reason || ok != test.ok {
+			t.Errorf("%s: expected %q %v, got %q %v", test.data, test.reason, test.ok, reason, ok)
+		}
+	}
+}
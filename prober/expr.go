@@ -0,0 +1,304 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+var exprKeywordReplacer = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\band\b`), "&&"},
+	{regexp.MustCompile(`(?i)\bor\b`), "||"},
+	{regexp.MustCompile(`(?i)\bnot\b`), "!"},
+}
+
+// exprPowerRE matches a number literal raised to a power, such as 2^96. Go gives
+// ^ the precedence of +, so powers are folded into literals before parsing
+// rather than evaluated as an operator.
+var exprPowerRE = regexp.MustCompile(`\b(\d+(?:\.\d+)?)\s*\^\s*(\d+)\b`)
+
+// parseExpr parses a small expression language used for probe assertions and
+// scaling formulas. It accepts Go expression syntax restricted to number and
+// boolean literals, variables, arithmetic, comparisons and logical operators;
+// AND, OR and NOT are accepted as aliases for &&, || and !, and powers of
+// number literals such as 2^96 can be used as fixed-point divisors. Variables
+// are either plain identifiers or name.field selectors, and every one of them
+// must be present in vars so that typos are reported before any RPC is made.
+func parseExpr(s string, vars map[string]bool) (ast.Expr, error) {
+	for _, kw := range exprKeywordReplacer {
+		s = kw.re.ReplaceAllString(s, kw.repl)
+	}
+	var powErr error
+	s = exprPowerRE.ReplaceAllStringFunc(s, func(m string) string {
+		lit, err := foldPower(exprPowerRE.FindStringSubmatch(m)[1:])
+		if err != nil {
+			powErr = err
+		}
+		return lit
+	})
+	if powErr != nil {
+		return nil, powErr
+	}
+	e, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	var walkErr error
+	ast.Inspect(e, func(n ast.Node) bool {
+		if walkErr != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.BinaryExpr:
+			switch n.Op {
+			case token.ADD, token.SUB, token.MUL, token.QUO,
+				token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ,
+				token.LAND, token.LOR:
+			default:
+				walkErr = fmt.Errorf("unsupported operator %s", n.Op)
+			}
+		case *ast.UnaryExpr:
+			if n.Op != token.NOT && n.Op != token.SUB && n.Op != token.ADD {
+				walkErr = fmt.Errorf("unsupported operator %s", n.Op)
+			}
+		case *ast.BasicLit:
+			if n.Kind != token.INT && n.Kind != token.FLOAT {
+				walkErr = fmt.Errorf("unsupported literal %s", n.Value)
+			}
+		case *ast.SelectorExpr:
+			name, ok := exprVarName(n)
+			if !ok {
+				walkErr = fmt.Errorf("unsupported selector expression")
+			} else if !vars[name] {
+				walkErr = fmt.Errorf("unknown variable %q", name)
+			}
+			return false
+		case *ast.Ident:
+			if n.Name != "true" && n.Name != "false" && !vars[n.Name] {
+				walkErr = fmt.Errorf("unknown variable %q", n.Name)
+			}
+		case *ast.ParenExpr, nil:
+		default:
+			walkErr = fmt.Errorf("unsupported expression %T", n)
+		}
+		return walkErr == nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return e, nil
+}
+
+// foldPower evaluates base^exp to a number literal. Integer bases are
+// computed exactly so that divisors like 2^96 keep every bit.
+func foldPower(m []string) (string, error) {
+	exp, err := strconv.Atoi(m[1])
+	if err != nil || exp > 256 {
+		return "", fmt.Errorf("unsupported exponent %s", m[1])
+	}
+	if base, ok := new(big.Int).SetString(m[0], 10); ok {
+		return new(big.Int).Exp(base, big.NewInt(int64(exp)), nil).String(), nil
+	}
+	base, err := strconv.ParseFloat(m[0], 64)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(math.Pow(base, float64(exp)), 'g', -1, 64), nil
+}
+
+func exprVarName(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name, true
+	case *ast.SelectorExpr:
+		x, ok := exprVarName(e.X)
+		if !ok {
+			return "", false
+		}
+		return x + "." + e.Sel.Name, true
+	}
+	return "", false
+}
+
+// evalBoolExpr evaluates an expression returned by parseExpr and requires it
+// to produce a boolean.
+func evalBoolExpr(e ast.Expr, vars map[string]float64) (bool, error) {
+	v, err := evalExpr(e, vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluates to %v, not a boolean", v)
+	}
+	return b, nil
+}
+
+// evalFloatExpr evaluates an expression returned by parseExpr and requires
+// it to produce a number.
+func evalFloatExpr(e ast.Expr, vars map[string]float64) (float64, error) {
+	v, err := evalExpr(e, vars)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression evaluates to %v, not a number", v)
+	}
+	return f, nil
+}
+
+// evalExpr evaluates an expression returned by parseExpr to either a float64
+// or a bool. Booleans compared with numbers are treated as 1 and 0, matching
+// how bool outputs are exported as gauges.
+func evalExpr(e ast.Expr, vars map[string]float64) (interface{}, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return evalExpr(e.X, vars)
+	case *ast.BasicLit:
+		if e.Kind == token.INT {
+			n, ok := new(big.Int).SetString(e.Value, 0)
+			if !ok {
+				return nil, fmt.Errorf("invalid number %s", e.Value)
+			}
+			f, _ := new(big.Float).SetInt(n).Float64()
+			return f, nil
+		}
+		return strconv.ParseFloat(e.Value, 64)
+	case *ast.Ident:
+		switch e.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return lookupExprVar(e, vars)
+	case *ast.SelectorExpr:
+		return lookupExprVar(e, vars)
+	case *ast.UnaryExpr:
+		x, err := evalExpr(e.X, vars)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == token.NOT {
+			b, ok := x.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operator ! not defined on %v", x)
+			}
+			return !b, nil
+		}
+		f, err := exprFloat(x)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == token.SUB {
+			return -f, nil
+		}
+		return f, nil
+	case *ast.BinaryExpr:
+		x, err := evalExpr(e.X, vars)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == token.LAND || e.Op == token.LOR {
+			xb, ok := x.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operator %s not defined on %v", e.Op, x)
+			}
+			if (e.Op == token.LAND && !xb) || (e.Op == token.LOR && xb) {
+				return xb, nil
+			}
+			y, err := evalExpr(e.Y, vars)
+			if err != nil {
+				return nil, err
+			}
+			yb, ok := y.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operator %s not defined on %v", e.Op, y)
+			}
+			return yb, nil
+		}
+		y, err := evalExpr(e.Y, vars)
+		if err != nil {
+			return nil, err
+		}
+		xf, err := exprFloat(x)
+		if err != nil {
+			return nil, err
+		}
+		yf, err := exprFloat(y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return xf + yf, nil
+		case token.SUB:
+			return xf - yf, nil
+		case token.MUL:
+			return xf * yf, nil
+		case token.QUO:
+			return xf / yf, nil
+		case token.EQL:
+			return xf == yf, nil
+		case token.NEQ:
+			return xf != yf, nil
+		case token.LSS:
+			return xf < yf, nil
+		case token.LEQ:
+			return xf <= yf, nil
+		case token.GTR:
+			return xf > yf, nil
+		case token.GEQ:
+			return xf >= yf, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", e.Op)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+func lookupExprVar(e ast.Expr, vars map[string]float64) (interface{}, error) {
+	name, ok := exprVarName(e)
+	if !ok {
+		return nil, fmt.Errorf("unsupported selector expression")
+	}
+	v, ok := vars[name]
+	if !ok {
+		return nil, fmt.Errorf("no value for %q", name)
+	}
+	return v, nil
+}
+
+func exprFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected value %v", v)
+}
@@ -19,14 +19,11 @@ import (
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/jmespath/go-jmespath"
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"io"
 	"net/http"
 	"net/url"
-	"reflect"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -47,6 +44,12 @@ func ProbeGraphQL(ctx context.Context, target string, params url.Values, module
 	)
 	registry.MustRegister(graphqlGaugeVec)
 
+	decimals, err := decimalsParam(params)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+
 	var hc = &http.Client{Timeout: 10 * time.Second}
 
 	for _, q := range params["query"] {
@@ -85,19 +88,14 @@ func ProbeGraphQL(ctx context.Context, target string, params url.Values, module
 			return false
 		}
 
-		result, err := jmespath.Search(jmespathString, data)
+		result, err := searchJMESPath(jmespathString, data, logger)
 		if err != nil {
 			level.Error(logger).Log("msg", "Error jmespath search "+err.Error(), "jsondata", data)
 			return false
 		}
-		var value float64
-		resultType := reflect.TypeOf(result).String()
-		if strings.Contains(resultType, "float") || strings.Contains(resultType, "int") {
-			value = result.(float64)
-		} else if strings.Contains(resultType, "string") {
-			value, err = strconv.ParseFloat(result.(string), 64)
-		} else {
-			level.Error(logger).Log("msg", "Make sure the value get from jmespath is not a number "+result.(string))
+		value, err := resultToFloat64WithDecimals(result, decimals)
+		if err != nil {
+			level.Error(logger).Log("msg", "Make sure the value get from jmespath is a number, "+err.Error())
 			return false
 		}
 		graphqlGaugeVec.WithLabelValues(target, jmespathString).Set(value)
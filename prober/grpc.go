@@ -97,6 +97,11 @@ func ProbeGRPC(ctx context.Context, target string, params url.Values, module con
 			Help: "Response HealthCheck response",
 		}, []string{"serving_status"})
 
+		healthyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_grpc_healthy",
+			Help: "1 if the service reports SERVING, 0 otherwise",
+		})
+
 		probeSSLEarliestCertExpiryGauge = prometheus.NewGauge(sslEarliestCertExpiryGaugeOpts)
 
 		probeTLSVersion = prometheus.NewGaugeVec(
@@ -121,10 +126,22 @@ func ProbeGRPC(ctx context.Context, target string, params url.Values, module con
 	registry.MustRegister(isSSLGauge)
 	registry.MustRegister(statusCodeGauge)
 	registry.MustRegister(healthCheckResponseGaugeVec)
+	registry.MustRegister(healthyGauge)
 	registry.MustRegister(probeSSLEarliestCertExpiryGauge)
 	registry.MustRegister(probeTLSVersion)
 	registry.MustRegister(probeSSLLastInformation)
 
+	// The service and tls params override the module's settings, so that
+	// one module serves all the services of a fleet.
+	service := module.GRPC.Service
+	if params.Has("service") {
+		service = params.Get("service")
+	}
+	useTLS := module.GRPC.TLS
+	if t := params.Get("tls"); t != "" {
+		useTLS = t == "true"
+	}
+
 	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
 		target = "http://" + target
 	}
@@ -168,7 +185,7 @@ func ProbeGRPC(ctx context.Context, target string, params url.Values, module con
 
 	var opts []grpc.DialOption
 	target = targetHost + ":" + targetPort
-	if !module.GRPC.TLS {
+	if !useTLS {
 		level.Debug(logger).Log("msg", "Dialing GRPC without TLS")
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 		if len(targetPort) == 0 {
@@ -185,12 +202,13 @@ func ProbeGRPC(ctx context.Context, target string, params url.Values, module con
 	conn, err := grpc.Dial(target, opts...)
 
 	if err != nil {
-		level.Error(logger).Log("did not connect: %v", err)
+		level.Error(logger).Log("msg", "did not connect", "err", err)
+		return false
 	}
 
 	client := NewGrpcHealthCheckClient(conn)
 	defer conn.Close()
-	ok, statusCode, serverPeer, servingStatus, err := client.Check(context.Background(), module.GRPC.Service)
+	ok, statusCode, serverPeer, servingStatus, err := client.Check(ctx, service)
 	durationGaugeVec.WithLabelValues("check").Add(time.Since(checkStart).Seconds())
 
 	for servingStatusName, _ := range grpc_health_v1.HealthCheckResponse_ServingStatus_value {
@@ -199,6 +217,9 @@ func ProbeGRPC(ctx context.Context, target string, params url.Values, module con
 	if servingStatus != "" {
 		healthCheckResponseGaugeVec.WithLabelValues(servingStatus).Set(float64(1))
 	}
+	if ok {
+		healthyGauge.Set(1)
+	}
 
 	if serverPeer != nil {
 		tlsInfo, tlsOk := serverPeer.AuthInfo.(credentials.TLSInfo)
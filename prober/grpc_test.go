@@ -166,6 +166,63 @@ func TestMultipleGRPCservices(t *testing.T) {
 	}
 }
 
+func TestGRPCServiceParam(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Error listening on socket: %s", err)
+	}
+	defer ln.Close()
+
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Error retrieving port for socket: %s", err)
+	}
+	s := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("service1", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("service2", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			t.Errorf("failed to serve: %v", err)
+			return
+		}
+	}()
+	defer s.GracefulStop()
+
+	tests := []struct {
+		params  url.Values
+		healthy float64
+	}{
+		// The module's service.
+		{url.Values{}, 0},
+		{url.Values{"service": {"service1"}}, 1},
+		{url.Values{"service": {"service2"}, "tls": {"false"}}, 0},
+	}
+	for _, test := range tests {
+		testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		registry := prometheus.NewRegistry()
+		result := ProbeGRPC(testCTX, "localhost:"+port, test.params,
+			config.Module{Timeout: time.Second, GRPC: config.GRPCProbe{
+				IPProtocolFallback: false,
+				Service:            "service2",
+			},
+			}, registry, log.NewNopLogger())
+		cancel()
+
+		if result != (test.healthy == 1) {
+			t.Errorf("params %v: unexpected result %v", test.params, result)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRegistryResults(map[string]float64{"probe_grpc_healthy": test.healthy}, mfs, t)
+	}
+}
+
 func TestGRPCTLSConnection(t *testing.T) {
 
 	certExpiry := time.Now().AddDate(0, 0, 1)
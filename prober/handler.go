@@ -16,6 +16,7 @@ package prober
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/textproto"
@@ -28,21 +29,29 @@ import (
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"gopkg.in/yaml.v2"
 )
 
 var (
 	Probers = map[string]ProbeFn{
-		"http":    ProbeHTTP,
-		"tcp":     ProbeTCP,
-		"icmp":    ProbeICMP,
-		"dns":     ProbeDNS,
-		"grpc":    ProbeGRPC,
-		"ethrpc":  ProbeETHRPC,
-		"btcrpc":  ProbeBTCRPC,
-		"json":    ProbeJSON,
-		"graphql": ProbeGraphQL,
+		"http":       ProbeHTTP,
+		"tcp":        ProbeTCP,
+		"icmp":       ProbeICMP,
+		"dns":        ProbeDNS,
+		"grpc":       ProbeGRPC,
+		"ethrpc":     ProbeETHRPC,
+		"btcrpc":     ProbeBTCRPC,
+		"json":       ProbeJSON,
+		"graphql":    ProbeGraphQL,
+		"multichain": ProbeMultichain,
+		"solana":     ProbeSolana,
+		"cosmos":     ProbeCosmos,
+		"sui":        ProbeSui,
+		"consensus":  ProbeConsensus,
+		"aptos":      ProbeAptos,
+		"tron":       ProbeTron,
 	}
 )
 
@@ -113,6 +122,23 @@ func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger lo
 		}
 	}
 
+	// In validate mode the params are only checked, the target is not
+	// probed.
+	if params.Get("validate") == "true" {
+		report := validationReport{Module: moduleName, Prober: module.Prober, Target: target}
+		if validate, ok := paramValidators[module.Prober]; ok {
+			report.Checked = true
+			report.Errors = validate(params, module)
+		}
+		report.Valid = len(report.Errors) == 0
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Valid {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
 	sl := newScrapeLogger(logger, moduleName, target, logLevelProber)
 	level.Info(sl).Log("msg", "Beginning probe", "probe", module.Prober, "timeout_seconds", timeoutSeconds)
 
@@ -120,7 +146,16 @@ func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger lo
 	registry := prometheus.NewRegistry()
 	registry.MustRegister(probeSuccessGauge)
 	registry.MustRegister(probeDurationGauge)
-	success := prober(ctx, target, params, module, registry, sl)
+	// With a metric_prefix the prober registers into a registry of its own,
+	// whose metrics are renamed when gathered.
+	proberRegistry := registry
+	var gatherer prometheus.Gatherer = registry
+	if module.MetricPrefix != "" {
+		proberRegistry = prometheus.NewRegistry()
+		gatherer = prometheus.Gatherers{registry, prefixGatherer{prefix: module.MetricPrefix, next: proberRegistry}}
+	}
+	trace := &CallTrace{}
+	success := prober(contextWithCallTrace(ctx, trace), target, params, module, proberRegistry, sl)
 	duration := time.Since(start).Seconds()
 	probeDurationGauge.Set(duration)
 	if success {
@@ -130,7 +165,7 @@ func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger lo
 		level.Error(sl).Log("msg", "Probe failed", "duration_seconds", duration)
 	}
 
-	debugOutput := DebugOutput(&module, &sl.buffer, registry)
+	debugOutput := DebugOutput(&module, &sl.buffer, gatherer, trace)
 	rh.Add(moduleName, target, debugOutput, success)
 
 	if r.URL.Query().Get("debug") == "true" {
@@ -139,10 +174,26 @@ func Handler(w http.ResponseWriter, r *http.Request, c *config.Config, logger lo
 		return
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
 	h.ServeHTTP(w, r)
 }
 
+// prefixGatherer prepends prefix to the names of the metric families
+// gathered from next.
+type prefixGatherer struct {
+	prefix string
+	next   prometheus.Gatherer
+}
+
+func (g prefixGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.next.Gather()
+	for _, mf := range mfs {
+		name := g.prefix + mf.GetName()
+		mf.Name = &name
+	}
+	return mfs, err
+}
+
 func setHTTPHost(hostname string, module *config.Module) error {
 	// By creating a new hashmap and copying values there we
 	// ensure that the initial configuration remain intact.
@@ -185,19 +236,27 @@ func (sl scrapeLogger) Log(keyvals ...interface{}) error {
 	return level.NewFilter(sl.next, sl.logLevel).Log(keyvals...)
 }
 
-// DebugOutput returns plaintext debug output for a probe.
-func DebugOutput(module *config.Module, logBuffer *bytes.Buffer, registry *prometheus.Registry) string {
+// DebugOutput returns plaintext debug output for a probe. calls may be nil.
+func DebugOutput(module *config.Module, logBuffer *bytes.Buffer, registry prometheus.Gatherer, calls *CallTrace) string {
 	buf := &bytes.Buffer{}
 	fmt.Fprintf(buf, "Logs for the probe:\n")
-	logBuffer.WriteTo(buf)
+	buf.WriteString(calls.hide(logBuffer.String()))
+	if !calls.empty() {
+		fmt.Fprintf(buf, "\n\n\nCalls made by the probe:\n")
+		calls.WriteTo(buf)
+	}
 	fmt.Fprintf(buf, "\n\n\nMetrics that would have been returned:\n")
 	mfs, err := registry.Gather()
 	if err != nil {
 		fmt.Fprintf(buf, "Error gathering metrics: %s\n", err)
 	}
+	// Labels may hold secrets too, e.g. the rpc label the target with its
+	// credentials.
+	metrics := &bytes.Buffer{}
 	for _, mf := range mfs {
-		expfmt.MetricFamilyToText(buf, mf)
+		expfmt.MetricFamilyToText(metrics, mf)
 	}
+	buf.WriteString(calls.hide(metrics.String()))
 	fmt.Fprintf(buf, "\n\n\nModule configuration:\n")
 	c, err := yaml.Marshal(module)
 	if err != nil {
@@ -16,15 +16,17 @@ package prober
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/jmespath/go-jmespath"
 	"github.com/prometheus/blackbox_exporter/config"
 	"github.com/prometheus/client_golang/prometheus"
 	"io"
+	"math"
+	"math/big"
 	"net/http"
 	"net/url"
-	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -38,11 +40,16 @@ func ProbeJSON(ctx context.Context, target string, params url.Values, module con
 		jsonJmespathGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "probe_json_jmespath",
 			Help: "",
-		}, []string{"target", "jmespath"})
+		}, []string{"target", "jmespath", "index"})
 	)
 	registry.MustRegister(jsonJmespathGaugeVec)
 
 	jmespathString := params.Get("jmespath")
+	decimals, err := decimalsParam(params)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
 
 	var hc = &http.Client{Timeout: 10 * time.Second}
 
@@ -69,21 +76,145 @@ func ProbeJSON(ctx context.Context, target string, params url.Values, module con
 		return false
 	}
 
-	result, err := jmespath.Search(jmespathString, data)
+	result, err := searchJMESPath(jmespathString, data, logger)
 	if err != nil {
 		level.Error(logger).Log("msg", "Error jmespath search "+err.Error(), "jsondata", data)
 		return false
 	}
-	var value float64
-	resultType := reflect.TypeOf(result).String()
-	if strings.Contains(resultType, "float") || strings.Contains(resultType, "int") {
-		value = result.(float64)
-	} else if strings.Contains(resultType, "string") {
-		value, err = strconv.ParseFloat(result.(string), 64)
-	} else {
-		level.Error(logger).Log("msg", "Make sure the value get from jmespath is not a number "+result.(string))
+	// Arrays yield one series per element, labelled with its index.
+	switch r := result.(type) {
+	case []interface{}:
+		success = true
+		for i, e := range r {
+			value, err := resultToFloat64WithDecimals(e, decimals)
+			if err != nil {
+				level.Error(logger).Log("msg", "Make sure the values get from jmespath are numbers, "+err.Error(), "index", i)
+				success = false
+				continue
+			}
+			jsonJmespathGaugeVec.WithLabelValues(target, jmespathString, strconv.Itoa(i)).Set(value)
+		}
+		return success
+	case map[string]interface{}:
+		level.Warn(logger).Log("msg", "jmespath result is an object and cannot be reduced to a number, select one of its fields", "jmespath", jmespathString)
+		return false
+	}
+	value, err := resultToFloat64WithDecimals(result, decimals)
+	if err != nil {
+		level.Error(logger).Log("msg", "Make sure the value get from jmespath is a number, "+err.Error())
 		return false
 	}
-	jsonJmespathGaugeVec.WithLabelValues(target, jmespathString).Set(value)
+	jsonJmespathGaugeVec.WithLabelValues(target, jmespathString, "").Set(value)
 	return true
 }
+
+// searchJMESPath evaluates a comma separated list of JMESPath expressions in
+// order and returns the first non-null result, so that one probe works
+// across providers naming a field differently. Commas nested in brackets,
+// braces, parentheses or quotes belong to the expression.
+func searchJMESPath(expressions string, data interface{}, logger log.Logger) (interface{}, error) {
+	for _, expression := range splitJMESPathList(expressions) {
+		result, err := jmespath.Search(expression, data)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			level.Debug(logger).Log("msg", "jmespath expression matched", "jmespath", expression)
+			return result, nil
+		}
+	}
+	return nil, fmt.Errorf("no expression in %q matched", expressions)
+}
+
+func splitJMESPathList(s string) []string {
+	var (
+		parts []string
+		depth int
+		quote rune
+		start int
+	)
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '[' || r == '{' || r == '(':
+			depth++
+		case r == ']' || r == '}' || r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// decimalsParam returns the decimals param, 0 if unset.
+func decimalsParam(params url.Values) (int, error) {
+	d := params.Get("decimals")
+	if d == "" {
+		return 0, nil
+	}
+	decimals, err := strconv.Atoi(d)
+	if err != nil || decimals < 0 {
+		return 0, fmt.Errorf("decimals '%s' is not valid", d)
+	}
+	return decimals, nil
+}
+
+// resultToFloat64WithDecimals converts a JMESPath result, a JSON number, a
+// json.Number or a numeric string, to a float64 divided by 10^decimals. Strings may be
+// decimal or 0x prefixed hex, as returned by JSON-RPC APIs.
+//
+// Numeric strings are parsed and divided by 10^decimals as big.Int and
+// big.Float, and only the final value is rounded to a float64. Raw amounts
+// far above 2^53, such as wei balances, therefore only lose what float64
+// cannot represent: the result keeps about 15 to 17 significant digits, so
+// a balance of 123456.789012345678901234 ether is exported as
+// 123456.78901234568. JSON numbers are already float64 once decoded, large
+// amounts must be returned as strings to benefit from this.
+func resultToFloat64WithDecimals(result interface{}, decimals int) (float64, error) {
+	var n *big.Float
+	switch v := result.(type) {
+	case float64:
+		n = new(big.Float).SetPrec(256).SetFloat64(v)
+	case json.Number:
+		return resultToFloat64WithDecimals(string(v), decimals)
+	case string:
+		s := strings.TrimSpace(v)
+		digits := strings.TrimPrefix(s, "-")
+		if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+			i, ok := new(big.Int).SetString(digits[2:], 16)
+			if !ok {
+				return 0, fmt.Errorf("%q is not a valid hex number", v)
+			}
+			if len(digits) != len(s) {
+				i.Neg(i)
+			}
+			n = new(big.Float).SetPrec(256).SetInt(i)
+		} else if f, ok := new(big.Float).SetPrec(256).SetString(s); ok {
+			n = f
+		} else {
+			// Also covers NaN and Inf, which big.Float does not represent.
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return 0, fmt.Errorf("%q is not a number", v)
+			}
+			if decimals > 0 {
+				return f / math.Pow10(decimals), nil
+			}
+			return f, nil
+		}
+	default:
+		return 0, fmt.Errorf("%v is a %T, not a number", result, result)
+	}
+	if decimals > 0 {
+		n.Quo(n, new(big.Float).SetInt(pow10(decimals)))
+	}
+	f, _ := n.Float64()
+	return f, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestJSONJMESPathFallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"price": "12.5", "sizes": [1, 3, 2]}}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		jmespath string
+		success  bool
+		value    float64
+	}{
+		{"data.price", true, 12.5},
+		// The first expression misses, the second matches.
+		{"result.price, data.price", true, 12.5},
+		// Commas inside an expression do not split it.
+		{"result.size, max(data.sizes[0:3])", true, 3},
+		{"result.price, result.value", false, 0},
+	}
+	for _, test := range tests {
+		registry := prometheus.NewRegistry()
+		testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result := ProbeJSON(testCTX, ts.URL, url.Values{"jmespath": {test.jmespath}}, config.Module{Timeout: time.Second}, registry, log.NewNopLogger())
+		cancel()
+		if result != test.success {
+			t.Errorf("jmespath %q: expected success %v, got %v", test.jmespath, test.success, result)
+			continue
+		}
+		if !test.success {
+			continue
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gaugeValues(mfs, "probe_json_jmespath", "jmespath")[test.jmespath]; got != test.value {
+			t.Errorf("jmespath %q: expected %v, got %v", test.jmespath, test.value, got)
+		}
+	}
+}
+
+func TestJSONJMESPathArray(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"price": {"usd": "12.5"}, "sizes": [1, "0x3", 2], "names": [1, "a"]}}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		jmespath string
+		success  bool
+		values   map[string]float64
+	}{
+		{"data.sizes", true, map[string]float64{"0": 1, "1": 3, "2": 2}},
+		// Elements that are not numbers are skipped.
+		{"data.names", false, map[string]float64{"0": 1}},
+		// An object cannot be reduced to a number.
+		{"data.price", false, map[string]float64{}},
+		// Scalars are exported without an index.
+		{"data.price.usd", true, map[string]float64{"": 12.5}},
+	}
+	for _, test := range tests {
+		registry := prometheus.NewRegistry()
+		testCTX, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		result := ProbeJSON(testCTX, ts.URL, url.Values{"jmespath": {test.jmespath}}, config.Module{Timeout: time.Second}, registry, log.NewNopLogger())
+		cancel()
+		if result != test.success {
+			t.Errorf("jmespath %q: expected success %v, got %v", test.jmespath, test.success, result)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := gaugeValues(mfs, "probe_json_jmespath", "index")
+		if len(got) != len(test.values) {
+			t.Errorf("jmespath %q: expected %v, got %v", test.jmespath, test.values, got)
+			continue
+		}
+		for index, want := range test.values {
+			if got[index] != want {
+				t.Errorf("jmespath %q: expected %v at index %q, got %v", test.jmespath, want, index, got[index])
+			}
+		}
+	}
+}
+
+func TestResultToFloat64WithDecimals(t *testing.T) {
+	tests := []struct {
+		result   interface{}
+		decimals int
+		want     float64
+		err      bool
+	}{
+		{result: float64(12), want: 12},
+		{result: "12.5", want: 12.5},
+		{result: "1e3", want: 1000},
+		{result: "0x1234", want: 4660},
+		{result: "0X1234", want: 4660},
+		{result: "-42", want: -42},
+		{result: "-0x10", want: -16},
+		{result: json.Number("2500"), decimals: 3, want: 2.5},
+		{result: float64(-1.5), decimals: 1, want: -0.15},
+		// 1.5 ether in wei, decimal and hex.
+		{result: "1500000000000000000", decimals: 18, want: 1.5},
+		{result: "0x14d1120d7b160000", decimals: 18, want: 1.5},
+		// Above 2^53, scaled before the conversion to float64.
+		{result: "123456789012345678901234", decimals: 18, want: 123456.789012345678901234},
+		{result: "0x1a249b1f10a06c96aff2", decimals: 18, want: 123456.789012345678901234},
+		{result: "9007199254740993", want: 9007199254740992},
+		{result: "NaN", want: math.NaN()},
+		{result: "0xzz", err: true},
+		{result: "abc", err: true},
+		{result: true, err: true},
+	}
+	for _, test := range tests {
+		got, err := resultToFloat64WithDecimals(test.result, test.decimals)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %v", test.result, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %s", test.result, err)
+			continue
+		}
+		if math.IsNaN(test.want) {
+			if !math.IsNaN(got) {
+				t.Errorf("%v: expected NaN, got %v", test.result, got)
+			}
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v with %d decimals: expected %v, got %v", test.result, test.decimals, test.want, got)
+		}
+	}
+}
+
+func TestResultToFloat64WithDecimalsWhaleBalance(t *testing.T) {
+	// 24 digits of wei, well above 2^53.
+	const wei = "987654321987654321987654"
+	got, err := resultToFloat64WithDecimals(wei, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 987654.321987654321987654
+	if math.Abs(got-want)/want > 1e-15 {
+		t.Errorf("expected %s wei to scale to about %v ether, got %v", wei, want, got)
+	}
+	// Scaling the float64 of the raw amount is not guaranteed to round as
+	// well, the result must be at least as close.
+	naive, _ := strconv.ParseFloat(wei, 64)
+	naive /= 1e18
+	if math.Abs(got-want) > math.Abs(naive-want) {
+		t.Errorf("expected big.Float scaling to be at least as precise as float64, got %v, float64 gives %v", got, naive)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// defaultMulticall3Address is where Multicall3 is deployed on most chains.
+const defaultMulticall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+const multicall3ABI = `[{"name":"aggregate3","type":"function","stateMutability":"payable","inputs":[{"name":"calls","type":"tuple[]","components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}]}],"outputs":[{"name":"returnData","type":"tuple[]","components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}]`
+
+var errMulticallReverted = errors.New("call reverted in multicall")
+
+// multicallRevertError is the error of a call that reverted in a multicall.
+// It carries the revert data like the errors of reverted eth_calls do.
+type multicallRevertError struct {
+	data []byte
+}
+
+func (e *multicallRevertError) Error() string { return errMulticallReverted.Error() }
+
+func (e *multicallRevertError) Is(target error) bool { return target == errMulticallReverted }
+
+// ErrorData implements rpc.DataError.
+func (e *multicallRevertError) ErrorData() interface{} { return hexutil.Encode(e.data) }
+
+type multicallCall struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// multicall3 runs calls in a single eth_call to Multicall3's aggregate3 at
+// address. Individual calls may fail without failing the others. An error
+// is returned when the aggregate call itself fails or its result cannot be
+// decoded, which is also what happens on chains without Multicall3.
+func multicall3(ctx context.Context, c *rpc.Client, address string, calls []multicallCall, block string) ([]multicallResult, error) {
+	abiObj, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		return nil, err
+	}
+	callData, err := abiObj.Pack("aggregate3", calls)
+	if err != nil {
+		return nil, err
+	}
+	elem := newEthCallElem(address, callData, block)
+	if err := c.CallContext(ctx, elem.Result, elem.Method, elem.Args...); err != nil {
+		return nil, err
+	}
+	out, err := abiObj.Unpack("aggregate3", common.FromHex(*elem.Result.(*string)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding aggregate3 result: %w", err)
+	}
+	results := *abi.ConvertType(out[0], new([]multicallResult)).(*[]multicallResult)
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("aggregate3 returned %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
+
+// fillBatchFromMulticall stores multicall results into eth_call batch
+// elements created by newEthCallElem, as if the batch had been sent.
+func fillBatchFromMulticall(batch []rpc.BatchElem, results []multicallResult) {
+	for i, r := range results {
+		if !r.Success {
+			batch[i].Error = &multicallRevertError{data: r.ReturnData}
+			continue
+		}
+		*batch[i].Result.(*string) = hexutil.Encode(r.ReturnData)
+	}
+}
+
+// callViaMulticall sends an eth_call batch as a single aggregate3 call to
+// Multicall3 at address, or the default deployment if empty, so that all
+// calls read the same block, the batch's block. It falls back to sending the batch itself where
+// that fails, e.g. on chains without Multicall3. calls must hold the calls
+// of batch, in the same order.
+func callViaMulticall(ctx context.Context, c *rpc.Client, address string, batch []rpc.BatchElem, calls []multicallCall, block string, logger log.Logger) error {
+	if address == "" {
+		address = defaultMulticall3Address
+	}
+	if len(calls) > 0 {
+		results, err := multicall3(ctx, c, address, calls, block)
+		if err == nil {
+			fillBatchFromMulticall(batch, results)
+			return nil
+		}
+		level.Warn(logger).Log("msg", "multicall failed, falling back to individual calls, "+err.Error(), "multicallAddress", address)
+	}
+	return c.BatchCallContext(ctx, batch)
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+var errNoBlock = errors.New("latest block not found")
+
+// ProbeMultichain reads the head of several Ethereum JSON-RPC endpoints,
+// given as chain=name:url params, in parallel. A failing chain is reported
+// by probe_multichain_up without affecting the others. The module's ethrpc
+// settings apply to every endpoint; the target is not used.
+func ProbeMultichain(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		upGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_multichain_up",
+			Help: "Whether the head of the chain could be read",
+		}, []string{"chain"})
+		blockNumberGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_multichain_block_number",
+			Help: "Number of the latest block of the chain",
+		}, []string{"chain"})
+		blockAgeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_multichain_block_age_seconds",
+			Help: "Seconds since the timestamp of the latest block of the chain",
+		}, []string{"chain"})
+	)
+	registry.MustRegister(upGaugeVec)
+	registry.MustRegister(blockNumberGaugeVec)
+	registry.MustRegister(blockAgeGaugeVec)
+
+	chains := params["chain"]
+	if len(chains) == 0 {
+		level.Error(logger).Log("msg", "no chains specified! format: chainName:url")
+		return false
+	}
+	headers, err := ethRPCHeaders(params, module)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	success = true
+	for _, c := range chains {
+		name, endpoint, ok := strings.Cut(c, ":")
+		if !ok || name == "" || endpoint == "" {
+			level.Error(logger).Log("msg", "chain params format is invalid, SKIP! valid format: chainName:url", "chain", c)
+			success = false
+			continue
+		}
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			head, err := readChainHead(ctx, endpoint, headers, module)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				level.Error(logger).Log("msg", "reading chain head failed, "+err.Error(), "chain", name)
+				upGaugeVec.WithLabelValues(name).Set(0)
+				success = false
+				return
+			}
+			upGaugeVec.WithLabelValues(name).Set(1)
+			blockNumberGaugeVec.WithLabelValues(name).Set(float64(head.Number.ToInt().Uint64()))
+			blockAgeGaugeVec.WithLabelValues(name).Set(time.Since(time.Unix(int64(head.Timestamp), 0)).Seconds())
+		}()
+	}
+	wg.Wait()
+	return success
+}
+
+func readChainHead(ctx context.Context, endpoint string, headers http.Header, module config.Module) (*rpcBlockHeader, error) {
+	eth, _, err := dialETHRPC(ctx, endpoint, headers, module)
+	if err != nil {
+		return nil, err
+	}
+	defer eth.Close()
+	var head rpcBlockHeader
+	if err := eth.Client().CallContext(ctx, &head, "eth_getBlockByNumber", "latest", false); err != nil {
+		return nil, err
+	}
+	if head.Number == nil {
+		return nil, errNoBlock
+	}
+	return &head, nil
+}
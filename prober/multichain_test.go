@@ -0,0 +1,81 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func newChainHeadTestServer(t *testing.T, number uint64, timestamp time.Time) string {
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		if method == "eth_getBlockByNumber" {
+			return map[string]string{
+				"number":    hexutil.EncodeUint64(number),
+				"timestamp": hexutil.EncodeUint64(uint64(timestamp.Unix())),
+			}, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestMultichainHeads(t *testing.T) {
+	now := time.Now()
+	mainnet := newChainHeadTestServer(t, 19000000, now.Add(-12*time.Second))
+	arbitrum := newChainHeadTestServer(t, 180000000, now.Add(-300*time.Second))
+	// A closed server makes the chain unreachable.
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	down := ts.URL
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := ProbeMultichain(ctx, "fleet", url.Values{
+		"chain": {"mainnet:" + mainnet, "arbitrum:" + arbitrum, "down:" + down},
+	}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger())
+	if result {
+		t.Errorf("expected an unreachable chain to fail the probe")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := gaugeValues(mfs, "probe_multichain_up", "chain")
+	if up["mainnet"] != 1 || up["arbitrum"] != 1 || up["down"] != 0 {
+		t.Errorf("unexpected probe_multichain_up %v", up)
+	}
+	numbers := gaugeValues(mfs, "probe_multichain_block_number", "chain")
+	if len(numbers) != 2 || numbers["mainnet"] != 19000000 || numbers["arbitrum"] != 180000000 {
+		t.Errorf("unexpected block numbers %v", numbers)
+	}
+	ages := gaugeValues(mfs, "probe_multichain_block_age_seconds", "chain")
+	if ages["mainnet"] < 12 || ages["mainnet"] > 20 || ages["arbitrum"] < 300 || ages["arbitrum"] > 310 {
+		t.Errorf("unexpected block ages %v", ages)
+	}
+}
@@ -0,0 +1,519 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	pconfig "github.com/prometheus/common/config"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// JSON-RPC failures are classified, for probe_jsonrpc_error, as:
+//   - dial: the client could not be set up or connect.
+//   - transport: the request failed on the way, e.g. with an HTTP 429.
+//   - rpc_error: the endpoint answered with a JSON-RPC error object.
+//   - decode: the result could not be decoded.
+//   - rate_limited_local: the exporter's own rate limit held the request
+//     back past the probe deadline.
+const (
+	rpcErrorDial             = "dial"
+	rpcErrorTransport        = "transport"
+	rpcErrorRPC              = "rpc_error"
+	rpcErrorDecode           = "decode"
+	rpcErrorRateLimitedLocal = "rate_limited_local"
+)
+
+// classifyRPCError returns the type of a failed call, dial failures aside.
+func classifyRPCError(err error) string {
+	var (
+		rpcErr    rpc.Error
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.Is(err, errRateLimitedLocal):
+		return rpcErrorRateLimitedLocal
+	case errors.As(err, &rpcErr):
+		return rpcErrorRPC
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return rpcErrorDecode
+	}
+	return rpcErrorTransport
+}
+
+// rpcErrorCode returns the code of a JSON-RPC error object, and false for
+// failures that are not one.
+func rpcErrorCode(err error) (int, bool) {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return 0, false
+	}
+	return rpcErr.ErrorCode(), true
+}
+
+// errResponseBytesLimit is returned when the responses of a probe exceed
+// ethrpc.response_bytes_limit.
+var errResponseBytesLimit = errors.New("probe exceeded response_bytes_limit")
+
+// probeTransport accounts for the HTTP requests of a single probe: it counts
+// the JSON-RPC requests sent through it the way RPC providers bill them, and
+// the response bytes read, optionally aborting once a limit is reached.
+type probeTransport struct {
+	next               http.RoundTripper
+	perRequest         bool
+	responseBytesLimit int64
+	// cached is set when next was reused from an earlier probe.
+	cached bool
+	// limiter, if set, holds requests back to the target's rate limit.
+	limiter *tokenBucket
+	// trace, if set, records the requests and responses for the debug
+	// output.
+	trace *CallTrace
+
+	mu            sync.Mutex
+	count         int
+	responseBytes int64
+	tlsState      *tls.ConnectionState
+	retryAfter    time.Duration
+}
+
+func (t *probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		body     []byte
+		haveBody bool
+	)
+	if req.GetBody != nil && (!t.perRequest || t.trace != nil) {
+		if rc, err := req.GetBody(); err == nil {
+			body, err = io.ReadAll(rc)
+			rc.Close()
+			haveBody = err == nil
+		}
+	}
+	n := 1
+	if !t.perRequest && haveBody {
+		n = countJSONRPCMethods(bytes.NewReader(body))
+	}
+	traceIndex := -1
+	if t.trace != nil {
+		traceIndex = t.trace.addRequest(req.Method+" "+req.URL.Redacted(), string(body))
+	}
+	if t.limiter != nil {
+		if err := t.limiter.wait(req.Context(), n); err != nil {
+			return nil, err
+		}
+	}
+	t.mu.Lock()
+	t.count += n
+	t.mu.Unlock()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	if resp.TLS != nil && t.tlsState == nil {
+		t.tlsState = resp.TLS
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		t.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	t.mu.Unlock()
+	resp.Body = &countingBody{ReadCloser: resp.Body, t: t, traceIndex: traceIndex}
+	return resp, nil
+}
+
+// Count returns the number of billable requests.
+func (t *probeTransport) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// ResponseBytes returns the number of response bytes read.
+func (t *probeTransport) ResponseBytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.responseBytes
+}
+
+// TLSState returns the state of the first TLS connection a response was
+// read from, or nil if there was none.
+func (t *probeTransport) TLSState() *tls.ConnectionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tlsState
+}
+
+// RetryAfter returns the delay asked for by the last HTTP 429 response, 0
+// if there was none, and forgets it.
+func (t *probeTransport) RetryAfter() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.retryAfter
+	t.retryAfter = 0
+	return d
+}
+
+// parseRetryAfter parses a Retry-After header, given in seconds or as an
+// HTTP date. It returns 0 for a missing or invalid header.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// addResponseBytes records n bytes read and reports whether the probe is
+// still within its limit.
+func (t *probeTransport) addResponseBytes(n int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.responseBytes += int64(n)
+	return t.responseBytesLimit <= 0 || t.responseBytes <= t.responseBytesLimit
+}
+
+type countingBody struct {
+	io.ReadCloser
+	t *probeTransport
+	// err is set once the limit is exceeded, the body then reads no more.
+	err error
+
+	// The start of the body is kept for the trace, if any.
+	traceIndex int
+	traced     bytes.Buffer
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.t.trace != nil && b.traced.Len() <= maxTraceBodyLength {
+		b.traced.Write(p[:n])
+	}
+	if !b.t.addResponseBytes(n) {
+		// The bytes past the limit are not handed out, some decoders keep
+		// going on a read returning both data and an error.
+		b.err = errResponseBytesLimit
+		return 0, b.err
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.t.trace.setResponse(b.traceIndex, b.traced.String())
+	return b.ReadCloser.Close()
+}
+
+// countJSONRPCMethods returns the number of calls in a JSON-RPC request body:
+// the length of a batch, or 1 for a single call.
+func countJSONRPCMethods(body io.Reader) int {
+	var batch []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&batch); err != nil || len(batch) == 0 {
+		return 1
+	}
+	return len(batch)
+}
+
+// dialETHRPC connects to an Ethereum JSON-RPC endpoint, sending headers with
+// every HTTP request or with the WebSocket handshake. Requests sent over
+// HTTP are accounted for by the returned probeTransport; WebSocket
+// connections are not, nor are they rate limited. HTTP connections are kept
+// for reuse by later probes for the module's client_idle_ttl, WebSocket
+// connections are closed with the probe.
+func dialETHRPC(ctx context.Context, target string, headers http.Header, module config.Module) (*ethclient.Client, *probeTransport, error) {
+	tlsConfig, err := ethRPCTLSConfig(module)
+	if err != nil {
+		return nil, nil, err
+	}
+	newTransport := func() *http.Transport {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		return t
+	}
+	transport := &probeTransport{
+		perRequest:         module.ETHRPC.BillingModel == "per_request",
+		responseBytesLimit: int64(module.ETHRPC.ResponseBytesLimit),
+		trace:              callTraceFromContext(ctx),
+	}
+	if module.ETHRPC.RateLimit > 0 {
+		if u, err := url.Parse(target); err == nil {
+			transport.limiter = rpcRateLimiters.get(u.Host, module.ETHRPC.RateLimit, module.ETHRPC.RateLimitBurst)
+		}
+	}
+	if ttl := module.ETHRPC.ClientIdleTTL; ttl > 0 && ethRPCTransport(target) == "http" {
+		transport.next, transport.cached = rpcTransports.get(rpcTransportKey(target, headers, module), ttl, newTransport)
+	} else {
+		httpTransport := newTransport()
+		transport.next = httpTransport
+		context.AfterFunc(ctx, httpTransport.CloseIdleConnections)
+	}
+	c, err := rpc.DialOptions(ctx, target,
+		rpc.WithHTTPClient(&http.Client{Transport: transport}),
+		rpc.WithWebsocketDialer(websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 45 * time.Second,
+			TLSClientConfig:  tlsConfig,
+		}),
+		rpc.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Closing the client once the probe times out shuts a WebSocket
+	// connection down cleanly instead of leaving it to the server.
+	context.AfterFunc(ctx, c.Close)
+	return ethclient.NewClient(c), transport, nil
+}
+
+// rpcTransportKey identifies the HTTP transports that can be shared: those
+// of the same target, headers and TLS settings. Only a digest is kept, the
+// headers may hold API keys.
+func rpcTransportKey(target string, headers http.Header, module config.Module) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%+v\n%v\n", target, module.ETHRPC.TLSConfig, module.ETHRPC.CipherSuites)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %q\n", name, headers[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// transportCache keeps the HTTP transports of recent probes, and with them
+// their idle keep-alive connections, so that probes of the same target
+// every scrape interval skip the TCP and TLS handshakes. A transport is
+// dropped and its idle connections closed once it has not been used for
+// its TTL; requests still in flight on it are not affected.
+type transportCache struct {
+	mu      sync.Mutex
+	entries map[string]*transportCacheEntry
+}
+
+type transportCacheEntry struct {
+	transport *http.Transport
+	timer     *time.Timer
+}
+
+func newTransportCache() *transportCache {
+	return &transportCache{entries: make(map[string]*transportCacheEntry)}
+}
+
+// get returns the transport cached under key, or one made by newTransport,
+// and restarts its idle TTL. hit reports whether it was cached.
+func (c *transportCache) get(key string, ttl time.Duration, newTransport func() *http.Transport) (t *http.Transport, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.timer.Reset(ttl)
+		return e.transport, true
+	}
+	e := &transportCacheEntry{transport: newTransport()}
+	e.timer = time.AfterFunc(ttl, func() {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		e.transport.CloseIdleConnections()
+	})
+	c.entries[key] = e
+	return e.transport, false
+}
+
+// rpcTransports holds the HTTP transports shared by JSON-RPC probes.
+var rpcTransports = newTransportCache()
+
+// ethRPCTLSConfig builds the TLS configuration used for both HTTP and
+// WebSocket dials from the module's tls_config and cipher_suites.
+func ethRPCTLSConfig(module config.Module) (*tls.Config, error) {
+	tlsConfig, err := pconfig.NewTLSConfig(&module.ETHRPC.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := config.CipherSuiteIDs(module.ETHRPC.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.CipherSuites = cipherSuites
+	return tlsConfig, nil
+}
+
+// ethRPCTransport returns "ws" for WebSocket targets and "http" otherwise.
+func ethRPCTransport(target string) string {
+	if strings.HasPrefix(target, "ws://") || strings.HasPrefix(target, "wss://") {
+		return "ws"
+	}
+	return "http"
+}
+
+// Retried failures are classified, for probe_jsonrpc_retries, as:
+//   - rate_limited: an HTTP 429 response.
+//   - server_error: an HTTP 5xx response.
+//   - network: the endpoint could not be reached or the response read.
+//   - other: any other failure, e.g. a JSON-RPC error.
+const (
+	retryRateLimited = "rate_limited"
+	retryServerError = "server_error"
+	retryNetwork     = "network"
+	retryOther       = "other"
+)
+
+// retryReason returns the class of a failed call.
+func retryReason(err error) string {
+	var (
+		httpErr rpc.HTTPError
+		netErr  net.Error
+	)
+	switch {
+	case errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests:
+		return retryRateLimited
+	case errors.As(err, &httpErr) && httpErr.StatusCode >= 500:
+		return retryServerError
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return retryNetwork
+	}
+	return retryOther
+}
+
+// rpcRetryPolicy retries failed JSON-RPC calls as set by the retries probe
+// param, with the module's retry_backoff for each class of failure. The
+// retryBackoffMs param overrides the backoff of all classes.
+type rpcRetryPolicy struct {
+	retries int
+	backoff config.ETHRPCRetryBackoff
+	// retryAfter, if set, returns the delay asked for by the last HTTP 429
+	// response, 0 if none.
+	retryAfter func() time.Duration
+}
+
+func parseRPCRetryPolicy(params url.Values, module config.Module) (rpcRetryPolicy, error) {
+	p := rpcRetryPolicy{backoff: module.ETHRPC.RetryBackoff}
+	if r := params.Get("retries"); r != "" {
+		n, err := strconv.Atoi(r)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("retries '%s' is not valid", r)
+		}
+		p.retries = n
+	}
+	if b := params.Get("retryBackoffMs"); b != "" {
+		ms, err := strconv.Atoi(b)
+		if err != nil || ms <= 0 {
+			return p, fmt.Errorf("retryBackoffMs '%s' is not valid", b)
+		}
+		d := time.Duration(ms) * time.Millisecond
+		p.backoff = config.ETHRPCRetryBackoff{RateLimited: d, ServerError: d, Network: d, Other: d}
+	}
+	return p, nil
+}
+
+// delay returns how long to wait before retrying a call that failed for
+// reason, after attempt earlier retries for the same reason.
+func (p rpcRetryPolicy) delay(reason string, attempt int) time.Duration {
+	switch reason {
+	case retryRateLimited:
+		if p.retryAfter != nil {
+			if d := p.retryAfter(); d > 0 {
+				return d
+			}
+		}
+		return p.backoff.RateLimited << attempt
+	case retryServerError:
+		return p.backoff.ServerError << attempt
+	case retryNetwork:
+		return p.backoff.Network
+	}
+	return p.backoff.Other << attempt
+}
+
+// do runs call until it succeeds or the retries are exhausted, and returns
+// the number of retries used for each class of failure along with the last
+// error. It gives up early when the next attempt would start after the
+// context deadline, and does not retry once the response bytes limit or
+// the local rate limit is reached.
+func (p rpcRetryPolicy) do(ctx context.Context, call func() error) (map[string]int, error) {
+	err := call()
+	retries := make(map[string]int)
+	for n := 0; err != nil && n < p.retries; n++ {
+		if errors.Is(err, errResponseBytesLimit) || errors.Is(err, errRateLimitedLocal) || ctx.Err() != nil {
+			break
+		}
+		reason := retryReason(err)
+		backoff := p.delay(reason, retries[reason])
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return retries, err
+		case <-time.After(backoff):
+		}
+		retries[reason]++
+		err = call()
+	}
+	return retries, err
+}
+
+// ethRPCHeaders merges the headers configured on the module with those given
+// as header=Name:Value probe params. Params are added to, rather than
+// replace, configured values.
+func ethRPCHeaders(params url.Values, module config.Module) (http.Header, error) {
+	headers := make(http.Header)
+	for name, value := range module.ETHRPC.Headers {
+		headers.Set(name, string(value))
+	}
+	for _, h := range params["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("header param %q is not valid, must be Name:Value", h)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestRetryReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{rpc.HTTPError{StatusCode: http.StatusTooManyRequests}, retryRateLimited},
+		{rpc.HTTPError{StatusCode: http.StatusBadGateway}, retryServerError},
+		{rpc.HTTPError{StatusCode: http.StatusForbidden}, retryOther},
+		{&url.Error{Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, retryNetwork},
+		{fmt.Errorf("reading response: %w", io.ErrUnexpectedEOF), retryNetwork},
+		{&jsonRPCTestError{Code: -32000, Message: "header not found"}, retryOther},
+	}
+	for _, test := range tests {
+		if got := retryReason(test.err); got != test.want {
+			t.Errorf("%v: expected %s, got %s", test.err, test.want, got)
+		}
+	}
+}
+
+func TestRPCRetryPolicyDelay(t *testing.T) {
+	p := rpcRetryPolicy{backoff: config.ETHRPCRetryBackoff{
+		RateLimited: time.Second,
+		ServerError: 100 * time.Millisecond,
+		Network:     10 * time.Millisecond,
+		Other:       50 * time.Millisecond,
+	}}
+	tests := []struct {
+		reason string
+		want   []time.Duration
+	}{
+		// Rate limits and server errors back off exponentially.
+		{retryRateLimited, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{retryServerError, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}},
+		// Network errors are retried fast, at a steady pace.
+		{retryNetwork, []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}},
+		{retryOther, []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		for attempt, want := range test.want {
+			if got := p.delay(test.reason, attempt); got != want {
+				t.Errorf("%s attempt %d: expected %s, got %s", test.reason, attempt, want, got)
+			}
+		}
+	}
+
+	// A Retry-After header takes precedence for rate limits only.
+	p.retryAfter = func() time.Duration { return 3 * time.Second }
+	if got := p.delay(retryRateLimited, 2); got != 3*time.Second {
+		t.Errorf("expected Retry-After to be respected, got %s", got)
+	}
+	if got := p.delay(retryServerError, 0); got != 100*time.Millisecond {
+		t.Errorf("expected Retry-After to be ignored for server errors, got %s", got)
+	}
+}
+
+func TestRPCRetryPolicyDo(t *testing.T) {
+	p := rpcRetryPolicy{retries: 3, backoff: config.ETHRPCRetryBackoff{
+		RateLimited: time.Millisecond,
+		ServerError: time.Millisecond,
+		Network:     time.Millisecond,
+		Other:       time.Millisecond,
+	}}
+	errs := []error{
+		rpc.HTTPError{StatusCode: http.StatusTooManyRequests},
+		rpc.HTTPError{StatusCode: http.StatusServiceUnavailable},
+		io.EOF,
+	}
+	calls := 0
+	retries, err := p.do(context.Background(), func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the call to succeed on the last retry, got %s", err)
+	}
+	want := map[string]int{retryRateLimited: 1, retryServerError: 1, retryNetwork: 1}
+	if fmt.Sprint(retries) != fmt.Sprint(want) {
+		t.Errorf("expected retries %v, got %v", want, retries)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("2"); got != 2*time.Second {
+		t.Errorf("expected 2s, got %s", got)
+	}
+	if got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got < 50*time.Second || got > time.Minute {
+		t.Errorf("expected about a minute, got %s", got)
+	}
+	for _, v := range []string{"", "-1", "soon"} {
+		if got := parseRetryAfter(v); got != 0 {
+			t.Errorf("%q: expected 0, got %s", v, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// lamportsDecimals is the number of decimals of SOL in lamports.
+const lamportsDecimals = 9
+
+// ProbeSolana reads the slot, the health and the balance of the accounts
+// given as account params from a Solana JSON-RPC endpoint. The module's
+// ethrpc settings, such as headers and TLS, apply to it as well.
+func ProbeSolana(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		slotGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_solana_slot",
+			Help: "Slot the node has reached",
+		})
+		healthGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_solana_health",
+			Help: "1 if getHealth reports the node as ok, 0 otherwise",
+		})
+		balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_solana_balance",
+			Help: "Balance of the account in SOL",
+		}, []string{"account"})
+	)
+	registry.MustRegister(slotGauge)
+	registry.MustRegister(healthGauge)
+	registry.MustRegister(balanceGaugeVec)
+
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	headers, err := ethRPCHeaders(params, module)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+	eth, _, err := dialETHRPC(ctx, target, headers, module)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error dialing rpc", target, err)
+		return false
+	}
+	defer eth.Close()
+	c := eth.Client()
+
+	var slot uint64
+	if err := c.CallContext(ctx, &slot, "getSlot"); err != nil {
+		level.Error(logger).Log("msg", "get slot failed, "+err.Error())
+		return false
+	}
+	slotGauge.Set(float64(slot))
+
+	// An unhealthy node answers getHealth with an error, e.g. -32005 when
+	// it is behind.
+	success = true
+	var health string
+	if err := c.CallContext(ctx, &health, "getHealth"); err != nil {
+		level.Error(logger).Log("msg", "node is not healthy, "+err.Error())
+		success = false
+	} else if health != "ok" {
+		level.Error(logger).Log("msg", "node is not healthy, getHealth returned "+health)
+		success = false
+	}
+	if success {
+		healthGauge.Set(1)
+	}
+
+	for _, account := range params["account"] {
+		var balance struct {
+			Value uint64 `json:"value"`
+		}
+		if err := c.CallContext(ctx, &balance, "getBalance", account); err != nil {
+			level.Error(logger).Log("msg", "get balance failed, "+err.Error(), "account", account)
+			success = false
+			continue
+		}
+		sol, _ := new(big.Float).Quo(new(big.Float).SetUint64(balance.Value), new(big.Float).SetInt(pow10(lamportsDecimals))).Float64()
+		balanceGaugeVec.WithLabelValues(account).Set(sol)
+	}
+	return success
+}
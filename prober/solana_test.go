@@ -0,0 +1,81 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestSolana(t *testing.T) {
+	const treasury = "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"
+	healthy := true
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "getSlot":
+			return 250000000, nil
+		case "getHealth":
+			if !healthy {
+				return nil, &jsonRPCTestError{Code: -32005, Message: "Node is behind by 120 slots"}
+			}
+			return "ok", nil
+		case "getBalance":
+			return map[string]interface{}{
+				"context": map[string]uint64{"slot": 250000000},
+				// 1.5 SOL
+				"value": 1500000000,
+			}, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	for _, test := range []struct {
+		healthy bool
+		health  float64
+	}{
+		{true, 1},
+		{false, 0},
+	} {
+		healthy = test.healthy
+		registry := prometheus.NewRegistry()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result := ProbeSolana(ctx, ts.URL, url.Values{"account": {treasury}}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger())
+		cancel()
+		if result != test.healthy {
+			t.Errorf("healthy=%v: expected success %v, got %v", test.healthy, test.healthy, result)
+		}
+		mfs, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gaugeValues(mfs, "probe_solana_slot", ""); got[""] != 250000000 {
+			t.Errorf("healthy=%v: unexpected slot %v", test.healthy, got)
+		}
+		if got := gaugeValues(mfs, "probe_solana_health", ""); got[""] != test.health {
+			t.Errorf("healthy=%v: expected health %v, got %v", test.healthy, test.health, got)
+		}
+		if got := gaugeValues(mfs, "probe_solana_balance", "account"); got[treasury] != 1.5 {
+			t.Errorf("healthy=%v: unexpected balance %v", test.healthy, got)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeHistory remembers values observed by earlier probes so that a probe
+// can report how a value changed between scrapes. Probes are otherwise
+// stateless, each one gets a fresh registry. With a ttl, keys not observed
+// for ttl, e.g. accounts no longer probed, are forgotten.
+type probeHistory struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]historyEntry
+}
+
+type historyEntry struct {
+	value   string
+	changed time.Time
+	seen    time.Time
+}
+
+func newProbeHistory(ttl time.Duration) *probeHistory {
+	return &probeHistory{ttl: ttl, entries: make(map[string]historyEntry)}
+}
+
+// expire drops the entries not observed for ttl. h.mu must be held.
+func (h *probeHistory) expire(now time.Time) {
+	if h.ttl <= 0 {
+		return
+	}
+	for key, e := range h.entries {
+		if now.Sub(e.seen) > h.ttl {
+			delete(h.entries, key)
+		}
+	}
+}
+
+// observe records value under key. unchanged reports whether an earlier probe
+// saw the same value, and unchangedFor how long ago the value last changed.
+func (h *probeHistory) observe(key, value string, now time.Time) (unchangedFor time.Duration, unchanged bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire(now)
+	prev, ok := h.entries[key]
+	if !ok || prev.value != value {
+		h.entries[key] = historyEntry{value: value, changed: now, seen: now}
+		return 0, false
+	}
+	prev.seen = now
+	h.entries[key] = prev
+	return now.Sub(prev.changed), true
+}
+
+// swap records value under key and returns the value it replaces. seen is
+// false the first time key is recorded.
+func (h *probeHistory) swap(key, value string, now time.Time) (prev string, seen bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire(now)
+	prevEntry, seen := h.entries[key]
+	if !seen || prevEntry.value != value {
+		h.entries[key] = historyEntry{value: value, changed: now, seen: now}
+	} else {
+		prevEntry.seen = now
+		h.entries[key] = prevEntry
+	}
+	return prevEntry.value, seen
+}
+
+// get returns the value recorded under key, which keeps it from expiring.
+func (h *probeHistory) get(key string, now time.Time) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire(now)
+	e, ok := h.entries[key]
+	if !ok {
+		return "", false
+	}
+	e.seen = now
+	h.entries[key] = e
+	return e.value, true
+}
+
+// rewardsHistory holds the pending rewards seen by the staking_rewards module.
+var rewardsHistory = newProbeHistory(time.Hour)
+
+// nonceHistory holds the nonces seen by the nonce module.
+var nonceHistory = newProbeHistory(time.Hour)
+
+// guardianHistory holds the guardians seen by the timelock module.
+var guardianHistory = newProbeHistory(time.Hour)
+
+// tokenDecimals caches the decimals() read by the erc20balance module, which
+// a token does not change.
+var tokenDecimals = newProbeHistory(time.Hour)
+
+// latencyBaseline keeps the latencies of the last size successful probes per
+// key, the baseline a new latency is compared against. Keys not observed for
+// ttl, e.g. targets no longer probed, are dropped.
+type latencyBaseline struct {
+	size       int
+	minSamples int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	samples map[string]*latencySamples
+}
+
+type latencySamples struct {
+	latencies []time.Duration
+	seen      time.Time
+}
+
+func newLatencyBaseline(size, minSamples int, ttl time.Duration) *latencyBaseline {
+	return &latencyBaseline{size: size, minSamples: minSamples, ttl: ttl, samples: make(map[string]*latencySamples)}
+}
+
+// observe returns the ratio of d to the mean of the latencies recorded for
+// key so far, then records d. ok is false until minSamples latencies have
+// been recorded, a cold baseline says nothing about a regression.
+func (b *latencyBaseline) observe(key string, d time.Duration, now time.Time) (ratio float64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k, s := range b.samples {
+		if now.Sub(s.seen) > b.ttl {
+			delete(b.samples, k)
+		}
+	}
+	s, found := b.samples[key]
+	if !found {
+		s = &latencySamples{}
+		b.samples[key] = s
+	}
+	if len(s.latencies) >= b.minSamples {
+		var sum time.Duration
+		for _, p := range s.latencies {
+			sum += p
+		}
+		if mean := sum / time.Duration(len(s.latencies)); mean > 0 {
+			ratio, ok = float64(d)/float64(mean), true
+		}
+	}
+	s.latencies = append(s.latencies, d)
+	if len(s.latencies) > b.size {
+		s.latencies = s.latencies[len(s.latencies)-b.size:]
+	}
+	s.seen = now
+	return ratio, ok
+}
+
+// rpcLatencyBaseline holds the latencies of successful ETHRPC probes per
+// target and module.
+var rpcLatencyBaseline = newLatencyBaseline(50, 5, time.Hour)
+
+// errRateLimitedLocal is returned when a request would have to wait for the
+// rate limit past the deadline of the probe.
+var errRateLimitedLocal = errors.New("rate limited by the exporter")
+
+// tokenBucket limits requests to rate per second, with bursts of up to
+// burst requests.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// waiting is the number of callers waiting for tokens, waited the total
+	// time they waited.
+	waiting int
+	waited  time.Duration
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes n tokens and returns how long the caller has to wait until
+// they are available. Tokens are taken even if the caller has to wait, so
+// that waiting callers are served in order.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= math.Min(n, b.burst)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket has been full and unused for longer than
+// it takes to refill, so that dropping it changes no limit.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	elapsed := now.Sub(b.last)
+	return b.waiting == 0 &&
+		elapsed.Seconds() > b.burst/b.rate &&
+		b.tokens+elapsed.Seconds()*b.rate >= b.burst
+}
+
+// cancel returns n tokens taken by a caller that gave up waiting.
+func (b *tokenBucket) cancel(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+math.Min(n, b.burst))
+}
+
+// wait blocks until n tokens are available. It returns errRateLimitedLocal
+// right away when they would only be available after the deadline of ctx.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	d := b.reserve(float64(n), time.Now())
+	if d == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		b.cancel(float64(n))
+		return errRateLimitedLocal
+	}
+	b.mu.Lock()
+	b.waiting++
+	b.mu.Unlock()
+	start := time.Now()
+	defer func() {
+		b.mu.Lock()
+		b.waiting--
+		b.waited += time.Since(start)
+		b.mu.Unlock()
+	}()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		b.cancel(float64(n))
+		return errRateLimitedLocal
+	case <-t.C:
+		return nil
+	}
+}
+
+// rateLimiters holds the token buckets of the hosts probed with a rate
+// limit, keyed by host and limits. Idle buckets are dropped, a later request
+// gets a new, full one. It is a prometheus.Collector of how much the probes
+// wait for them, per host.
+type rateLimiters struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	hosts   map[*tokenBucket]string
+}
+
+var (
+	limiterWaitDesc = prometheus.NewDesc(
+		"probe_rpc_limiter_wait_seconds",
+		"Total time requests waited for the exporter's rate limit of the host",
+		[]string{"host"}, nil)
+	limiterQueueDepthDesc = prometheus.NewDesc(
+		"probe_rpc_limiter_queue_depth",
+		"Number of requests waiting for the exporter's rate limit of the host",
+		[]string{"host"}, nil)
+)
+
+func newRateLimiters() *rateLimiters {
+	return &rateLimiters{buckets: make(map[string]*tokenBucket), hosts: make(map[*tokenBucket]string)}
+}
+
+func (l *rateLimiters) get(host string, rate float64, burst int) *tokenBucket {
+	key := fmt.Sprintf("%s|%g|%d", host, rate, burst)
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if k != key && b.idle(now) {
+			delete(l.buckets, k)
+			delete(l.hosts, b)
+		}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(rate, burst)
+		l.buckets[key] = b
+		l.hosts[b] = host
+	}
+	return b
+}
+
+// Describe implements prometheus.Collector.
+func (l *rateLimiters) Describe(ch chan<- *prometheus.Desc) {
+	ch <- limiterWaitDesc
+	ch <- limiterQueueDepthDesc
+}
+
+// Collect implements prometheus.Collector. Hosts probed by modules with
+// different limits have a bucket for each, they are added up. The wait of a
+// host restarts from zero once its buckets were dropped.
+func (l *rateLimiters) Collect(ch chan<- prometheus.Metric) {
+	waited := make(map[string]time.Duration)
+	waiting := make(map[string]int)
+	l.mu.Lock()
+	for b, host := range l.hosts {
+		b.mu.Lock()
+		waited[host] += b.waited
+		waiting[host] += b.waiting
+		b.mu.Unlock()
+	}
+	l.mu.Unlock()
+	for host, d := range waited {
+		ch <- prometheus.MustNewConstMetric(limiterWaitDesc, prometheus.CounterValue, d.Seconds(), host)
+		ch <- prometheus.MustNewConstMetric(limiterQueueDepthDesc, prometheus.GaugeValue, float64(waiting[host]), host)
+	}
+}
+
+// RateLimiterCollector returns the collector of the waits for the rate
+// limits of JSON-RPC target hosts, an operational metric of the exporter
+// rather than of a probe.
+func RateLimiterCollector() prometheus.Collector {
+	return rpcRateLimiters
+}
+
+// rpcRateLimiters holds the rate limits of JSON-RPC target hosts.
+var rpcRateLimiters = newRateLimiters()
@@ -0,0 +1,194 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLatencyBaselineRegression(t *testing.T) {
+	b := newLatencyBaseline(10, 5, time.Hour)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, ok := b.observe("rpc", 100*time.Millisecond, now); ok {
+			t.Fatalf("sample %d: expected no ratio before the baseline is warm", i)
+		}
+	}
+	ratio, ok := b.observe("rpc", 300*time.Millisecond, now)
+	if !ok || math.Abs(ratio-3) > 1e-9 {
+		t.Errorf("expected a ratio of 3, got %v (ok=%v)", ratio, ok)
+	}
+	if _, ok := b.observe("other", 300*time.Millisecond, now); ok {
+		t.Errorf("expected baselines to be kept per key")
+	}
+
+	// Once the window only holds slow samples they become the baseline.
+	for i := 0; i < 10; i++ {
+		b.observe("rpc", 300*time.Millisecond, now)
+	}
+	ratio, _ = b.observe("rpc", 300*time.Millisecond, now)
+	if math.Abs(ratio-1) > 1e-9 {
+		t.Errorf("expected the bounded window to forget old samples, got ratio %v", ratio)
+	}
+}
+
+func TestLatencyBaselineExpiry(t *testing.T) {
+	b := newLatencyBaseline(10, 5, time.Hour)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		b.observe("rpc", 100*time.Millisecond, now)
+		b.observe("gone", 100*time.Millisecond, now)
+	}
+	now = now.Add(45 * time.Minute)
+	if _, ok := b.observe("rpc", 100*time.Millisecond, now); !ok {
+		t.Errorf("expected a key observed within the TTL to be kept")
+	}
+	now = now.Add(30 * time.Minute)
+	if _, ok := b.observe("rpc", 100*time.Millisecond, now); !ok {
+		t.Errorf("expected a key observed within the TTL to be kept")
+	}
+	if _, ok := b.samples["gone"]; ok {
+		t.Errorf("expected a key not observed for longer than the TTL to be dropped")
+	}
+	if _, ok := b.observe("gone", 100*time.Millisecond, now); ok {
+		t.Errorf("expected a dropped key to start a cold baseline")
+	}
+}
+
+func TestProbeHistoryExpiry(t *testing.T) {
+	h := newProbeHistory(time.Hour)
+	now := time.Now()
+	h.observe("active", "1", now)
+	h.observe("gone", "1", now)
+	now = now.Add(45 * time.Minute)
+	h.observe("active", "1", now)
+	now = now.Add(30 * time.Minute)
+	unchangedFor, unchanged := h.observe("active", "1", now)
+	if !unchanged || unchangedFor != 75*time.Minute {
+		t.Errorf("expected a key observed within the TTL to be kept, unchanged for 75m, got %v (unchanged=%v)", unchangedFor, unchanged)
+	}
+	if _, ok := h.entries["gone"]; ok {
+		t.Errorf("expected a key not observed for longer than the TTL to be dropped")
+	}
+	if _, seen := h.swap("gone", "1", now); seen {
+		t.Errorf("expected a dropped key to be recorded anew")
+	}
+}
+
+func TestProbeHistoryGet(t *testing.T) {
+	h := newProbeHistory(time.Hour)
+	now := time.Now()
+	if _, ok := h.get("token", now); ok {
+		t.Errorf("expected no value before one is recorded")
+	}
+	h.observe("token", "6", now)
+	// Reading a value keeps it from expiring.
+	for i := 0; i < 3; i++ {
+		now = now.Add(45 * time.Minute)
+		if v, ok := h.get("token", now); !ok || v != "6" {
+			t.Fatalf("read %d: expected 6, got %q (ok=%v)", i, v, ok)
+		}
+	}
+	if _, ok := h.get("token", now.Add(2*time.Hour)); ok {
+		t.Errorf("expected a value not read for longer than the TTL to be dropped")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 0)
+	now := time.Now()
+	// A burst of two, then one token every 500ms.
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := b.reserve(1, now); got != want {
+			t.Errorf("request %d: expected a wait of %v, got %v", i, want, got)
+		}
+	}
+	// Cancelled requests give their tokens back.
+	b.cancel(2)
+	if got := b.reserve(1, now.Add(500*time.Millisecond)); got != 0 {
+		t.Errorf("expected no wait after cancelled requests, got %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b = newTokenBucket(1, 1)
+	if err := b.wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.wait(ctx, 1); !errors.Is(err, errRateLimitedLocal) {
+		t.Errorf("expected a wait past the deadline to fail, got %v", err)
+	}
+}
+
+func TestRateLimitersEviction(t *testing.T) {
+	l := newRateLimiters()
+	idle := l.get("idle.example.com", 10, 1)
+	busy := l.get("busy.example.com", 10, 1)
+	now := time.Now()
+	idle.reserve(1, now.Add(-time.Second))
+	// Still refilling from a request 50ms ago.
+	busy.reserve(1, now.Add(-50*time.Millisecond))
+
+	l.get("other.example.com", 10, 1)
+	if _, ok := l.hosts[idle]; ok {
+		t.Errorf("expected a full bucket unused for longer than its refill window to be dropped")
+	}
+	if _, ok := l.hosts[busy]; !ok {
+		t.Errorf("expected a refilling bucket to be kept")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("expected 2 buckets left, got %d", len(l.buckets))
+	}
+	if b := l.get("idle.example.com", 10, 1); b == idle {
+		t.Errorf("expected a dropped bucket to be replaced by a new one")
+	}
+}
+
+func TestRateLimitersCollector(t *testing.T) {
+	l := newRateLimiters()
+	// One token every 20ms, after a burst of one.
+	b := l.get("rpc.example.com", 50, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := b.wait(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(l)
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var waited float64
+	for _, mf := range mfs {
+		if mf.GetName() == "probe_rpc_limiter_wait_seconds" {
+			waited = mf.Metric[0].GetCounter().GetValue()
+		}
+	}
+	if waited < 0.03 {
+		t.Errorf("expected the requests to have waited about 40ms, got %v", waited)
+	}
+	if got := gaugeValues(mfs, "probe_rpc_limiter_queue_depth", "host"); got["rpc.example.com"] != 0 {
+		t.Errorf("expected no request left waiting, got %v", got)
+	}
+}
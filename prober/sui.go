@@ -0,0 +1,152 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// suiDecimals is the number of decimals of SUI in MIST.
+const suiDecimals = 9
+
+// ProbeSui reads the latest checkpoint, the epoch and the total number of
+// transactions from a Sui JSON-RPC endpoint, and the balance of the accounts
+// given as account params. Balances are extracted from the suix_getBalance
+// result with the jmespath param, totalBalance by default, and scaled by
+// decimals, 9 by default. coinType selects a coin other than SUI.
+func ProbeSui(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		checkpointGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_sui_checkpoint",
+			Help: "Sequence number of the latest checkpoint",
+		})
+		epochGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_sui_epoch",
+			Help: "Current epoch of the network",
+		})
+		totalTransactionsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_sui_total_transactions",
+			Help: "Total number of transaction blocks known to the node",
+		})
+		balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_sui_balance",
+			Help: "Balance of the account, scaled by decimals",
+		}, []string{"account", "coinType"})
+	)
+	registry.MustRegister(checkpointGauge)
+	registry.MustRegister(epochGauge)
+	registry.MustRegister(totalTransactionsGauge)
+	registry.MustRegister(balanceGaugeVec)
+
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	decimals := suiDecimals
+	if params.Get("decimals") != "" {
+		var err error
+		decimals, err = decimalsParam(params)
+		if err != nil {
+			level.Error(logger).Log("msg", err.Error())
+			return false
+		}
+	}
+	jmespathString := params.Get("jmespath")
+	if jmespathString == "" {
+		jmespathString = "totalBalance"
+	}
+	headers, err := ethRPCHeaders(params, module)
+	if err != nil {
+		level.Error(logger).Log("msg", err.Error())
+		return false
+	}
+	eth, _, err := dialETHRPC(ctx, target, headers, module)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error dialing rpc", target, err)
+		return false
+	}
+	defer eth.Close()
+	c := eth.Client()
+
+	// Sui returns 64-bit integers as decimal strings.
+	for _, g := range []struct {
+		method string
+		gauge  prometheus.Gauge
+	}{
+		{"sui_getLatestCheckpointSequenceNumber", checkpointGauge},
+		{"sui_getTotalTransactionBlocks", totalTransactionsGauge},
+	} {
+		var result string
+		if err := c.CallContext(ctx, &result, g.method); err != nil {
+			level.Error(logger).Log("msg", g.method+" failed, "+err.Error())
+			return false
+		}
+		n, err := strconv.ParseUint(result, 10, 64)
+		if err != nil {
+			level.Error(logger).Log("msg", "unexpected "+g.method+" result "+result)
+			return false
+		}
+		g.gauge.Set(float64(n))
+	}
+	var systemState struct {
+		Epoch string `json:"epoch"`
+	}
+	if err := c.CallContext(ctx, &systemState, "suix_getLatestSuiSystemState"); err != nil {
+		level.Error(logger).Log("msg", "suix_getLatestSuiSystemState failed, "+err.Error())
+		return false
+	}
+	epoch, err := strconv.ParseUint(systemState.Epoch, 10, 64)
+	if err != nil {
+		level.Error(logger).Log("msg", "unexpected epoch "+systemState.Epoch)
+		return false
+	}
+	epochGauge.Set(float64(epoch))
+
+	success = true
+	coinType := params.Get("coinType")
+	for _, account := range params["account"] {
+		args := []interface{}{account}
+		if coinType != "" {
+			args = append(args, coinType)
+		}
+		var balance interface{}
+		if err := c.CallContext(ctx, &balance, "suix_getBalance", args...); err != nil {
+			level.Error(logger).Log("msg", "suix_getBalance failed, "+err.Error(), "account", account)
+			success = false
+			continue
+		}
+		result, err := searchJMESPath(jmespathString, balance, logger)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error jmespath search "+err.Error(), "account", account)
+			success = false
+			continue
+		}
+		value, err := resultToFloat64WithDecimals(result, decimals)
+		if err != nil {
+			level.Error(logger).Log("msg", "Make sure the value get from jmespath is a number, "+err.Error(), "account", account)
+			success = false
+			continue
+		}
+		balanceGaugeVec.WithLabelValues(account, coinType).Set(value)
+	}
+	return success
+}
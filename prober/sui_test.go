@@ -0,0 +1,74 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestSui(t *testing.T) {
+	const owner = "0x94f1a597b4e8f709a396f7f6b1482bdcd65a673d111e49286c527fab7c2d0961"
+	ts := newJSONRPCTestServer(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "sui_getLatestCheckpointSequenceNumber":
+			return "31250000", nil
+		case "sui_getTotalTransactionBlocks":
+			return "2500000000", nil
+		case "suix_getLatestSuiSystemState":
+			return map[string]string{"epoch": "380", "protocolVersion": "42"}, nil
+		case "suix_getBalance":
+			return map[string]interface{}{
+				"coinType":        "0x2::sui::SUI",
+				"coinObjectCount": 3,
+				// 12.5 SUI
+				"totalBalance": "12500000000",
+			}, nil
+		}
+		return nil, &jsonRPCTestError{Code: -32601, Message: "method not found"}
+	})
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := ProbeSui(ctx, ts.URL, url.Values{"account": {owner}}, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger())
+	if !result {
+		t.Fatalf("sui probe failed unexpectedly")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{
+		"probe_sui_checkpoint":         31250000,
+		"probe_sui_total_transactions": 2500000000,
+		"probe_sui_epoch":              380,
+	} {
+		if got := gaugeValues(mfs, name, ""); got[""] != want {
+			t.Errorf("expected %s %v, got %v", name, want, got)
+		}
+	}
+	if got := gaugeValues(mfs, "probe_sui_balance", "account"); got[owner] != 12.5 {
+		t.Errorf("unexpected balance %v", got)
+	}
+}
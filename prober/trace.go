@@ -0,0 +1,193 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// maxTraceResultLength bounds the raw results shown in the debug output.
+const maxTraceResultLength = 130
+
+// maxTraceBodyLength bounds the request and response bodies shown in the
+// debug output.
+const maxTraceBodyLength = 2048
+
+type callTraceKey struct{}
+
+// CallTrace records, for the debug output, the RPC calls made by a probe
+// along with the raw result, the value extracted from it and the metric it
+// was exported as.
+type CallTrace struct {
+	mu       sync.Mutex
+	target   string
+	calls    []tracedCall
+	requests []tracedRequest
+	secrets  []string
+}
+
+type tracedCall struct {
+	method, result, value, metric string
+}
+
+// tracedRequest is an HTTP request sent by the probe, as method and URL,
+// with its body and the body of the response.
+type tracedRequest struct {
+	request, body, response string
+}
+
+func contextWithCallTrace(ctx context.Context, t *CallTrace) context.Context {
+	return context.WithValue(ctx, callTraceKey{}, t)
+}
+
+// callTraceFromContext returns the trace of the probe, or nil when it is not
+// traced. The methods of CallTrace are no-ops on nil.
+func callTraceFromContext(ctx context.Context) *CallTrace {
+	t, _ := ctx.Value(callTraceKey{}).(*CallTrace)
+	return t
+}
+
+// add records a call. metric is the series the value was exported as,
+// without the rpc label, or empty if the value is used otherwise.
+func (t *CallTrace) add(method, result, value, metric string) {
+	if t == nil {
+		return
+	}
+	if len(result) > maxTraceResultLength {
+		result = result[:maxTraceResultLength] + "..."
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, tracedCall{method, result, value, metric})
+}
+
+// setTarget records the endpoint that served the probe, once resolved.
+func (t *CallTrace) setTarget(target string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.target = target
+}
+
+// addRequest records an HTTP request and returns the index to pass to
+// setResponse.
+func (t *CallTrace) addRequest(request, body string) int {
+	if t == nil {
+		return -1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, tracedRequest{request: request, body: truncateTraceBody(body)})
+	return len(t.requests) - 1
+}
+
+// setResponse records the response body of request i.
+func (t *CallTrace) setResponse(i int, response string) {
+	if t == nil || i < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests[i].response = truncateTraceBody(response)
+}
+
+func truncateTraceBody(s string) string {
+	if len(s) > maxTraceBodyLength {
+		return s[:maxTraceBodyLength] + "..."
+	}
+	return s
+}
+
+// redact hides the given values, such as API keys passed as headers,
+// wherever they appear in the trace.
+func (t *CallTrace) redact(secrets ...string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range secrets {
+		if s != "" {
+			t.secrets = append(t.secrets, s)
+		}
+	}
+}
+
+func (t *CallTrace) empty() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.target == "" && len(t.calls) == 0 && len(t.requests) == 0
+}
+
+// WriteTo writes the target, the recorded calls as a table and the HTTP
+// requests along with their responses.
+func (t *CallTrace) WriteTo(w io.Writer) (int64, error) {
+	if t == nil {
+		return 0, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sb strings.Builder
+	if t.target != "" {
+		fmt.Fprintf(&sb, "Target: %s\n\n", t.target)
+	}
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tRESULT\tVALUE\tMETRIC")
+	for _, c := range t.calls {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.method, c.result, c.value, c.metric)
+	}
+	tw.Flush()
+	for _, r := range t.requests {
+		fmt.Fprintf(&sb, "\n%s\n> %s\n< %s\n", r.request, r.body, r.response)
+	}
+	n, err := io.WriteString(w, t.hideSecrets(sb.String()))
+	return int64(n), err
+}
+
+// hide replaces the redacted values in s, such as the probe logs.
+func (t *CallTrace) hide(s string) string {
+	if t == nil {
+		return s
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hideSecrets(s)
+}
+
+func (t *CallTrace) hideSecrets(s string) string {
+	for _, secret := range t.secrets {
+		s = strings.ReplaceAll(s, secret, "<secret>")
+	}
+	return s
+}
+
+// traceMetric formats a series for CallTrace.add from its name and label
+// name and value pairs.
+func traceMetric(name string, labels ...string) string {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
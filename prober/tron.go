@@ -0,0 +1,141 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// sunPerTRX is the number of sun, the unit of TRON balances, in one TRX.
+const sunPerTRX = 1e6
+
+// ProbeTron reads the latest block of a TRON node from its HTTP API
+// /wallet/getnowblock, and with account params the TRX balances of those
+// addresses from /wallet/getaccount. Addresses are given in base58, e.g.
+// account=TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t. Requests use the
+// http_client_config, headers, e.g. TRON-PRO-API-KEY, and body_size_limit of
+// the module's http section.
+func ProbeTron(ctx context.Context, target string, params url.Values, module config.Module, registry *prometheus.Registry, logger log.Logger) (success bool) {
+	var (
+		blockNumberGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_tron_block_number",
+			Help: "Number of the latest block of the node",
+		})
+		blockLagGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_tron_block_lag_seconds",
+			Help: "Seconds between now and the timestamp of the latest block",
+		})
+		balanceGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_tron_balance",
+			Help: "TRX balance of the account",
+		}, []string{"account"})
+	)
+	registry.MustRegister(blockNumberGauge)
+	registry.MustRegister(blockLagGauge)
+	registry.MustRegister(balanceGaugeVec)
+
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = "http://" + target
+	}
+	target = strings.TrimSuffix(target, "/")
+
+	client, err := newAPIClient(module, "tron_probe")
+	if err != nil {
+		level.Error(logger).Log("msg", "Error generating HTTP client", "err", err)
+		return false
+	}
+
+	var block struct {
+		BlockHeader *struct {
+			RawData struct {
+				Number    uint64 `json:"number"`
+				Timestamp int64  `json:"timestamp"`
+			} `json:"raw_data"`
+		} `json:"block_header"`
+	}
+	if err := tronCall(ctx, client, target+"/wallet/getnowblock", nil, &block); err != nil {
+		level.Error(logger).Log("msg", "getnowblock failed, "+err.Error())
+		return false
+	}
+	if block.BlockHeader == nil {
+		level.Error(logger).Log("msg", "getnowblock returned no block_header")
+		return false
+	}
+	blockNumberGauge.Set(float64(block.BlockHeader.RawData.Number))
+	blockLagGauge.Set(time.Since(time.UnixMilli(block.BlockHeader.RawData.Timestamp)).Seconds())
+
+	// A failing account fails the probe, the others are still reported.
+	success = true
+	for _, account := range params["account"] {
+		// An account that never received anything is answered with {}.
+		var acc struct {
+			Balance int64 `json:"balance"`
+		}
+		req := map[string]interface{}{"address": account, "visible": true}
+		if err := tronCall(ctx, client, target+"/wallet/getaccount", req, &acc); err != nil {
+			level.Error(logger).Log("msg", "getaccount failed, "+err.Error(), "account", account)
+			success = false
+			continue
+		}
+		balanceGaugeVec.WithLabelValues(account).Set(float64(acc.Balance) / sunPerTRX)
+	}
+	return success
+}
+
+// tronCall posts req, if any, to the TRON HTTP API at callURL and decodes
+// the response into result. The API reports errors in the Error field of a
+// 200 response.
+func tronCall(ctx context.Context, client *apiClient, callURL string, req interface{}, result interface{}) error {
+	var body io.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, callURL, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	respBody, err := client.do(httpReq)
+	if err != nil {
+		return err
+	}
+	var apiErr struct {
+		Error string `json:"Error"`
+	}
+	if err := json.Unmarshal(respBody, &apiErr); err != nil {
+		return err
+	}
+	if apiErr.Error != "" {
+		return fmt.Errorf("%s", apiErr.Error)
+	}
+	return json.Unmarshal(respBody, result)
+}
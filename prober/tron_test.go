@@ -0,0 +1,164 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+func TestTron(t *testing.T) {
+	const (
+		funded = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+		empty  = "TLa2f6VPqDgRE67v1736s7bJ8Ray5wYjU7"
+	)
+	blockTime := time.Now().Add(-30 * time.Second).UnixMilli()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		switch r.URL.Path {
+		case "/wallet/getnowblock":
+			fmt.Fprintf(w, `{"blockID":"0000000003b9aca0","block_header":{"raw_data":{"number":62500000,"txTrieRoot":"00","witness_address":"41","parentHash":"00","version":30,"timestamp":%d},"witness_signature":"00"}}`, blockTime)
+		case "/wallet/getaccount":
+			var req struct {
+				Address string `json:"address"`
+				Visible bool   `json:"visible"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Visible {
+				t.Errorf("unexpected getaccount request %+v: %v", req, err)
+			}
+			if req.Address == funded {
+				fmt.Fprintf(w, `{"address":%q,"balance":1234567890,"create_time":1600000000000}`, funded)
+			} else {
+				w.Write([]byte(`{}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	params := url.Values{"account": {funded, empty}}
+	if !ProbeTron(ctx, ts.URL, params, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger()) {
+		t.Fatalf("tron probe failed unexpectedly")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValues(mfs, "probe_tron_block_number", ""); got[""] != 62500000 {
+		t.Errorf("unexpected block number %v", got)
+	}
+	if got := gaugeValues(mfs, "probe_tron_block_lag_seconds", ""); got[""] < 30 || got[""] > 40 {
+		t.Errorf("unexpected block lag %v", got)
+	}
+	got := gaugeValues(mfs, "probe_tron_balance", "account")
+	if got[funded] != 1234.56789 {
+		t.Errorf("unexpected balance of %s: %v", funded, got)
+	}
+	if v, ok := got[empty]; !ok || v != 0 {
+		t.Errorf("expected a zero balance for %s, got %v", empty, got)
+	}
+}
+
+func TestTronError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Error":"class org.tron.core.exception.BadItemException : block not found"}`))
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if ProbeTron(ctx, ts.URL, url.Values{}, config.Module{Timeout: 5 * time.Second}, prometheus.NewRegistry(), log.NewNopLogger()) {
+		t.Errorf("expected the tron probe to fail on an API error")
+	}
+}
+
+func TestTronAccountError(t *testing.T) {
+	const (
+		funded = "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+		broken = "TLa2f6VPqDgRE67v1736s7bJ8Ray5wYjU7"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/wallet/getnowblock":
+			fmt.Fprintf(w, `{"block_header":{"raw_data":{"number":1,"timestamp":%d}}}`, time.Now().UnixMilli())
+		case "/wallet/getaccount":
+			var req struct {
+				Address string `json:"address"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Address == broken {
+				w.Write([]byte(`{"Error":"INVALID address"}`))
+				return
+			}
+			fmt.Fprintf(w, `{"address":%q,"balance":2000000}`, req.Address)
+		}
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	params := url.Values{"account": {broken, funded}}
+	if ProbeTron(ctx, ts.URL, params, config.Module{Timeout: 5 * time.Second}, registry, log.NewNopLogger()) {
+		t.Errorf("expected a failing account to fail the probe")
+	}
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValues(mfs, "probe_tron_balance", "account"); len(got) != 1 || got[funded] != 2 {
+		t.Errorf("expected the accounts after the failing one to be reported, got %v", got)
+	}
+}
+
+func TestTronHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("TRON-PRO-API-KEY") != "secret" {
+			http.Error(w, `{"Error":"ApiKey not exists"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"block_header":{"raw_data":{"number":1,"timestamp":%d}}}`, time.Now().UnixMilli())
+	}))
+	defer ts.Close()
+
+	for _, key := range []string{"", "secret"} {
+		module := config.Module{Timeout: 5 * time.Second}
+		if key != "" {
+			module.HTTP.Headers = map[string]string{"TRON-PRO-API-KEY": key}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result := ProbeTron(ctx, ts.URL, url.Values{}, module, prometheus.NewRegistry(), log.NewNopLogger())
+		cancel()
+		if result != (key != "") {
+			t.Errorf("api key %q: expected success %v, got %v", key, key != "", result)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2016 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+
+	"github.com/prometheus/blackbox_exporter/config"
+)
+
+// paramValidators check the params of a probe without running it, for the
+// validate=true mode of Handler. They return one message per invalid param.
+var paramValidators = map[string]func(params url.Values, module config.Module) []string{
+	"ethrpc":     validateETHRPCParams,
+	"json":       validateJSONParams,
+	"sui":        validateSuiParams,
+	"btcrpc":     validateBTCRPCParams,
+	"multichain": validateMultichainParams,
+	"consensus":  validateConsensusParams,
+	"tron":       validateTronParams,
+	"cosmos":     validateCosmosParams,
+	"solana":     validateSolanaParams,
+	"aptos":      validateAptosParams,
+	"grpc":       validateGRPCParams,
+}
+
+// validationReport is the answer of Handler in validate mode.
+type validationReport struct {
+	Module string `json:"module"`
+	Prober string `json:"prober"`
+	Target string `json:"target"`
+	// Checked is false for probers whose params are not validated.
+	Checked bool     `json:"checked"`
+	Valid   bool     `json:"valid"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func validateETHRPCParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if _, err := ethRPCHeaders(params, module); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := parseRPCRetryPolicy(params, module); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := rawMaxLengthParam(params); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if params.Get("block") != "" {
+		if _, err := parseBlockTag(params.Get("block")); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	m := params.Get("module")
+	required, ok := ethRPCRequiredParams[m]
+	if !ok {
+		return append(errs, "module '"+m+"' is not an ethrpc module")
+	}
+	for _, name := range required {
+		if len(params[name]) == 0 {
+			errs = append(errs, name+" param is missing")
+		}
+	}
+	if m == "contract_call" {
+		errs = append(errs, validateContractCallParams(params)...)
+	}
+	return errs
+}
+
+// ethRPCRequiredParams lists the modules of ProbeETHRPC with the params they
+// fail without. The call param of contract_call is checked with its specs.
+var ethRPCRequiredParams = map[string][]string{
+	"chain_info":               nil,
+	"gas_price":                nil,
+	"congestion":               nil,
+	"net_chain_check":          nil,
+	"ws_subscription_liveness": nil,
+	"logs_consistency":         nil,
+	"logs":                     {"address"},
+	"contract_gas":             {"contract"},
+	"estimate_gas":             {"call"},
+	"tx_receipt":               {"txhash"},
+	"balance":                  {"account"},
+	"nonce":                    {"account"},
+	"erc20balance":             {"account", "token"},
+	"transfer_tax":             {"token", "slot"},
+	"erc721balance":            {"account", "token"},
+	"storage":                  {"slot"},
+	"proxy_impl":               {"proxy"},
+	"ownership":                {"contract"},
+	"timelock":                 {"contract"},
+	"withdrawal_queue":         {"contract", "getter"},
+	"univ3_slot0":              {"pool"},
+	"chainlink":                {"feed"},
+	"pool_tvl":                 {"pool", "feed"},
+	"l2_output":                {"oracle"},
+	"staking_rewards":          {"contract", "account"},
+	"contract_call":            nil,
+}
+
+// validateContractCallParams checks the call specs of the contract_call
+// module and the expressions referring to their results.
+func validateContractCallParams(params url.Values) []string {
+	var errs []string
+	if len(params["call"]) == 0 {
+		errs = append(errs, "call param is missing")
+	}
+	var calls []ValidCallParam
+	for _, callParam := range params["call"] {
+		call, _, err := parseContractCall(callParam)
+		if err != nil {
+			errs = append(errs, "call '"+callParam+"' is not valid, "+err.Error())
+			continue
+		}
+		calls = append(calls, call)
+	}
+	if assert := params.Get("assert"); assert != "" {
+		if _, err := parseContractCallAssert(assert, calls); err != nil {
+			errs = append(errs, "assert expression '"+assert+"' is not valid, "+err.Error())
+		}
+	}
+	if scale := params.Get("scale"); scale != "" {
+		if _, err := parseExpr(scale, scaleExprVars); err != nil {
+			errs = append(errs, "scale expression '"+scale+"' is not valid, "+err.Error())
+		}
+	}
+	return errs
+}
+
+func validateJSONParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if params.Get("jmespath") == "" {
+		errs = append(errs, "jmespath param is missing")
+	}
+	errs = append(errs, validateJMESPathParams(params)...)
+	return errs
+}
+
+func validateSuiParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if _, err := ethRPCHeaders(params, module); err != nil {
+		errs = append(errs, err.Error())
+	}
+	return append(errs, validateJMESPathParams(params)...)
+}
+
+// validateJMESPathParams checks the decimals param and compiles every
+// expression of the jmespath param.
+func validateJMESPathParams(params url.Values) []string {
+	var errs []string
+	if _, err := decimalsParam(params); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if params.Get("jmespath") == "" {
+		return errs
+	}
+	for _, expression := range splitJMESPathList(params.Get("jmespath")) {
+		if _, err := jmespath.Compile(expression); err != nil {
+			errs = append(errs, "jmespath expression '"+expression+"' is not valid, "+err.Error())
+		}
+	}
+	return errs
+}
+
+func validateBTCRPCParams(params url.Values, module config.Module) []string {
+	var errs []string
+	switch m := params.Get("module"); m {
+	case "btc_chain_info", "btc_mempool_info", "btc_tip":
+	case "btc_fee_estimate":
+		if b := params.Get("blocks"); b != "" {
+			if n, err := strconv.Atoi(b); err != nil || n <= 0 {
+				errs = append(errs, "blocks '"+b+"' is not a valid confirmation target")
+			}
+		}
+	default:
+		errs = append(errs, "module '"+m+"' is not a btcrpc module")
+	}
+	return errs
+}
+
+func validateMultichainParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if _, err := ethRPCHeaders(params, module); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(params["chain"]) == 0 {
+		errs = append(errs, "chain param is missing")
+	}
+	for _, c := range params["chain"] {
+		if name, endpoint, ok := strings.Cut(c, ":"); !ok || name == "" || endpoint == "" {
+			errs = append(errs, "chain '"+c+"' is not valid, format: chainName:url")
+		}
+	}
+	return errs
+}
+
+func validateConsensusParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if _, err := ethRPCHeaders(params, module); err != nil {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+// validateTronParams checks that accounts are given in the base58 form the
+// probe asks the API for.
+func validateTronParams(params url.Values, module config.Module) []string {
+	var errs []string
+	for _, account := range params["account"] {
+		if len(account) != 34 || account[0] != 'T' {
+			errs = append(errs, "account '"+account+"' is not a base58 TRON address")
+		}
+	}
+	return errs
+}
+
+func validateCosmosParams(params url.Values, module config.Module) []string {
+	var errs []string
+	switch transport := params.Get("transport"); transport {
+	case "", "http", "grpc":
+	default:
+		errs = append(errs, "transport '"+transport+"' is not valid, must be http or grpc")
+	}
+	return errs
+}
+
+// validateSolanaParams checks that accounts are base58 public keys.
+func validateSolanaParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if _, err := ethRPCHeaders(params, module); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, account := range params["account"] {
+		if len(account) < 32 || len(account) > 44 || strings.Trim(account, base58Alphabet) != "" {
+			errs = append(errs, "account '"+account+"' is not a base58 Solana address")
+		}
+	}
+	return errs
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func validateAptosParams(params url.Values, module config.Module) []string {
+	var errs []string
+	if _, err := newAPIClient(module, "aptos_probe"); err != nil {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+func validateGRPCParams(params url.Values, module config.Module) []string {
+	var errs []string
+	switch t := params.Get("tls"); t {
+	case "", "true", "false":
+	default:
+		errs = append(errs, "tls '"+t+"' is not valid, must be true or false")
+	}
+	return errs
+}